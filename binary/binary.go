@@ -41,6 +41,14 @@ func (t Triad) Bump() Triad {
 	return (t % MaxTriad) + 1
 }
 
+// Before returns whether t comes before u as an index counter.
+// Indexes wrap around like Bump, so t is before u if it's less than half of the range behind u.
+func (t Triad) Before(u Triad) bool {
+	d := (u - t) % MaxTriad
+
+	return d != 0 && d < MaxTriad/2
+}
+
 func (t Triad) Add(d int) (result Triad) {
 	result = t + Triad(d)
 
@@ -12,8 +12,8 @@ package binary
 import (
 	"bytes"
 	"errors"
+	"net"
 	"strconv"
-	"strings"
 
 	"github.com/beito123/binary"
 	"github.com/beito123/go-raknet"
@@ -87,82 +87,166 @@ func (rs *RaknetStream) PutString(value string) error {
 	return rs.Put(b)
 }
 
+const (
+	// AddressFamilyIPv6 is AF_INET6 written in IPv6 addresses
+	// Raknet writes the value used on Windows.
+	AddressFamilyIPv6 = 23
+)
+
 // Address sets address got from Buffer to addr and port
-// address(version byte, address byte x4, port ushort)
+// IPv4: address(version byte, address byte x4, port ushort)
+// IPv6: address(version byte, family lshort, port ushort, flowinfo uint, address byte x16, scope id uint)
 func (rs *RaknetStream) Address() (addr string, port uint16, err error) {
-	ver, err := rs.Byte()
+	saddr, err := rs.AddressSystemAddress()
 	if err != nil {
 		return "", 0, err
 	}
 
-	if ver == 4 {
-		for i := 0; i < 4; i++ {
-			bytes, err := rs.Byte()
-			if err != nil {
-				return "", 0, err
-			}
+	return saddr.IP.String(), saddr.Port, nil
+}
 
-			addr := addr + strconv.Itoa(int(^bytes&0xff))
-			if i < 3 {
-				addr += "."
-			}
+// PutAddress puts address to Buffer
+// IPv4: address(version byte, address byte x4, port ushort)
+// IPv6: address(version byte, family lshort, port ushort, flowinfo uint, address byte x16, scope id uint)
+func (rs *RaknetStream) PutAddress(addr string, port uint16, version byte) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return errors.New("invalid ip address")
+	}
+
+	if version == 4 && ip.To4() == nil {
+		return errors.New("invalid IPv4 address")
+	}
+
+	return rs.putAddress(raknet.NewSystemAddressBytes(ip, port), version)
+}
+
+// AddressSystemAddress sets address got from Buffer to SystemAddress
+func (rs *RaknetStream) AddressSystemAddress() (*raknet.SystemAddress, error) {
+	ver, err := rs.Byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch ver {
+	case 4:
+		if rs.Len() < net.IPv4len {
+			return nil, binary.ErrNotEnought
+		}
+
+		ip := make(net.IP, net.IPv4len)
+		for i, b := range rs.Get(net.IPv4len) {
+			ip[i] = ^b & 0xff
 		}
 
-		port, err = rs.Short()
+		port, err := rs.Short()
 		if err != nil {
-			return "", 0, err
+			return nil, err
+		}
+
+		return raknet.NewSystemAddressBytes(ip, port), nil
+	case 6:
+		if rs.Len() < 2 {
+			return nil, binary.ErrNotEnought
+		}
+
+		rs.Get(2) // family
+
+		port, err := rs.Short()
+		if err != nil {
+			return nil, err
 		}
 
-		return addr, port, nil
-	} else {
-		// IPv6
+		_, err = rs.Int() // flowinfo
+		if err != nil {
+			return nil, err
+		}
+
+		if rs.Len() < net.IPv6len {
+			return nil, binary.ErrNotEnought
+		}
+
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, rs.Get(net.IPv6len))
+
+		scopeID, err := rs.Int()
+		if err != nil {
+			return nil, err
+		}
+
+		addr := raknet.NewSystemAddressBytes(ip, port)
+		addr.SetScopeID(uint32(scopeID))
+
+		return addr, nil
 	}
 
-	return "", 0, nil
+	return nil, errors.New("unknown ip address version: " + strconv.Itoa(int(ver)))
 }
 
-// PutAddress puts address to Buffer
-// address(version byte, address byte x4, port ushort)
-func (rs *RaknetStream) PutAddress(addr string, port uint16, version byte) error {
+// PutAddressSystemAddress puts address from UDPAddr to Buffer
+func (rs *RaknetStream) PutAddressSystemAddress(addr *raknet.SystemAddress) error {
+	return rs.putAddress(addr, byte(addr.Version()))
+}
+
+func (rs *RaknetStream) putAddress(addr *raknet.SystemAddress, version byte) error {
 	err := rs.PutByte(version)
 	if err != nil {
 		return err
 	}
 
-	if version == 4 {
-		for _, str := range strings.Split(addr, ".") {
-			i, _ := strconv.Atoi(str)
-			err = rs.PutByte(^byte(i) & 0xff)
+	switch version {
+	case 4:
+		ip := addr.IP.To4()
+		if ip == nil {
+			return errors.New("invalid IPv4 address")
+		}
+
+		for _, b := range ip {
+			err = rs.PutByte(^b & 0xff)
 			if err != nil {
 				return err
 			}
 		}
-		err = rs.PutShort(port)
+
+		err = rs.PutShort(addr.Port)
 		if err != nil {
 			return err
 		}
-	} else {
-		// ipv6
-	}
+	case 6:
+		ip := addr.IP.To16()
+		if ip == nil {
+			return errors.New("invalid IPv6 address")
+		}
 
-	return nil
-}
+		err = rs.Put([]byte{AddressFamilyIPv6 & 0xff, AddressFamilyIPv6 >> 8}) // little endian
+		if err != nil {
+			return err
+		}
 
-// AddressSystemAddress sets address got from Buffer to SystemAddress
-func (rs *RaknetStream) AddressSystemAddress() (*raknet.SystemAddress, error) {
-	addr, port, err := rs.Address()
-	if err != nil {
-		return nil, err
-	}
+		err = rs.PutShort(addr.Port)
+		if err != nil {
+			return err
+		}
 
-	naddr := raknet.NewSystemAddress(addr, port)
+		err = rs.PutInt(0) // flowinfo
+		if err != nil {
+			return err
+		}
 
-	return naddr, nil
-}
+		err = rs.Put(ip)
+		if err != nil {
+			return err
+		}
 
-// PutAddressSystemAddress puts address from UDPAddr to Buffer
-func (rs *RaknetStream) PutAddressSystemAddress(addr *raknet.SystemAddress) error {
-	return rs.PutAddress(addr.IP.String(), addr.Port, byte(addr.Version()))
+		err = rs.PutInt(int32(addr.ScopeID()))
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("unknown ip address version: " + strconv.Itoa(int(version)))
+	}
+
+	return nil
 }
 
 // UUID reads UUID
@@ -0,0 +1,339 @@
+// Package debug serves an admin HTTP/JSON endpoint of a live Raknet server
+//
+// The handler isn't protected, so mount it only on an admin port:
+//
+//	mux.Handle("/debug/raknet/", http.StripPrefix("/debug/raknet", debug.NewHandler(ser)))
+//
+// It serves:
+//
+//	GET  /sessions                 live sessions
+//	POST /sessions/kick            kicks a session (guid, reason)
+//	GET  /bans                     blocked addresses
+//	POST /bans/add                 blocks an address (ip, duration, reason)
+//	POST /bans/remove              unblocks an address (ip)
+//	GET  /server                   the server's config and status
+//	POST /server/broadcasting      enables or disables broadcasting (enabled)
+//
+// Parameters of actions are read from the query or a form body.
+// A duration is like "10m", and an empty duration blocks the address permanently.
+package debug
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/beito123/go-raknet/server"
+)
+
+var (
+	errNotRunning = errors.New("server isn't running")
+	errInvalidIP  = errors.New("invalid ip")
+)
+
+// NewHandler returns a handler serving the endpoint of the server
+func NewHandler(ser *server.Server) *Handler {
+	h := &Handler{
+		server: ser,
+		mux:    http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("/sessions", h.get(h.sessions))
+	h.mux.HandleFunc("/sessions/kick", h.post(h.kick))
+	h.mux.HandleFunc("/bans", h.get(h.bans))
+	h.mux.HandleFunc("/bans/add", h.post(h.addBan))
+	h.mux.HandleFunc("/bans/remove", h.post(h.removeBan))
+	h.mux.HandleFunc("/server", h.get(h.config))
+	h.mux.HandleFunc("/server/broadcasting", h.post(h.broadcasting))
+
+	return h
+}
+
+// Handler is an http.Handler of the debug endpoint
+type Handler struct {
+	server *server.Server
+	mux    *http.ServeMux
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Session is a live session
+type Session struct {
+	ID              uint64 `json:"id"`
+	Addr            string `json:"addr"`
+	GUID            int64  `json:"guid"`
+	State           string `json:"state"`
+	MTU             int    `json:"mtu"`
+	ProtocolVersion int    `json:"protocolVersion"`
+	RTT             string `json:"rtt"`
+	SendQueue       int    `json:"sendQueue"`
+	RecoveryQueue   int    `json:"recoveryQueue"`
+	Uptime          string `json:"uptime"`
+}
+
+// Ban is a blocked address
+type Ban struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+
+	// Expires is the time the address is unblocked, it's empty if it's permanent
+	Expires string `json:"expires,omitempty"`
+}
+
+// Config is the server's config and status
+type Config struct {
+	State                  string `json:"state"`
+	Uptime                 string `json:"uptime"`
+	Sessions               int    `json:"sessions"`
+	Handshaking            int    `json:"handshaking"`
+	BlockedAddresses       int    `json:"blockedAddresses"`
+	MaxConnections         int    `json:"maxConnections"`
+	MaxConnectionsPerIP    int    `json:"maxConnectionsPerIP"`
+	MaxHandshakingSessions int    `json:"maxHandshakingSessions"`
+	MTU                    int    `json:"mtu"`
+	NetworkProtocol        int    `json:"networkProtocol"`
+	NetworkProtocols       []int  `json:"networkProtocols,omitempty"`
+	BroadcastingEnabled    bool   `json:"broadcastingEnabled"`
+	MigrationEnabled       bool   `json:"migrationEnabled"`
+	ReconnectCooldown      string `json:"reconnectCooldown"`
+	HandshakeTimeout       string `json:"handshakeTimeout"`
+	Writers                int    `json:"writers"`
+	WriteQueueSize         int    `json:"writeQueueSize"`
+	BatchWrites            bool   `json:"batchWrites"`
+	BatchReads             bool   `json:"batchReads"`
+	Listeners              int    `json:"listeners"`
+	Shards                 int    `json:"shards"`
+}
+
+func (h *Handler) sessions(r *http.Request) (interface{}, error) {
+	sessions := []Session{}
+
+	err := h.server.RangeSessions(func(key string, session *server.Session) bool {
+		st := session.Stats()
+
+		sessions = append(sessions, Session{
+			ID:              session.ID,
+			Addr:            session.Addr().String(),
+			GUID:            session.GUID,
+			State:           session.State().String(),
+			MTU:             session.MTU,
+			ProtocolVersion: session.ProtocolVersion,
+			RTT:             st.RTTAvg.String(),
+			SendQueue:       st.SendQueueDepth,
+			RecoveryQueue:   st.RecoveryQueueDepth,
+			Uptime:          session.Uptime().String(),
+		})
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID < sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (h *Handler) kick(r *http.Request) (interface{}, error) {
+	guid, err := strconv.ParseInt(r.FormValue("guid"), 10, 64)
+	if err != nil {
+		return nil, httpError{http.StatusBadRequest, "invalid guid"}
+	}
+
+	err = h.server.CloseSessionGUID(guid, r.FormValue("reason"))
+	if err != nil {
+		return nil, httpError{http.StatusNotFound, err.Error()}
+	}
+
+	return nil, nil
+}
+
+func (h *Handler) bans(r *http.Request) (interface{}, error) {
+	bans := []Ban{}
+
+	for _, addr := range h.server.BlockedAddresses() {
+		ban := Ban{
+			IP:     addr.IP.String(),
+			Reason: addr.Reason,
+		}
+
+		if addr.Expire != nil && !addr.Expire.IsPermanent() {
+			ban.Expires = addr.Expire.Time.Add(addr.Expire.Duration).Format(time.RFC3339)
+		}
+
+		bans = append(bans, ban)
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].IP < bans[j].IP
+	})
+
+	return bans, nil
+}
+
+func (h *Handler) addBan(r *http.Request) (interface{}, error) {
+	ip := net.ParseIP(r.FormValue("ip"))
+	if ip == nil {
+		return nil, httpError{http.StatusBadRequest, errInvalidIP.Error()}
+	}
+
+	// The server sets the time with its clock
+	exp := &server.Expire{
+		Duration: server.PermanentExpire,
+	}
+
+	if len(r.FormValue("duration")) > 0 {
+		d, err := time.ParseDuration(r.FormValue("duration"))
+		if err != nil || d <= 0 {
+			return nil, httpError{http.StatusBadRequest, "invalid duration"}
+		}
+
+		exp.Duration = d
+	}
+
+	h.server.AddBlockedAddress(ip, exp, r.FormValue("reason"))
+
+	return nil, nil
+}
+
+func (h *Handler) removeBan(r *http.Request) (interface{}, error) {
+	ip := net.ParseIP(r.FormValue("ip"))
+	if ip == nil {
+		return nil, httpError{http.StatusBadRequest, errInvalidIP.Error()}
+	}
+
+	if !h.server.HasBlockedAddress(ip) {
+		return nil, httpError{http.StatusNotFound, "not blocked"}
+	}
+
+	h.server.RemoveBlockedAddress(ip)
+
+	return nil, nil
+}
+
+func (h *Handler) config(r *http.Request) (interface{}, error) {
+	ser := h.server
+
+	return &Config{
+		State:                  ser.State().String(),
+		Uptime:                 (time.Duration(ser.Timestamp()) * time.Millisecond).String(),
+		Sessions:               ser.Count(),
+		Handshaking:            ser.CountHandshaking(),
+		BlockedAddresses:       ser.CountBlockedAddresses(),
+		MaxConnections:         ser.MaxConnections,
+		MaxConnectionsPerIP:    ser.MaxConnectionsPerIP,
+		MaxHandshakingSessions: ser.MaxHandshakingSessions,
+		MTU:                    ser.MTU,
+		NetworkProtocol:        ser.NetworkProtocol,
+		NetworkProtocols:       ser.NetworkProtocols,
+		BroadcastingEnabled:    ser.IsBroadcastingEnabled(),
+		MigrationEnabled:       ser.MigrationEnabled,
+		ReconnectCooldown:      ser.ReconnectCooldown.String(),
+		HandshakeTimeout:       ser.HandshakeTimeout.String(),
+		Writers:                ser.Writers,
+		WriteQueueSize:         ser.WriteQueueSize,
+		BatchWrites:            ser.BatchWrites,
+		BatchReads:             ser.BatchReads,
+		Listeners:              ser.Listeners,
+		Shards:                 ser.Shards,
+	}, nil
+}
+
+func (h *Handler) broadcasting(r *http.Request) (interface{}, error) {
+	enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+	if err != nil {
+		return nil, httpError{http.StatusBadRequest, "invalid enabled"}
+	}
+
+	h.server.SetBroadcastingEnabled(enabled)
+
+	return nil, nil
+}
+
+// endpoint returns a result written as JSON
+// Actions return a nil result, then {"ok":true} is written.
+type endpoint func(r *http.Request) (interface{}, error)
+
+// httpError is an error with a status code
+type httpError struct {
+	status  int
+	message string
+}
+
+func (err httpError) Error() string {
+	return err.message
+}
+
+func (h *Handler) get(f endpoint) http.HandlerFunc {
+	return h.handle(http.MethodGet, f)
+}
+
+func (h *Handler) post(f endpoint) http.HandlerFunc {
+	return h.handle(http.MethodPost, f)
+}
+
+func (h *Handler) handle(method string, f endpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, httpError{http.StatusMethodNotAllowed, "method not allowed"})
+			return
+		}
+
+		// Sessions and bans are made when the server is started
+		if h.server.State() == server.StateNew {
+			writeError(w, httpError{http.StatusServiceUnavailable, errNotRunning.Error()})
+			return
+		}
+
+		result, err := f(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if result == nil {
+			result = map[string]bool{"ok": true}
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	herr, ok := err.(httpError)
+	if ok {
+		status = herr.status
+	}
+
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
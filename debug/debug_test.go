@@ -0,0 +1,162 @@
+package debug_test
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/debug"
+	"github.com/beito123/go-raknet/identifier"
+	"github.com/beito123/go-raknet/raknettest"
+	"github.com/beito123/go-raknet/server"
+)
+
+// serve returns the debug handler of a server with a connected client on the network
+func serve(t *testing.T, network *raknettest.Network) (*debug.Handler, *raknettest.Client) {
+	t.Helper()
+
+	ser := &server.Server{
+		MaxConnections: 10,
+		Identifier:     identifier.Base{Connection: raknet.ConnectionGoRaknet},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	err := network.Serve(ctx, ser, "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := network.Dial("10.0.0.2:5000", "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { client.Close() })
+
+	for i := 0; i < 100 && !client.Connected(); i++ {
+		network.Advance(100 * time.Millisecond)
+	}
+
+	if !client.Connected() {
+		t.Fatal("the client didn't connect")
+	}
+
+	return debug.NewHandler(ser), client
+}
+
+// request serves the request, and decodes the JSON result to v
+func request(t *testing.T, h http.Handler, method string, target string, v interface{}) {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, nil)
+	if method == http.MethodPost {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s %s: %d %s", method, target, w.Code, strings.TrimSpace(w.Body.String()))
+	}
+
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	h, _ := serve(t, network)
+
+	// Sessions are listed while clients connect and disconnect
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 10; i++ {
+			client, err := network.Dial(fmt.Sprintf("10.0.0.%d:5000", i+3), "10.0.0.1:19132")
+			if err != nil {
+				return
+			}
+
+			for j := 0; j < 10 && !client.Connected(); j++ {
+				network.Advance(10 * time.Millisecond)
+			}
+
+			client.Close()
+			network.Advance(10 * time.Millisecond)
+		}
+	}()
+
+	states := map[string]bool{
+		server.StateHandshaking.String(): true,
+		server.StateConnected.String():   true,
+		server.StateDisconected.String(): true,
+	}
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		var sessions []debug.Session
+		request(t, h, http.MethodGet, "/sessions", &sessions)
+
+		if len(sessions) == 0 || sessions[0].Addr != "10.0.0.2:5000" {
+			t.Fatalf("sessions %+v, want the first client", sessions)
+		}
+
+		for _, session := range sessions {
+			if !states[session.State] {
+				t.Fatalf("the session is %s", session.State)
+			}
+		}
+	}
+}
+
+func TestBanExpiry(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	h, _ := serve(t, network)
+
+	var result map[string]bool
+	request(t, h, http.MethodPost, "/bans/add?ip=10.0.0.3&duration=1s&reason=test", &result)
+
+	var bans []debug.Ban
+	request(t, h, http.MethodGet, "/bans", &bans)
+
+	if len(bans) != 1 || bans[0].IP != "10.0.0.3" || bans[0].Expires == "" {
+		t.Fatalf("bans %+v", bans)
+	}
+
+	// The ban expires on the server's clock
+	network.Advance(2 * time.Second)
+
+	request(t, h, http.MethodGet, "/bans", &bans)
+
+	if len(bans) != 0 {
+		t.Fatalf("bans %+v after the ban expired", bans)
+	}
+}
@@ -9,12 +9,14 @@ import (
 	"time"
 
 	"github.com/beito123/go-raknet/protocol"
+	"github.com/beito123/go-raknet/server"
 	"github.com/davecgh/go-spew/spew"
 
 	raknet "github.com/beito123/go-raknet"
 )
 
 // MonitorHandler is a simple monitor handler
+// It implements the handlers it needs, and isn't notified of sessions in the handshake.
 type MonitorHandler struct {
 	MonitorIP net.IP
 	Path      string
@@ -70,10 +72,6 @@ func (hand *MonitorHandler) HandlePing(addr net.Addr) {
 	}
 }
 
-// OpenedPreConn is called before a new session is created
-func (MonitorHandler) OpenedPreConn(addr net.Addr) {
-}
-
 // OpenConn is called on a new session is created
 func (hand *MonitorHandler) OpenedConn(uid int64, addr net.Addr) {
 	if hand.IsTargetAddr(addr) {
@@ -83,14 +81,27 @@ func (hand *MonitorHandler) OpenedConn(uid int64, addr net.Addr) {
 	}
 }
 
-// ClosePreConn is called before a session is closed
-func (MonitorHandler) ClosedPreConn(uid int64) {
+// RejectedConn is called when a new connection is rejected
+func (hand *MonitorHandler) RejectedConn(addr net.Addr, reason server.RejectReason) {
+	if hand.IsTargetAddr(addr) {
+		hand.out <- "# Rejected the connection from the monitor target (" + reason.String() + ")\n\n"
+	}
+}
+
+// MigratedConn is called when a session moved to a new address
+func (hand *MonitorHandler) MigratedConn(uid int64, from net.Addr, to net.Addr) {
+	if hand.IsTarget(uid) {
+		hand.out <- "# The monitor target moved from " + from.String() + " to " + to.String() + "\n\n"
+
+		hand.targets[uid] = to
+	}
 }
 
 // CloseConn is called on a session is closed
-func (hand *MonitorHandler) ClosedConn(uid int64) {
+func (hand *MonitorHandler) ClosedConn(uid int64, reason server.DisconnectReason, message string) {
 	if hand.IsTarget(uid) {
-		hand.out <- "# Disconnected the monitor target connected from " + hand.targets[uid].String() + "\n\n"
+		hand.out <- "# Disconnected the monitor target connected from " + hand.targets[uid].String() +
+			" (" + reason.String() + ": " + message + ")\n\n"
 
 		delete(hand.targets, uid)
 	}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"net"
 	"os"
@@ -11,6 +12,8 @@ import (
 	"github.com/beito123/binary"
 	"github.com/beito123/go-raknet"
 	"github.com/beito123/go-raknet/identifier"
+	"github.com/beito123/go-raknet/log/logrusadapter"
+	"github.com/beito123/go-raknet/pcap"
 	"github.com/satori/go.uuid"
 
 	"github.com/beito123/go-raknet/server"
@@ -23,14 +26,18 @@ func main() {
 		proto         int
 		maxConnection int
 		monitor       string
+		capture       string
+		trace         string
 		help          bool
 	)
 
-	//Command: goraknet -p <server port> -P <mcpe protocol> -m <max connections> -M <ip addr>
+	//Command: goraknet -p <server port> -P <mcpe protocol> -m <max connections> -M <ip addr> -C <pcap file> -T <trace file>
 	flag.IntVar(&port, "p", 19132, "a server port")
 	flag.IntVar(&proto, "P", 291, "mcpe protocol number") // mcpe v1. proto:291
 	flag.IntVar(&maxConnection, "m", 10, "max connections")
 	flag.StringVar(&monitor, "M", "", "monitor ip")
+	flag.StringVar(&capture, "C", "", "pcap file to capture datagrams")
+	flag.StringVar(&trace, "T", "", "file to trace packets as JSON lines")
 	flag.BoolVar(&help, "help", false, "help")
 	flag.Parse()
 
@@ -50,6 +57,8 @@ func main() {
 		logger.Info("-P: set mcbe protocol number")
 		logger.Info("-m: set server's max connections. (default: 10)")
 		logger.Info("-M: set server's IP address")
+		logger.Info("-C: capture datagrams to a pcap file, rotated every 100MB")
+		logger.Info("-T: trace decoded packets to a file as JSON lines")
 		return
 	}
 
@@ -75,7 +84,7 @@ func main() {
 	}
 
 	ser := &server.Server{
-		Logger:              logger,
+		Logger:              logrusadapter.New(logger),
 		MaxConnections:      maxConnection,
 		MTU:                 2048, //Minecraft default MTU
 		Identifier:          id,
@@ -97,10 +106,46 @@ func main() {
 		})
 	}
 
+	if len(trace) > 0 {
+		file, err := os.Create(trace)
+		if err != nil {
+			logger.Fatal(err)
+			return
+		}
+
+		defer file.Close()
+
+		ser.Tracer = server.NewJSONTracer(file)
+	}
+
 	logger.Info("Starting the server...")
 	logger.Debug("address: 0.0.0.0:" + strconv.Itoa(port))
 
-	go ser.Start("0.0.0.0", port)
+	if len(capture) > 0 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			logger.Fatal(err)
+			return
+		}
+
+		w, err := pcap.Create(capture, 100<<20)
+		if err != nil {
+			logger.Fatal(err)
+			return
+		}
+
+		defer w.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ser.SetCancel(cancel)
+
+		go ser.Serve(ctx, &pcap.Conn{
+			PacketConn: conn,
+			Writer:     w,
+		})
+	} else {
+		go ser.Start("0.0.0.0", port)
+	}
 
 	logger.Info("Enter to stop the server")
 
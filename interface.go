@@ -1,5 +1,7 @@
 package raknet
 
+import "time"
+
 /*
  * go-raknet
  *
@@ -13,12 +15,51 @@ package raknet
 	Logger
 */
 
-// Logger is a logger interface supported
+// Logger is a structured and leveled logger
+// fields are pairs of a key and a value, such as "addr", addr.
+// Adapters for log/slog (Go 1.21 or later), logrus and zap are in the log directory.
 type Logger interface {
-	Info(msg ...interface{})
-	Warn(msg ...interface{})
-	Fatal(msg ...interface{})
-	Debug(msg ...interface{})
+
+	// Debug logs a message for debugging
+	Debug(msg string, fields ...interface{})
+
+	// Info logs a message
+	Info(msg string, fields ...interface{})
+
+	// Warn logs a warning
+	Warn(msg string, fields ...interface{})
+
+	// Error logs an error
+	Error(msg string, fields ...interface{})
+
+	// With returns a logger adding the fields to all messages
+	With(fields ...interface{}) Logger
+
+	// Enabled returns whether messages of the level are logged
+	// It's used to skip building messages for every packet.
+	Enabled(level LogLevel) bool
+}
+
+/*
+	Clock
+*/
+
+// Clock is a source of the current time
+type Clock interface {
+
+	// Now returns the current time
+	Now() time.Time
+}
+
+// Scheduler is a Clock running functions after durations on the clock
+// A server with a Scheduler as its Clock updates sessions with it instead of a ticker,
+// so a virtual clock decides when updates run, such as raknettest.Clock.
+type Scheduler interface {
+	Clock
+
+	// AfterFunc calls f once after d passed on the clock
+	// f may be called in the goroutine moving the clock, so it mustn't block.
+	AfterFunc(d time.Duration, f func())
 }
 
 /*
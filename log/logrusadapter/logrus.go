@@ -0,0 +1,114 @@
+// Package logrusadapter adapts a logrus logger to raknet.Logger
+package logrusadapter
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	raknet "github.com/beito123/go-raknet"
+)
+
+// New returns a raknet.Logger logging to the logger
+func New(logger *logrus.Logger) raknet.Logger {
+	return NewEntry(logrus.NewEntry(logger))
+}
+
+// NewEntry returns a raknet.Logger logging to the entry with its fields
+func NewEntry(entry *logrus.Entry) raknet.Logger {
+	return &Logger{
+		entry: entry,
+	}
+}
+
+// Logger is a raknet.Logger logging to a logrus.Entry
+type Logger struct {
+	entry *logrus.Entry
+}
+
+func (logger *Logger) Debug(msg string, fields ...interface{}) {
+	if logger.Enabled(raknet.LevelDebug) {
+		logger.entry.WithFields(Fields(fields)).Debug(msg)
+	}
+}
+
+func (logger *Logger) Info(msg string, fields ...interface{}) {
+	if logger.Enabled(raknet.LevelInfo) {
+		logger.entry.WithFields(Fields(fields)).Info(msg)
+	}
+}
+
+func (logger *Logger) Warn(msg string, fields ...interface{}) {
+	if logger.Enabled(raknet.LevelWarn) {
+		logger.entry.WithFields(Fields(fields)).Warn(msg)
+	}
+}
+
+func (logger *Logger) Error(msg string, fields ...interface{}) {
+	if logger.Enabled(raknet.LevelError) {
+		logger.entry.WithFields(Fields(fields)).Error(msg)
+	}
+}
+
+func (logger *Logger) With(fields ...interface{}) raknet.Logger {
+	return &Logger{
+		entry: logger.entry.WithFields(Fields(fields)),
+	}
+}
+
+func (logger *Logger) Enabled(level raknet.LogLevel) bool {
+	// Logger.Level is changed atomically by SetLevel
+	current := logrus.Level(atomic.LoadUint32((*uint32)(&logger.entry.Logger.Level)))
+
+	return current >= Level(level)
+}
+
+// Entry returns the logrus.Entry
+func (logger *Logger) Entry() *logrus.Entry {
+	return logger.entry
+}
+
+// Level returns the logrus.Level of the level
+func Level(level raknet.LogLevel) logrus.Level {
+	switch level {
+	case raknet.LevelDebug:
+		return logrus.DebugLevel
+	case raknet.LevelInfo:
+		return logrus.InfoLevel
+	case raknet.LevelWarn:
+		return logrus.WarnLevel
+	}
+
+	return logrus.ErrorLevel
+}
+
+// Fields returns logrus.Fields of pairs of a key and a value
+// Keys not string are formatted with fmt.Sprint, and the value without a key is set to "!BADKEY" like log/slog.
+func Fields(fields []interface{}) logrus.Fields {
+	result := make(logrus.Fields, len(fields)/2)
+
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 >= len(fields) {
+			result["!BADKEY"] = fields[i]
+			break
+		}
+
+		key, ok := fields[i].(string)
+		if !ok {
+			key = fmt.Sprint(fields[i])
+		}
+
+		result[key] = fields[i+1]
+	}
+
+	return result
+}
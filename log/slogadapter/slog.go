@@ -0,0 +1,85 @@
+//go:build go1.21
+// +build go1.21
+
+// Package slogadapter adapts a log/slog logger to raknet.Logger
+//
+// It needs Go 1.21 or later for log/slog.
+package slogadapter
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"log/slog"
+
+	raknet "github.com/beito123/go-raknet"
+)
+
+// New returns a raknet.Logger logging to the logger
+// if logger is nil, slog.Default() is used.
+func New(logger *slog.Logger) raknet.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Logger{
+		logger: logger,
+	}
+}
+
+// Logger is a raknet.Logger logging to a slog.Logger
+type Logger struct {
+	logger *slog.Logger
+}
+
+func (logger *Logger) Debug(msg string, fields ...interface{}) {
+	logger.logger.Log(context.Background(), slog.LevelDebug, msg, fields...)
+}
+
+func (logger *Logger) Info(msg string, fields ...interface{}) {
+	logger.logger.Log(context.Background(), slog.LevelInfo, msg, fields...)
+}
+
+func (logger *Logger) Warn(msg string, fields ...interface{}) {
+	logger.logger.Log(context.Background(), slog.LevelWarn, msg, fields...)
+}
+
+func (logger *Logger) Error(msg string, fields ...interface{}) {
+	logger.logger.Log(context.Background(), slog.LevelError, msg, fields...)
+}
+
+func (logger *Logger) With(fields ...interface{}) raknet.Logger {
+	return &Logger{
+		logger: logger.logger.With(fields...),
+	}
+}
+
+func (logger *Logger) Enabled(level raknet.LogLevel) bool {
+	return logger.logger.Enabled(context.Background(), Level(level))
+}
+
+// Slog returns the slog.Logger
+func (logger *Logger) Slog() *slog.Logger {
+	return logger.logger
+}
+
+// Level returns the slog.Level of the level
+func Level(level raknet.LogLevel) slog.Level {
+	switch level {
+	case raknet.LevelDebug:
+		return slog.LevelDebug
+	case raknet.LevelInfo:
+		return slog.LevelInfo
+	case raknet.LevelWarn:
+		return slog.LevelWarn
+	}
+
+	return slog.LevelError
+}
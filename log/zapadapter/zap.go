@@ -0,0 +1,75 @@
+// Package zapadapter adapts a zap logger to raknet.Logger
+package zapadapter
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	raknet "github.com/beito123/go-raknet"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a raknet.Logger logging to the logger
+// Callers are reported as callers of the adapter.
+func New(logger *zap.Logger) raknet.Logger {
+	return &Logger{
+		logger: logger.WithOptions(zap.AddCallerSkip(1)).Sugar(),
+	}
+}
+
+// Logger is a raknet.Logger logging to a zap.SugaredLogger
+type Logger struct {
+	logger *zap.SugaredLogger
+}
+
+func (logger *Logger) Debug(msg string, fields ...interface{}) {
+	logger.logger.Debugw(msg, fields...)
+}
+
+func (logger *Logger) Info(msg string, fields ...interface{}) {
+	logger.logger.Infow(msg, fields...)
+}
+
+func (logger *Logger) Warn(msg string, fields ...interface{}) {
+	logger.logger.Warnw(msg, fields...)
+}
+
+func (logger *Logger) Error(msg string, fields ...interface{}) {
+	logger.logger.Errorw(msg, fields...)
+}
+
+func (logger *Logger) With(fields ...interface{}) raknet.Logger {
+	return &Logger{
+		logger: logger.logger.With(fields...),
+	}
+}
+
+func (logger *Logger) Enabled(level raknet.LogLevel) bool {
+	return logger.logger.Desugar().Core().Enabled(Level(level))
+}
+
+// Zap returns the zap.SugaredLogger
+func (logger *Logger) Zap() *zap.SugaredLogger {
+	return logger.logger
+}
+
+// Level returns the zapcore.Level of the level
+func Level(level raknet.LogLevel) zapcore.Level {
+	switch level {
+	case raknet.LevelDebug:
+		return zapcore.DebugLevel
+	case raknet.LevelInfo:
+		return zapcore.InfoLevel
+	case raknet.LevelWarn:
+		return zapcore.WarnLevel
+	}
+
+	return zapcore.ErrorLevel
+}
@@ -0,0 +1,135 @@
+package raknet
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// LogLevel is a level of log messages
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (level LogLevel) String() string {
+	switch level {
+	case LevelDebug:
+		return "Debug"
+	case LevelInfo:
+		return "Info"
+	case LevelWarn:
+		return "Warn"
+	case LevelError:
+		return "Error"
+	}
+
+	return "Unknown"
+}
+
+// NopLogger is a logger discarding all messages
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, fields ...interface{}) {}
+func (NopLogger) Info(msg string, fields ...interface{})  {}
+func (NopLogger) Warn(msg string, fields ...interface{})  {}
+func (NopLogger) Error(msg string, fields ...interface{}) {}
+
+func (logger NopLogger) With(fields ...interface{}) Logger {
+	return logger
+}
+
+func (NopLogger) Enabled(level LogLevel) bool {
+	return false
+}
+
+// NewRateLimitedLogger returns a logger logging at most limit warnings and errors per interval
+// Messages over the limit are dropped, and the number of them is added to the next message logged.
+// Loggers returned by With share the limit. Debug and info messages aren't limited.
+func NewRateLimitedLogger(logger Logger, limit int, interval time.Duration) Logger {
+	return &rateLimitedLogger{
+		Logger: logger,
+		limiter: &logLimiter{
+			limit:    limit,
+			interval: interval,
+		},
+	}
+}
+
+type rateLimitedLogger struct {
+	Logger
+
+	limiter *logLimiter
+}
+
+func (logger *rateLimitedLogger) Warn(msg string, fields ...interface{}) {
+	fields, ok := logger.limiter.allow(fields)
+	if ok {
+		logger.Logger.Warn(msg, fields...)
+	}
+}
+
+func (logger *rateLimitedLogger) Error(msg string, fields ...interface{}) {
+	fields, ok := logger.limiter.allow(fields)
+	if ok {
+		logger.Logger.Error(msg, fields...)
+	}
+}
+
+func (logger *rateLimitedLogger) With(fields ...interface{}) Logger {
+	return &rateLimitedLogger{
+		Logger:  logger.Logger.With(fields...),
+		limiter: logger.limiter,
+	}
+}
+
+// logLimiter counts messages logged in the current interval
+type logLimiter struct {
+	mutex    sync.Mutex
+	limit    int
+	interval time.Duration
+	start    time.Time
+	count    int
+	dropped  int
+}
+
+// allow returns whether a message can be logged now
+// If messages were dropped before it, their number is added to the fields.
+func (limiter *logLimiter) allow(fields []interface{}) ([]interface{}, bool) {
+	now := time.Now()
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if now.Sub(limiter.start) >= limiter.interval {
+		limiter.start = now
+		limiter.count = 0
+	}
+
+	if limiter.count >= limiter.limit {
+		limiter.dropped++
+		return fields, false
+	}
+
+	limiter.count++
+
+	if limiter.dropped > 0 {
+		// Copies the fields not to overwrite the caller's slice
+		fields = append(fields[:len(fields):len(fields)], "dropped", limiter.dropped)
+		limiter.dropped = 0
+	}
+
+	return fields, true
+}
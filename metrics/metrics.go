@@ -0,0 +1,232 @@
+// Package metrics exports metrics of a Raknet server to Prometheus
+//
+// Metrics are attached to a server with Register before the server is started:
+//
+//	m, err := metrics.Register(ser, prometheus.DefaultRegisterer)
+//
+// and served with promhttp like other collectors.
+package metrics
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/server"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace is the prefix of metric names
+const Namespace = "raknet"
+
+// RTTBuckets is buckets of the RTT histogram in seconds
+var RTTBuckets = []float64{.005, .01, .025, .05, .075, .1, .15, .2, .3, .5, 1, 2}
+
+// Register attaches new metrics to the server, and registers them to reg
+// It must be called before the server is started, as it adds a handler to the server.
+func Register(ser *server.Server, reg prometheus.Registerer) (*Metrics, error) {
+	m := New(ser)
+
+	err := reg.Register(m)
+	if err != nil {
+		return nil, err
+	}
+
+	ser.Handlers = append(ser.Handlers, m.Handler())
+
+	return m, nil
+}
+
+// New returns new metrics of the server
+// Metrics of events, such as rejections, bans and RTTs, are counted by Handler,
+// and others are read from the server when they're collected.
+func New(ser *server.Server) *Metrics {
+	return &Metrics{
+		server: ser,
+
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "rejections_total",
+			Help:      "Number of rejected connections by reason.",
+		}, []string{"reason"}),
+		bans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "bans_total",
+			Help:      "Number of blocked addresses added.",
+		}),
+		rtt: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "rtt_seconds",
+			Help:      "Round-trip times to clients measured with pings.",
+			Buckets:   RTTBuckets,
+		}),
+
+		sessions:           newDesc("sessions", "Number of connected sessions.", nil),
+		handshakes:         newDesc("handshakes", "Number of sessions in the handshake.", nil),
+		blockedAddresses:   newDesc("blocked_addresses", "Number of blocked addresses.", nil),
+		packets:            newDesc("packets_total", "Number of datagrams by direction and packet ID.", []string{"direction", "id"}),
+		bytes:              newDesc("bytes_total", "Number of bytes of datagrams by direction and packet ID.", []string{"direction", "id"}),
+		messages:           newDesc("messages_total", "Number of messages by direction and reliability.", []string{"direction", "reliability"}),
+		retransmissions:    newDesc("retransmissions_total", "Number of datagrams resent.", nil),
+		nacks:              newDesc("nacks_total", "Number of NACK packets by direction.", []string{"direction"}),
+		duplicates:         newDesc("duplicates_dropped_total", "Number of duplicated reliable messages dropped.", nil),
+		splits:             newDesc("splits_reassembled_total", "Number of split messages reassembled.", nil),
+		sendQueueDepth:     newDesc("send_queue_depth", "Number of messages waiting to be sent.", nil),
+		recoveryQueueDepth: newDesc("recovery_queue_depth", "Number of datagrams waiting for ACKs.", nil),
+	}
+}
+
+func newDesc(name string, help string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", name), help, labels, nil)
+}
+
+// Metrics is a prometheus.Collector of a server
+type Metrics struct {
+	server *server.Server
+
+	rejections *prometheus.CounterVec
+	bans       prometheus.Counter
+	rtt        prometheus.Histogram
+
+	sessions           *prometheus.Desc
+	handshakes         *prometheus.Desc
+	blockedAddresses   *prometheus.Desc
+	packets            *prometheus.Desc
+	bytes              *prometheus.Desc
+	messages           *prometheus.Desc
+	retransmissions    *prometheus.Desc
+	nacks              *prometheus.Desc
+	duplicates         *prometheus.Desc
+	splits             *prometheus.Desc
+	sendQueueDepth     *prometheus.Desc
+	recoveryQueueDepth *prometheus.Desc
+}
+
+// Handler returns a handler counting events of the server
+// Register adds it to the server, so it's needed only with New.
+func (m *Metrics) Handler() server.Handler {
+	return &handler{
+		metrics: m,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.rejections.Describe(ch)
+	m.bans.Describe(ch)
+	m.rtt.Describe(ch)
+
+	ch <- m.sessions
+	ch <- m.handshakes
+	ch <- m.blockedAddresses
+	ch <- m.packets
+	ch <- m.bytes
+	ch <- m.messages
+	ch <- m.retransmissions
+	ch <- m.nacks
+	ch <- m.duplicates
+	ch <- m.splits
+	ch <- m.sendQueueDepth
+	ch <- m.recoveryQueueDepth
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.rejections.Collect(ch)
+	m.bans.Collect(ch)
+	m.rtt.Collect(ch)
+
+	// The server isn't started yet
+	if m.server.State() == server.StateNew {
+		return
+	}
+
+	handshakes := m.server.CountHandshaking()
+
+	ch <- prometheus.MustNewConstMetric(m.sessions, prometheus.GaugeValue, float64(m.server.Count()-handshakes))
+	ch <- prometheus.MustNewConstMetric(m.handshakes, prometheus.GaugeValue, float64(handshakes))
+	ch <- prometheus.MustNewConstMetric(m.blockedAddresses, prometheus.GaugeValue, float64(m.server.CountBlockedAddresses()))
+
+	received, sent := m.server.PacketCounts()
+	m.collectPackets(ch, "in", received)
+	m.collectPackets(ch, "out", sent)
+
+	stats := m.server.Stats()
+
+	for i := 0; i < server.NumReliabilities; i++ {
+		reliability := raknet.Reliability(i).String()
+
+		if stats.MessagesReceived[i] > 0 {
+			ch <- prometheus.MustNewConstMetric(m.messages, prometheus.CounterValue, float64(stats.MessagesReceived[i]), "in", reliability)
+		}
+
+		if stats.MessagesSent[i] > 0 {
+			ch <- prometheus.MustNewConstMetric(m.messages, prometheus.CounterValue, float64(stats.MessagesSent[i]), "out", reliability)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(m.retransmissions, prometheus.CounterValue, float64(stats.Retransmissions))
+	ch <- prometheus.MustNewConstMetric(m.nacks, prometheus.CounterValue, float64(stats.NACKsReceived), "in")
+	ch <- prometheus.MustNewConstMetric(m.nacks, prometheus.CounterValue, float64(stats.NACKsSent), "out")
+	ch <- prometheus.MustNewConstMetric(m.duplicates, prometheus.CounterValue, float64(stats.DuplicatesDropped))
+	ch <- prometheus.MustNewConstMetric(m.splits, prometheus.CounterValue, float64(stats.SplitsReassembled))
+	ch <- prometheus.MustNewConstMetric(m.sendQueueDepth, prometheus.GaugeValue, float64(stats.SendQueueDepth))
+	ch <- prometheus.MustNewConstMetric(m.recoveryQueueDepth, prometheus.GaugeValue, float64(stats.RecoveryQueueDepth))
+}
+
+// collectPackets collects counts of packet IDs seen at least once
+func (m *Metrics) collectPackets(ch chan<- prometheus.Metric, direction string, counts [256]server.PacketCount) {
+	for id, count := range counts {
+		if count.Datagrams == 0 {
+			continue
+		}
+
+		label := packetIDs[id]
+
+		ch <- prometheus.MustNewConstMetric(m.packets, prometheus.CounterValue, float64(count.Datagrams), direction, label)
+		ch <- prometheus.MustNewConstMetric(m.bytes, prometheus.CounterValue, float64(count.Bytes), direction, label)
+	}
+}
+
+// packetIDs is labels of packet IDs, such as "0x84"
+var packetIDs [256]string
+
+func init() {
+	for i := range packetIDs {
+		packetIDs[i] = fmt.Sprintf("0x%02x", i)
+	}
+}
+
+// handler counts events of the server
+type handler struct {
+	metrics *Metrics
+}
+
+// RejectedConn implements server.RejectHandler
+func (hand *handler) RejectedConn(addr net.Addr, reason server.RejectReason) {
+	hand.metrics.rejections.WithLabelValues(reason.String()).Inc()
+}
+
+// AddedBlockedAddress implements server.AddedBlockedAddressHandler
+func (hand *handler) AddedBlockedAddress(ip net.IP, reason string) {
+	hand.metrics.bans.Inc()
+}
+
+// RemovedBlockedAddress implements server.RemovedBlockedAddressHandler
+func (hand *handler) RemovedBlockedAddress(ip net.IP) {
+}
+
+// MeasuredRTT implements server.RTTHandler
+func (hand *handler) MeasuredRTT(uid int64, rtt time.Duration) {
+	hand.metrics.rtt.Observe(rtt.Seconds())
+}
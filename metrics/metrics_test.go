@@ -0,0 +1,143 @@
+package metrics_test
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/identifier"
+	"github.com/beito123/go-raknet/metrics"
+	"github.com/beito123/go-raknet/raknettest"
+	"github.com/beito123/go-raknet/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrape returns the metrics served at the url
+func scrape(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("scraped with %d: %s", resp.StatusCode, b)
+	}
+
+	return string(b)
+}
+
+func TestScrape(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser := &server.Server{
+		MaxConnections: 1,
+		Identifier:     identifier.Base{Connection: raknet.ConnectionGoRaknet},
+	}
+
+	reg := prometheus.NewRegistry()
+
+	_, err := metrics.Register(ser, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer hs.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = network.Serve(ctx, ser, "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := network.Dial("10.0.0.2:5000", "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	// The client measures RTTs with pings, while metrics are scraped
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		network.Advance(2 * raknet.PingSendInterval)
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		scrape(t, hs.URL)
+	}
+
+	if !client.Connected() {
+		t.Fatal("the client didn't connect")
+	}
+
+	// The second client is over MaxConnections
+	rejected, err := network.Dial("10.0.0.3:5000", "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rejected.Close()
+
+	network.Advance(time.Second)
+
+	ser.AddBlockedAddress(net.IPv4(10, 0, 0, 2), &server.Expire{Duration: server.PermanentExpire}, "test")
+
+	network.Advance(100 * time.Millisecond)
+
+	body := scrape(t, hs.URL)
+
+	for _, line := range []string{
+		`raknet_rejections_total{reason="NoFreeIncomingConnections"} 1`,
+		`raknet_bans_total 1`,
+		`raknet_blocked_addresses 1`,
+		`raknet_sessions 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("%q isn't scraped", line)
+		}
+	}
+
+	if strings.Contains(body, "raknet_rtt_seconds_count 0\n") || !strings.Contains(body, "raknet_rtt_seconds_count ") {
+		t.Error("RTTs aren't scraped")
+	}
+
+	if t.Failed() {
+		t.Log(body)
+	}
+}
@@ -0,0 +1,153 @@
+package pcap
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"net"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/server"
+)
+
+// Filter decides whether datagrams from or to the address are captured
+type Filter func(addr *net.UDPAddr) bool
+
+// IPFilter returns a Filter capturing datagrams of the IP addresses
+func IPFilter(ips ...net.IP) Filter {
+	return func(addr *net.UDPAddr) bool {
+		for _, ip := range ips {
+			if ip.Equal(addr.IP) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// AddrFilter returns a Filter capturing datagrams of the addresses
+func AddrFilter(addrs ...*net.UDPAddr) Filter {
+	return func(addr *net.UDPAddr) bool {
+		for _, a := range addrs {
+			if a.Port == addr.Port && a.IP.Equal(addr.IP) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// GUIDFilter returns a Filter capturing datagrams of sessions with the GUIDs in the server
+// Datagrams received before a session is created, such as the start of the handshake, aren't captured.
+// It follows sessions moved to new addresses.
+func GUIDFilter(ser *server.Server, guids ...int64) Filter {
+	return func(addr *net.UDPAddr) bool {
+		for _, guid := range guids {
+			session, ok := ser.GetSessionGUID(guid)
+			if !ok {
+				continue
+			}
+
+			a := session.Addr()
+			if a.Port == addr.Port && a.IP.Equal(addr.IP) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// CaptureErrorFunc is called when a datagram couldn't be written to the capture
+type CaptureErrorFunc func(err error)
+
+// Conn is a net.PacketConn capturing datagrams read and written to a pcap Writer
+// The server doesn't use batch reads and writes through it.
+// Datagrams with addresses not *net.UDPAddr aren't captured.
+type Conn struct {
+	net.PacketConn
+
+	// Writer writes captured datagrams
+	Writer *Writer
+
+	// Filter decides whether datagrams are captured
+	// if it's nil, all datagrams are captured.
+	Filter Filter
+
+	// Clock is a source of timestamps of datagrams
+	// if it's nil, the system clock is used.
+	Clock raknet.Clock
+
+	// CaptureError is called when a datagram couldn't be written to Writer
+	// if it's nil, errors are ignored.
+	CaptureError CaptureErrorFunc
+}
+
+// ReadFrom reads a datagram, and captures it
+func (conn *Conn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	n, addr, err = conn.PacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+
+	// Bytes over b are discarded, so n may be smaller than the datagram
+	conn.capture(addr, true, b[:n])
+
+	return n, addr, nil
+}
+
+// WriteTo writes a datagram, and captures it
+func (conn *Conn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	n, err = conn.PacketConn.WriteTo(b, addr)
+	if err != nil {
+		return n, err
+	}
+
+	conn.capture(addr, false, b[:n])
+
+	return n, nil
+}
+
+// capture writes the datagram received from or sent to the remote address
+func (conn *Conn) capture(remote net.Addr, received bool, b []byte) {
+	raddr, ok := remote.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	laddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	if conn.Filter != nil && !conn.Filter(raddr) {
+		return
+	}
+
+	src, dst := laddr, raddr
+	if received {
+		src, dst = raddr, laddr
+	}
+
+	err := conn.Writer.WriteDatagram(conn.now(), src, dst, b)
+	if err != nil && conn.CaptureError != nil {
+		conn.CaptureError(err)
+	}
+}
+
+func (conn *Conn) now() time.Time {
+	if conn.Clock != nil {
+		return conn.Clock.Now()
+	}
+
+	return time.Now()
+}
@@ -0,0 +1,259 @@
+package pcap
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// nanoMagic is the magic number of pcap files with timestamps in nanoseconds
+	nanoMagic = 0xa1b23c4d
+
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+)
+
+var (
+	errInvalidFile     = errors.New("not a pcap file")
+	errUnknownLinkType = errors.New("unsupported link type")
+)
+
+// Datagram is a UDP datagram in a pcap file
+type Datagram struct {
+
+	// Time is the time the datagram was captured
+	Time time.Time
+
+	// Src is the source address
+	Src *net.UDPAddr
+
+	// Dst is the destination address
+	Dst *net.UDPAddr
+
+	// Payload is the payload of the UDP datagram
+	Payload []byte
+}
+
+// NewReader returns a Reader reading a pcap file from r
+// Files with raw IP, Ethernet, Linux cooked and BSD loopback link types are supported.
+func NewReader(r io.Reader) (*Reader, error) {
+	header := make([]byte, fileHeaderSize)
+
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &Reader{
+		in: r,
+	}
+
+	switch binary.LittleEndian.Uint32(header) {
+	case magic:
+		reader.order = binary.LittleEndian
+	case nanoMagic:
+		reader.order = binary.LittleEndian
+		reader.nano = true
+	default:
+		switch binary.BigEndian.Uint32(header) {
+		case magic:
+			reader.order = binary.BigEndian
+		case nanoMagic:
+			reader.order = binary.BigEndian
+			reader.nano = true
+		default:
+			return nil, errInvalidFile
+		}
+	}
+
+	reader.linkType = reader.order.Uint32(header[20:]) & 0x0fffffff
+
+	switch reader.linkType {
+	case linkTypeNull, linkTypeEthernet, linkTypeRaw, linkTypeLinuxSLL, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return nil, errUnknownLinkType
+	}
+
+	return reader, nil
+}
+
+// Reader reads UDP datagrams from a pcap file
+type Reader struct {
+	in       io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+}
+
+// ReadDatagram reads the next UDP datagram
+// Packets other than UDP and fragmented IPv4 packets are skipped.
+// It returns io.EOF at the end of the file.
+func (r *Reader) ReadDatagram() (*Datagram, error) {
+	header := make([]byte, recordHeaderSize)
+
+	for {
+		_, err := io.ReadFull(r.in, header)
+		if err != nil {
+			return nil, err
+		}
+
+		size := r.order.Uint32(header[8:])
+		if size > snapLen*4 {
+			return nil, errInvalidFile
+		}
+
+		b := make([]byte, size)
+
+		_, err = io.ReadFull(r.in, b)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		sec := int64(r.order.Uint32(header[0:]))
+		frac := int64(r.order.Uint32(header[4:]))
+		if !r.nano {
+			frac *= 1000
+		}
+
+		dg, ok := parseUDP(r.ipPacket(b))
+		if !ok {
+			continue
+		}
+
+		dg.Time = time.Unix(sec, frac)
+
+		return dg, nil
+	}
+}
+
+// ipPacket returns the IP packet in the frame of the link type
+func (r *Reader) ipPacket(b []byte) []byte {
+	switch r.linkType {
+	case linkTypeNull:
+		// The address family in the host's byte order
+		if len(b) < 4 {
+			return nil
+		}
+
+		return b[4:]
+	case linkTypeEthernet:
+		if len(b) < 14 {
+			return nil
+		}
+
+		typ := binary.BigEndian.Uint16(b[12:])
+		b = b[14:]
+
+		if typ == etherTypeVLAN {
+			if len(b) < 4 {
+				return nil
+			}
+
+			typ = binary.BigEndian.Uint16(b[2:])
+			b = b[4:]
+		}
+
+		if typ != etherTypeIPv4 && typ != etherTypeIPv6 {
+			return nil
+		}
+
+		return b
+	case linkTypeLinuxSLL:
+		if len(b) < 16 {
+			return nil
+		}
+
+		return b[16:]
+	}
+
+	return b
+}
+
+// parseUDP returns the UDP datagram in the IP packet
+func parseUDP(b []byte) (*Datagram, bool) {
+	if len(b) < 1 {
+		return nil, false
+	}
+
+	var src, dst net.IP
+	var udp []byte
+
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < ipv4HeaderSize {
+			return nil, false
+		}
+
+		headerSize := int(b[0]&0x0f) * 4
+		size := int(binary.BigEndian.Uint16(b[2:]))
+		if headerSize < ipv4HeaderSize || size < headerSize || size > len(b) || b[9] != protocolUDP {
+			return nil, false
+		}
+
+		// Fragments aren't reassembled
+		if binary.BigEndian.Uint16(b[6:])&0x3fff != 0 {
+			return nil, false
+		}
+
+		src = net.IP(append([]byte(nil), b[12:16]...))
+		dst = net.IP(append([]byte(nil), b[16:20]...))
+		udp = b[headerSize:size]
+	case 6:
+		if len(b) < ipv6HeaderSize || b[6] != protocolUDP {
+			return nil, false
+		}
+
+		size := ipv6HeaderSize + int(binary.BigEndian.Uint16(b[4:]))
+		if size > len(b) {
+			return nil, false
+		}
+
+		src = net.IP(append([]byte(nil), b[8:24]...))
+		dst = net.IP(append([]byte(nil), b[24:40]...))
+		udp = b[ipv6HeaderSize:size]
+	default:
+		return nil, false
+	}
+
+	if len(udp) < udpHeaderSize {
+		return nil, false
+	}
+
+	size := int(binary.BigEndian.Uint16(udp[4:]))
+	if size < udpHeaderSize || size > len(udp) {
+		return nil, false
+	}
+
+	return &Datagram{
+		Src: &net.UDPAddr{
+			IP:   src,
+			Port: int(binary.BigEndian.Uint16(udp[0:])),
+		},
+		Dst: &net.UDPAddr{
+			IP:   dst,
+			Port: int(binary.BigEndian.Uint16(udp[2:])),
+		},
+		Payload: udp[udpHeaderSize:size],
+	}, true
+}
@@ -0,0 +1,319 @@
+// Package pcap captures datagrams of a Raknet server to pcap files, and reads them
+//
+// Files can be opened in Wireshark, which shows Raknet packets with its dissector.
+// A server captures datagrams through Conn:
+//
+//	w, err := pcap.Create("capture.pcap", 100<<20)
+//	...
+//	ser.Serve(ctx, &pcap.Conn{PacketConn: conn, Writer: w})
+package pcap
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// magic is the magic number of pcap files with timestamps in microseconds
+	magic = 0xa1b2c3d4
+
+	// linkTypeRaw is the link type of raw IPv4 and IPv6 packets
+	linkTypeRaw = 101
+
+	snapLen = 65535
+
+	fileHeaderSize   = 24
+	recordHeaderSize = 16
+	ipv4HeaderSize   = 20
+	ipv6HeaderSize   = 40
+	udpHeaderSize    = 8
+
+	protocolUDP = 17
+	hopLimit    = 64
+)
+
+var errWriterClosed = errors.New("writer closed")
+
+// NewWriter returns a Writer writing a pcap file to w
+// The file header is written before it returns.
+func NewWriter(w io.Writer) (*Writer, error) {
+	writer := &Writer{
+		out: w,
+	}
+
+	err := writer.writeFileHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// Create creates a pcap file at the path, and returns a Writer writing to it
+// When the file gets bigger than maxSize bytes, it's closed and the writer continues
+// to a new file numbered after the path, such as "capture.1.pcap".
+// if maxSize is 0 or less, the file isn't rotated.
+func Create(path string, maxSize int64) (*Writer, error) {
+	writer := &Writer{
+		path:    path,
+		maxSize: maxSize,
+	}
+
+	err := writer.create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// Writer writes UDP datagrams to pcap files
+// It's safe for concurrent use.
+type Writer struct {
+	mutex   sync.Mutex
+	out     io.Writer
+	file    *os.File
+	path    string
+	maxSize int64
+	files   int
+	size    int64
+	buf     []byte
+	closed  bool
+}
+
+// WriteDatagram writes a datagram from src to dst at the time
+// The datagram is written in an IP packet with a UDP header.
+func (w *Writer) WriteDatagram(t time.Time, src *net.UDPAddr, dst *net.UDPAddr, payload []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return errWriterClosed
+	}
+
+	w.buf = appendRecord(w.buf[:0], t, src, dst, payload)
+
+	if w.maxSize > 0 && w.size > fileHeaderSize && w.size+int64(len(w.buf)) > w.maxSize {
+		err := w.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.write(w.buf)
+}
+
+// Close closes the file created by Create
+// Writers returned by NewWriter don't close their io.Writer.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	if w.file != nil {
+		return w.file.Close()
+	}
+
+	return nil
+}
+
+// create creates a file at the path, and writes the file header
+func (w *Writer) create(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.out = file
+	w.size = 0
+
+	err = w.writeFileHeader()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return nil
+}
+
+// rotate closes the current file, and creates the next file
+func (w *Writer) rotate() error {
+	err := w.file.Close()
+	if err != nil {
+		return err
+	}
+
+	w.files++
+
+	return w.create(rotatedPath(w.path, w.files))
+}
+
+func (w *Writer) writeFileHeader() error {
+	b := make([]byte, fileHeaderSize)
+
+	binary.LittleEndian.PutUint32(b[0:], magic)
+	binary.LittleEndian.PutUint16(b[4:], 2) // version 2.4
+	binary.LittleEndian.PutUint16(b[6:], 4)
+	binary.LittleEndian.PutUint32(b[16:], snapLen)
+	binary.LittleEndian.PutUint32(b[20:], linkTypeRaw)
+
+	return w.write(b)
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.out.Write(b)
+	w.size += int64(n)
+
+	return err
+}
+
+// rotatedPath returns the path of the nth file, such as "capture.1.pcap" for "capture.pcap"
+func rotatedPath(path string, n int) string {
+	ext := filepath.Ext(path)
+
+	return strings.TrimSuffix(path, ext) + "." + strconv.Itoa(n) + ext
+}
+
+// appendRecord appends a pcap record of the datagram to b
+func appendRecord(b []byte, t time.Time, src *net.UDPAddr, dst *net.UDPAddr, payload []byte) []byte {
+	srcIP, dstIP := ipPair(src.IP, dst.IP)
+
+	ipSize := ipv6HeaderSize
+	if len(srcIP) == net.IPv4len {
+		ipSize = ipv4HeaderSize
+	}
+
+	// The payload is truncated to fit in the length fields
+	if len(payload) > snapLen-ipSize-udpHeaderSize {
+		payload = payload[:snapLen-ipSize-udpHeaderSize]
+	}
+
+	udpSize := udpHeaderSize + len(payload)
+	size := ipSize + udpSize
+
+	start := len(b)
+	b = append(b, make([]byte, recordHeaderSize+size)...)
+
+	record := b[start:]
+
+	binary.LittleEndian.PutUint32(record[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(size))
+	binary.LittleEndian.PutUint32(record[12:], uint32(size))
+
+	ip := record[recordHeaderSize:]
+	udp := ip[ipSize:]
+
+	binary.BigEndian.PutUint16(udp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpSize))
+	copy(udp[udpHeaderSize:], payload)
+
+	// The pseudo header of the checksum has the addresses, the protocol and the length
+	sum := sumBytes(0, srcIP)
+	sum = sumBytes(sum, dstIP)
+	sum += protocolUDP + uint32(udpSize)
+	sum = sumBytes(sum, udp)
+
+	checksum := finishChecksum(sum)
+	if checksum == 0 {
+		checksum = 0xffff
+	}
+
+	binary.BigEndian.PutUint16(udp[6:], checksum)
+
+	if ipSize == ipv4HeaderSize {
+		ip[0] = 0x45 // version 4, 5 words
+		binary.BigEndian.PutUint16(ip[2:], uint16(size))
+		ip[8] = hopLimit
+		ip[9] = protocolUDP
+		copy(ip[12:], srcIP)
+		copy(ip[16:], dstIP)
+
+		binary.BigEndian.PutUint16(ip[10:], finishChecksum(sumBytes(0, ip[:ipv4HeaderSize])))
+	} else {
+		ip[0] = 0x60 // version 6
+		binary.BigEndian.PutUint16(ip[4:], uint16(udpSize))
+		ip[6] = protocolUDP
+		ip[7] = hopLimit
+		copy(ip[8:], srcIP)
+		copy(ip[24:], dstIP)
+	}
+
+	return b
+}
+
+// ipPair returns the addresses in the same family
+// IPv4 addresses are used if both can be IPv4, such as on a dual-stack socket.
+func ipPair(src net.IP, dst net.IP) (net.IP, net.IP) {
+	src4, dst4 := toIPv4(src), toIPv4(dst)
+	if src4 != nil && dst4 != nil {
+		return src4, dst4
+	}
+
+	return toIPv6(src), toIPv6(dst)
+}
+
+// toIPv4 returns the IPv4 address of ip
+// Unspecified addresses, like a socket listening on all addresses, are 0.0.0.0.
+func toIPv4(ip net.IP) net.IP {
+	if len(ip) == 0 || ip.IsUnspecified() {
+		return net.IPv4zero.To4()
+	}
+
+	return ip.To4()
+}
+
+func toIPv6(ip net.IP) net.IP {
+	ip6 := ip.To16()
+	if ip6 == nil {
+		return net.IPv6unspecified
+	}
+
+	return ip6
+}
+
+// sumBytes adds b to the ones' complement sum as 16 bit words
+func sumBytes(sum uint32, b []byte) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+
+	return sum
+}
+
+func finishChecksum(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,200 @@
+package pcap
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testDatagrams returns datagrams between IPv4 and IPv6 addresses
+// Payloads have odd and even lengths for the checksums.
+func testDatagrams() []*Datagram {
+	t := time.Unix(1500000000, 123456000)
+
+	v4 := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132}
+	v4Client := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 19133}
+	v6Client := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5001}
+
+	return []*Datagram{
+		{Time: t, Src: v4Client, Dst: v4, Payload: []byte{0x01, 2, 3, 4, 5}},
+		{Time: t.Add(time.Millisecond), Src: v4, Dst: v4Client, Payload: []byte{0x1c, 2, 3, 4}},
+		{Time: t.Add(2 * time.Millisecond), Src: v6Client, Dst: v6, Payload: []byte{0x84, 0, 0, 0, 0x90}},
+		{Time: t.Add(3 * time.Millisecond), Src: v6, Dst: v6Client, Payload: bytes.Repeat([]byte{0xc0}, 100)},
+	}
+}
+
+// readAll reads all datagrams of the pcap file
+func readAll(t *testing.T, r io.Reader) []*Datagram {
+	t.Helper()
+
+	reader, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dgs []*Datagram
+	for {
+		dg, err := reader.ReadDatagram()
+		if err == io.EOF {
+			return dgs
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		dgs = append(dgs, dg)
+	}
+}
+
+// equalDatagram returns whether the datagrams have the same time, addresses and payload
+func equalDatagram(a *Datagram, b *Datagram) bool {
+	return a.Time.Equal(b.Time) &&
+		a.Src.IP.Equal(b.Src.IP) && a.Src.Port == b.Src.Port &&
+		a.Dst.IP.Equal(b.Dst.IP) && a.Dst.Port == b.Dst.Port &&
+		bytes.Equal(a.Payload, b.Payload)
+}
+
+func TestRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	w, err := NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testDatagrams()
+	for _, dg := range want {
+		err := w.WriteDatagram(dg.Time, dg.Src, dg.Dst, dg.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := readAll(t, buf)
+	if len(got) != len(want) {
+		t.Fatalf("read %d datagrams, want %d", len(got), len(want))
+	}
+
+	for i := range want {
+		if !equalDatagram(got[i], want[i]) {
+			t.Fatalf("datagram %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	for _, dg := range testDatagrams() {
+		record := appendRecord(nil, dg.Time, dg.Src, dg.Dst, dg.Payload)
+		ip := record[recordHeaderSize:]
+
+		srcIP, dstIP := ipPair(dg.Src.IP, dg.Dst.IP)
+
+		ipSize := ipv6HeaderSize
+		if len(srcIP) == net.IPv4len {
+			ipSize = ipv4HeaderSize
+
+			// The sum of a header with its checksum is 0xffff
+			if sum := finishChecksum(sumBytes(0, ip[:ipv4HeaderSize])); sum != 0 {
+				t.Fatalf("the IPv4 header checksum of %v is wrong by %#x", dg.Src, sum)
+			}
+		}
+
+		udp := ip[ipSize:]
+
+		sum := sumBytes(0, srcIP)
+		sum = sumBytes(sum, dstIP)
+		sum += protocolUDP + uint32(len(udp))
+		sum = sumBytes(sum, udp)
+
+		if finishChecksum(sum) != 0 {
+			t.Fatalf("the UDP checksum of %v is wrong by %#x", dg.Src, finishChecksum(sum))
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcap")
+
+	// Each file has the file header and two records at most
+	dgs := testDatagrams()[:2]
+	maxSize := int64(fileHeaderSize + len(appendRecord(nil, dgs[0].Time, dgs[0].Src, dgs[0].Dst, dgs[0].Payload))*2)
+
+	w, err := Create(path, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []*Datagram
+	for i := 0; i < 5; i++ {
+		dg := *dgs[i%len(dgs)]
+		dg.Time = dg.Time.Add(time.Duration(i) * time.Second)
+
+		err := w.WriteDatagram(dg.Time, dg.Src, dg.Dst, dg.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want = append(want, &dg)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{path, rotatedPath(path, 1), rotatedPath(path, 2)}
+
+	var got []*Datagram
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Size() > maxSize {
+			t.Fatalf("%s has %d bytes, over the limit %d", filepath.Base(p), info.Size(), maxSize)
+		}
+
+		file, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, readAll(t, file)...)
+
+		file.Close()
+	}
+
+	_, err = os.Stat(rotatedPath(path, 3))
+	if !os.IsNotExist(err) {
+		t.Fatalf("%s is created", filepath.Base(rotatedPath(path, 3)))
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("read %d datagrams from the files, want %d", len(got), len(want))
+	}
+
+	for i := range want {
+		if !equalDatagram(got[i], want[i]) {
+			t.Fatalf("datagram %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if w.WriteDatagram(time.Now(), dgs[0].Src, dgs[0].Dst, dgs[0].Payload) == nil {
+		t.Fatal("a datagram is written after closing")
+	}
+}
@@ -109,7 +109,7 @@ func (ack *Acknowledge) Decode() error {
 		}
 
 		var endIndex binary.Triad
-		if noRange {
+		if !noRange {
 			endIndex, err = ack.LTriad()
 			if err != nil {
 				return err
@@ -187,3 +187,34 @@ func simplifyRecords(records []*raknet.Record) []*raknet.Record {
 
 	return recs
 }
+
+// AppendAcknowledge appends an encoded acknowledge packet to b, and returns the extended buffer
+// It's the same as Acknowledge.Encode without allocating a stream.
+// Records are condensed if there are two or more.
+func AppendAcknowledge(b []byte, typ ACKType, records []*raknet.Record) []byte {
+	if len(records) > 1 {
+		records = CondenseRecords(records)
+	}
+
+	id := byte(IDACK)
+	if typ == TypeNACK {
+		id = IDNACK
+	}
+
+	b = append(b, id, byte(len(records)>>8), byte(len(records)))
+
+	for _, rec := range records {
+		if !rec.IsRanged() {
+			b = append(b, 1)
+			b = appendLTriad(b, binary.Triad(rec.Index))
+
+			continue
+		}
+
+		b = append(b, 0)
+		b = appendLTriad(b, binary.Triad(rec.Index))
+		b = appendLTriad(b, binary.Triad(rec.EndIndex))
+	}
+
+	return b
+}
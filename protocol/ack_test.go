@@ -0,0 +1,111 @@
+package protocol
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/beito123/go-raknet"
+)
+
+func TestAcknowledge(t *testing.T) {
+	ack := &Acknowledge{
+		Type: TypeACK,
+		Records: []*raknet.Record{
+			{Index: 1},
+			{Index: 5, EndIndex: 8},
+			{Index: 20},
+		},
+	}
+
+	err := ack.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dack := &Acknowledge{}
+	dack.SetBytes(ack.Bytes())
+
+	err = dack.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var numbers []int
+	for _, record := range dack.Records {
+		numbers = append(numbers, record.Numbers()...)
+	}
+
+	want := []int{1, 5, 6, 7, 8, 20}
+	if len(numbers) != len(want) {
+		t.Fatalf("decoded %v, want %v", numbers, want)
+	}
+
+	for i := range want {
+		if numbers[i] != want[i] {
+			t.Fatalf("decoded %v, want %v", numbers, want)
+		}
+	}
+}
+
+func TestAppendAcknowledge(t *testing.T) {
+	records := []*raknet.Record{{Index: 3}}
+
+	ack := &Acknowledge{
+		Type:    TypeNACK,
+		Records: records,
+	}
+
+	err := ack.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 0, 64)
+	if !bytes.Equal(AppendAcknowledge(buf, TypeNACK, records), ack.Bytes()) {
+		t.Fatal("AppendAcknowledge differs from Encode")
+	}
+
+	// A single record is sent for each datagram received, so it mustn't allocate
+	allocs := testing.AllocsPerRun(100, func() {
+		AppendAcknowledge(buf, TypeACK, records)
+	})
+
+	if allocs != 0 {
+		t.Fatalf("AppendAcknowledge allocates %v times", allocs)
+	}
+}
+
+func BenchmarkAppendAcknowledge(b *testing.B) {
+	records := []*raknet.Record{{Index: 3}}
+	buf := make([]byte, 0, 64)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		AppendAcknowledge(buf, TypeACK, records)
+	}
+}
+
+func BenchmarkAcknowledgeEncode(b *testing.B) {
+	records := []*raknet.Record{{Index: 3}}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		ack := &Acknowledge{
+			Type:    TypeACK,
+			Records: records,
+		}
+
+		ack.Encode()
+	}
+}
@@ -165,3 +165,9 @@ const (
 	IDACK  = 0xc0
 	IDNACK = 0xa0
 )
+
+// go-raknet extensions
+const (
+	// IDSessionMigration is used to move a session to a new client address
+	IDSessionMigration = IDReserved3
+)
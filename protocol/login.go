@@ -9,7 +9,11 @@ package protocol
  * http://opensource.org/licenses/mit-license.php
  */
 
-import "github.com/beito123/go-raknet"
+import (
+	"errors"
+
+	"github.com/beito123/go-raknet"
+)
 
 type AlreadyConnected struct {
 	BasePacket
@@ -133,6 +137,130 @@ func (pk *ConnectionBanned) New() raknet.Packet {
 	return new(ConnectionBanned)
 }
 
+type IPRecentlyConnected struct {
+	BasePacket
+
+	Magic      bool
+	ServerGUID int64
+}
+
+func (IPRecentlyConnected) ID() byte {
+	return IDIpRecentlyConnected
+}
+
+func (pk *IPRecentlyConnected) Encode() error {
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutMagic()
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutLong(pk.ServerGUID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *IPRecentlyConnected) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	pk.Magic = pk.CheckMagic()
+
+	pk.ServerGUID, err = pk.Long()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *IPRecentlyConnected) New() raknet.Packet {
+	return new(IPRecentlyConnected)
+}
+
+// MigrationTokenSize is the byte size of a session migration token
+const MigrationTokenSize = 16
+
+// SessionMigration is a go-raknet extension to move a session to a new client address
+// The server sends it to the connected client to tell the token,
+// and the client sends it from the new address to move the session.
+type SessionMigration struct {
+	BasePacket
+
+	Magic      bool
+	ClientGUID int64
+	Token      []byte
+}
+
+func (SessionMigration) ID() byte {
+	return IDSessionMigration
+}
+
+func (pk *SessionMigration) Encode() error {
+	if len(pk.Token) != MigrationTokenSize {
+		return errors.New("invalid migration token size")
+	}
+
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutMagic()
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutLong(pk.ClientGUID)
+	if err != nil {
+		return err
+	}
+
+	err = pk.Put(pk.Token)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *SessionMigration) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	pk.Magic = pk.CheckMagic()
+
+	pk.ClientGUID, err = pk.Long()
+	if err != nil {
+		return err
+	}
+
+	token := pk.Get(MigrationTokenSize)
+	if len(token) != MigrationTokenSize {
+		return errors.New("invalid migration token size")
+	}
+
+	pk.Token = make([]byte, MigrationTokenSize)
+	copy(pk.Token, token)
+
+	return nil
+}
+
+func (pk *SessionMigration) New() raknet.Packet {
+	return new(SessionMigration)
+}
+
 type ConnectionRequest struct {
 	BasePacket
 
@@ -208,6 +336,12 @@ type ConnectionRequestAccepted struct {
 	InternalAddresses []*raknet.SystemAddress // unknown
 	ClientTimestamp   int64
 	ServerTimestamp   int64
+
+	// AddressCount is the number of internal addresses sent, padded with empty addresses
+	// Minecraft uses raknet.MinecraftSystemAddressCount.
+	// if it's 0 or less, raknet.SystemAddressCount is used.
+	// Decode sets the number of addresses received.
+	AddressCount int
 }
 
 func (pk ConnectionRequestAccepted) ID() byte {
@@ -230,7 +364,7 @@ func (pk *ConnectionRequestAccepted) Encode() error {
 		return err
 	}
 
-	for i := 0; i < 10; i++ {
+	for i := 0; i < addressCount(pk.AddressCount); i++ {
 		var address *raknet.SystemAddress
 		if i < len(pk.InternalAddresses) {
 			address = pk.InternalAddresses[i]
@@ -273,14 +407,19 @@ func (pk *ConnectionRequestAccepted) Decode() error {
 		return err
 	}
 
-	pk.InternalAddresses = make([]*raknet.SystemAddress, 10)
-	for i := 0; i < 10; i++ {
-		pk.InternalAddresses[i], err = pk.AddressSystemAddress()
+	// The number of addresses depends on the protocol version, so reads until the timestamps
+	pk.InternalAddresses = nil
+	for pk.Len() > handshakeTimestampsLen {
+		address, err := pk.AddressSystemAddress()
 		if err != nil {
 			return err
 		}
+
+		pk.InternalAddresses = append(pk.InternalAddresses, address)
 	}
 
+	pk.AddressCount = len(pk.InternalAddresses)
+
 	pk.ClientTimestamp, err = pk.Long()
 	if err != nil {
 		return err
@@ -681,6 +820,12 @@ type NewIncomingConnection struct {
 	Addresses       []*raknet.SystemAddress
 	ServerTimestamp int64
 	ClientTimestamp int64
+
+	// AddressCount is the number of addresses sent, padded with empty addresses
+	// Minecraft uses raknet.MinecraftSystemAddressCount.
+	// if it's 0 or less, raknet.SystemAddressCount is used.
+	// Decode sets the number of addresses received.
+	AddressCount int
 }
 
 func (NewIncomingConnection) ID() byte {
@@ -698,7 +843,7 @@ func (pk *NewIncomingConnection) Encode() error {
 		return err
 	}
 
-	for i := 0; i < 10; i++ {
+	for i := 0; i < addressCount(pk.AddressCount); i++ {
 		var addr *raknet.SystemAddress
 		if i < len(pk.Addresses) {
 			addr = pk.Addresses[i]
@@ -736,16 +881,19 @@ func (pk *NewIncomingConnection) Decode() error {
 		return err
 	}
 
-	pk.Addresses = make([]*raknet.SystemAddress, 10)
-	for i := 0; i < 10; i++ {
+	// The number of addresses depends on the protocol version, so reads until the timestamps
+	pk.Addresses = nil
+	for pk.Len() > handshakeTimestampsLen {
 		addr, err := pk.AddressSystemAddress()
 		if err != nil {
 			return err
 		}
 
-		pk.Addresses[i] = addr
+		pk.Addresses = append(pk.Addresses, addr)
 	}
 
+	pk.AddressCount = len(pk.Addresses)
+
 	pk.ServerTimestamp, err = pk.Long()
 	if err != nil {
 		return err
@@ -762,3 +910,15 @@ func (pk *NewIncomingConnection) Decode() error {
 func (pk *NewIncomingConnection) New() raknet.Packet {
 	return new(NewIncomingConnection)
 }
+
+// handshakeTimestampsLen is the length of the two timestamps after the addresses in the handshake packets
+const handshakeTimestampsLen = 16
+
+// addressCount returns the number of addresses in the handshake packets
+func addressCount(count int) int {
+	if count <= 0 {
+		return raknet.SystemAddressCount
+	}
+
+	return count
+}
@@ -317,3 +317,52 @@ func (pk *CustomPacket) New() raknet.Packet {
 func CalcCPacketBaseSize() int {
 	return 1 + 3 // pk id + index
 }
+
+// AppendEncapsulated appends the encoded epk to b, and returns the extended buffer
+// It's the same as Encode without allocating a stream.
+func AppendEncapsulated(b []byte, epk *EncapsulatedPacket) []byte {
+	flags := epk.Reliability.ToBinary() << ReliabilityPosition
+	if epk.Split {
+		flags |= FlagSplit
+	}
+
+	length := uint16(len(epk.Payload) << 3)
+
+	b = append(b, flags, byte(length>>8), byte(length))
+
+	if epk.Reliability.IsReliable() {
+		b = appendLTriad(b, epk.MessageIndex)
+	}
+
+	if epk.Reliability.IsOrdered() || epk.Reliability.IsSequenced() {
+		b = appendLTriad(b, epk.OrderIndex)
+		b = append(b, epk.OrderChannel)
+	}
+
+	if epk.Split {
+		b = append(b,
+			byte(epk.SplitCount>>24), byte(epk.SplitCount>>16), byte(epk.SplitCount>>8), byte(epk.SplitCount),
+			byte(epk.SplitID>>8), byte(epk.SplitID),
+			byte(epk.SplitIndex>>24), byte(epk.SplitIndex>>16), byte(epk.SplitIndex>>8), byte(epk.SplitIndex))
+	}
+
+	return append(b, epk.Payload...)
+}
+
+// AppendCustomPacket appends an encoded custom packet to b, and returns the extended buffer
+// It's the same as CustomPacket.Encode without allocating a stream,
+// but Record of the messages isn't set.
+func AppendCustomPacket(b []byte, id byte, index binary.Triad, epks []*EncapsulatedPacket) []byte {
+	b = append(b, id)
+	b = appendLTriad(b, index)
+
+	for _, epk := range epks {
+		b = AppendEncapsulated(b, epk)
+	}
+
+	return b
+}
+
+func appendLTriad(b []byte, v binary.Triad) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16))
+}
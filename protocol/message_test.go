@@ -0,0 +1,129 @@
+package protocol
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/beito123/go-raknet"
+)
+
+// testMessages returns messages with each kind of header
+func testMessages() []*EncapsulatedPacket {
+	return []*EncapsulatedPacket{
+		{
+			Reliability: raknet.Unreliable,
+			Payload:     []byte{0x90, 1, 2, 3},
+		},
+		{
+			Reliability:  raknet.ReliableOrdered,
+			MessageIndex: 5,
+			OrderIndex:   2,
+			OrderChannel: 1,
+			Payload:      bytes.Repeat([]byte{0x91}, 100),
+		},
+		{
+			Reliability:  raknet.Reliable,
+			MessageIndex: 6,
+			Split:        true,
+			SplitCount:   3,
+			SplitID:      7,
+			SplitIndex:   1,
+			Payload:      bytes.Repeat([]byte{0x92}, 1000),
+		},
+	}
+}
+
+func TestAppendCustomPacket(t *testing.T) {
+	epks := testMessages()
+
+	pk := NewCustomPacket(IDCustom4)
+	pk.Index = 10
+	pk.Messages = epks
+
+	err := pk.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 0, raknet.MaxMTU*2)
+	if !bytes.Equal(AppendCustomPacket(buf, IDCustom4, 10, epks), pk.Bytes()) {
+		t.Fatal("AppendCustomPacket differs from Encode")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		AppendCustomPacket(buf, IDCustom4, 10, epks)
+	})
+
+	if allocs != 0 {
+		t.Fatalf("AppendCustomPacket allocates %v times", allocs)
+	}
+}
+
+func TestCustomPacketDecode(t *testing.T) {
+	epks := testMessages()
+
+	dpk := NewCustomPacket(IDCustom4)
+	dpk.SetBytes(AppendCustomPacket(nil, IDCustom4, 10, epks))
+
+	err := dpk.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dpk.Index != 10 || len(dpk.Messages) != len(epks) {
+		t.Fatalf("decoded index %d and %d messages", dpk.Index, len(dpk.Messages))
+	}
+
+	for i, epk := range dpk.Messages {
+		if !bytes.Equal(epk.Payload, epks[i].Payload) || epk.Reliability != epks[i].Reliability {
+			t.Fatalf("message %d is different", i)
+		}
+	}
+}
+
+func BenchmarkAppendCustomPacket(b *testing.B) {
+	epks := testMessages()
+	buf := make([]byte, 0, raknet.MaxMTU*2)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		AppendCustomPacket(buf, IDCustom4, 10, epks)
+	}
+}
+
+func BenchmarkCustomPacketEncode(b *testing.B) {
+	epks := testMessages()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		pk := NewCustomPacket(IDCustom4)
+		pk.Index = 10
+		pk.Messages = epks
+
+		pk.Encode()
+	}
+}
+
+func BenchmarkCustomPacketDecode(b *testing.B) {
+	data := AppendCustomPacket(nil, IDCustom4, 10, testMessages())
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		pk := NewCustomPacket(IDCustom4)
+		pk.SetBytes(data)
+
+		pk.Decode()
+	}
+}
@@ -16,7 +16,7 @@ type Protocol struct {
 }
 
 func (protocol *Protocol) RegisterPackets() {
-	protocol.packets = make([]raknet.Packet, 0xff)
+	protocol.packets = make([]raknet.Packet, 0x100)
 
 	protocol.packets[IDConnectedPing] = &ConnectedPing{}
 	protocol.packets[IDUnconnectedPing] = &UnconnectedPing{}
@@ -35,7 +35,9 @@ func (protocol *Protocol) RegisterPackets() {
 	protocol.packets[IDDisconnectionNotification] = &DisconnectionNotification{}
 	protocol.packets[IDConnectionBanned] = &ConnectionBanned{}
 	protocol.packets[IDIncompatibleProtocolVersion] = &IncompatibleProtocol{}
+	protocol.packets[IDIpRecentlyConnected] = &IPRecentlyConnected{}
 	protocol.packets[IDUnconnectedPong] = &UnconnectedPong{}
+	protocol.packets[IDSessionMigration] = &SessionMigration{}
 	protocol.packets[IDACK] = &Acknowledge{
 		Type: TypeACK,
 	}
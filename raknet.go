@@ -19,6 +19,12 @@ const (
 	// NetworkProtocol is a version of Raknet protocol
 	NetworkProtocol = 9
 
+	// MinNetworkProtocol is the oldest version of Raknet protocol used by Minecraft
+	MinNetworkProtocol = 9
+
+	// MaxNetworkProtocol is the newest version of Raknet protocol used by Minecraft
+	MaxNetworkProtocol = 11
+
 	// MaxMTU is the maximum size of MTU
 	MaxMTU = 1492
 
@@ -36,22 +42,67 @@ const (
 
 	// MaxSplitsPerQueue is the maximum size of Queue
 	MaxSplitsPerQueue = 4
+
+	// SystemAddressCount is the number of internal addresses in the handshake of Raknet
+	SystemAddressCount = 10
+
+	// MinecraftSystemAddressCount is the number of internal addresses in the handshake of Minecraft
+	MinecraftSystemAddressCount = 20
 )
 
 // Magic is Raknet offline message data id
 // using offline connection in Raknet
 var Magic = []byte{0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe, 0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78}
 
+// NetworkProtocols returns Raknet protocol versions between min and max
+func NetworkProtocols(min int, max int) []int {
+	var versions []int
+	for v := min; v <= max; v++ {
+		versions = append(versions, v)
+	}
+
+	return versions
+}
+
 // MaxPacketsPerSecond is the maximum size that can send per second
 var MaxPacketsPerSecond = 500
 
+// MaxPendingConnections is the default maximum number of clients in the handshake before a session is created
+// Its entries are kept until HandshakeTimeout, so they're limited against spoofed addresses.
+var MaxPendingConnections = 1024
+
+// MaxMigrationFailuresPerSecond is the maximum number of invalid session migrations from an ip address per second
+var MaxMigrationFailuresPerSecond = 3
+
+// WriteQueueSize is the default number of datagrams waiting to be written by a writer
+var WriteQueueSize = 1024
+
+// MaxWriteBatch is the maximum number of datagrams written at once in batch mode
+var MaxWriteBatch = 64
+
+// MaxReadBatch is the maximum number of datagrams read at once in batch mode
+var MaxReadBatch = 64
+
+// ShardQueueSize is the number of received datagrams waiting to be handled by a shard
+var ShardQueueSize = 1024
+
+// MaxPacketWarnings is the maximum number of warnings logged for received packets per PacketWarningInterval
+// Others are dropped, so clients can't flood logs with invalid packets.
+var MaxPacketWarnings = 10
+
 var (
 
+	// UpdateInterval is the interval of updating sessions
+	UpdateInterval time.Duration = time.Millisecond
+
 	// SendInterval
 	SendInterval             time.Duration = 50 * time.Millisecond
 	RecoverySendInterval                   = SendInterval
 	PingSendInterval                       = 2500 * time.Millisecond
 	DetectionSendInterval                  = PingSendInterval * 2
 	SessionTimeout                         = DetectionSendInterval * 5
+	HandshakeTimeout                       = 5 * time.Second
+	MigrationInterval                      = 5 * time.Second
 	MaxPacketsPerSecondBlock               = 1000 * 300 * time.Millisecond
+	PacketWarningInterval                  = time.Second
 )
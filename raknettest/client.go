@@ -0,0 +1,810 @@
+package raknettest
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/binary"
+	"github.com/beito123/go-raknet/protocol"
+	"github.com/beito123/go-raknet/server"
+	"github.com/beito123/go-raknet/util"
+)
+
+var (
+	errNotConnected = errors.New("not connected")
+)
+
+// RequestRetryInterval is the interval to resend a handshake request without a response
+var RequestRetryInterval = 500 * time.Millisecond
+
+// ClientState is a state of Client
+type ClientState int
+
+const (
+	ClientDisconnected ClientState = iota
+	ClientHandshaking
+	ClientConnected
+)
+
+// Dial returns a new client on the address, and starts connecting to the server
+func (network *Network) Dial(address string, server string) (*Client, error) {
+	saddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := network.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.Track()
+
+	client := NewClient(conn, saddr, network.Clock)
+
+	network.mutex.Lock()
+	client.GUID = network.rand.Int63()
+	network.mutex.Unlock()
+
+	if network.MTU > 0 && network.MTU < client.MTU {
+		client.MTU = network.MTU
+	}
+
+	err = client.Connect()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// NewClient returns a new client to connect addr through conn
+func NewClient(conn net.PacketConn, addr *net.UDPAddr, clock raknet.Clock) *Client {
+	return &Client{
+		GUID:            1,
+		MTU:             raknet.MaxMTU,
+		ProtocolVersion: raknet.NetworkProtocol,
+		Clock:           clock,
+		conn:            conn,
+		addr:            addr,
+	}
+}
+
+// Client is a minimal Raknet client to test servers
+// It completes the handshake, sends messages with reliability and split,
+// and receives user packets in the order.
+type Client struct {
+
+	// GUID is the client's GUID
+	GUID int64
+
+	// MTU is the MTU requested to the server
+	MTU int
+
+	// ProtocolVersion is the Raknet protocol version requested to the server
+	ProtocolVersion int
+
+	// Clock is a source of the current time
+	Clock raknet.Clock
+
+	conn     net.PacketConn
+	addr     *net.UDPAddr
+	protocol *protocol.Protocol
+
+	mutex  sync.Mutex
+	state  ClientState
+	done   chan struct{}
+	closed bool
+
+	// rejection is a packet the server rejected the connection with
+	rejection raknet.Packet
+
+	startTime       time.Time
+	request         raknet.Packet
+	lastRequestTime time.Time
+
+	// token is the session migration token sent from the server
+	token []byte
+
+	// migrating is true until a packet is received after Migrate
+	migrating bool
+
+	messageIndex      binary.Triad
+	splitID           uint16
+	sendSequence      binary.Triad
+	orderSendIndex    map[int]binary.Triad
+	sequenceSendIndex map[int]binary.Triad
+	recovery          map[int]*sentDatagram
+
+	receiveSequence      binary.Triad
+	reliablePackets      map[binary.Triad]bool
+	splitQueue           map[uint16]*server.SplitPacket
+	orderReceiveIndex    map[byte]binary.Triad
+	sequenceReceiveIndex map[byte]binary.Triad
+	handleQueue          map[byte]map[binary.Triad]*protocol.EncapsulatedPacket
+
+	packets [][]byte
+}
+
+type sentDatagram struct {
+	epks []*protocol.EncapsulatedPacket
+	time time.Time
+}
+
+// Connect starts the handshake with the server
+// The handshake progresses as the network delivers packets.
+func (client *Client) Connect() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.done != nil {
+		return errors.New("already started")
+	}
+
+	client.protocol = new(protocol.Protocol)
+	client.protocol.RegisterPackets()
+
+	client.orderSendIndex = make(map[int]binary.Triad)
+	client.sequenceSendIndex = make(map[int]binary.Triad)
+	client.recovery = make(map[int]*sentDatagram)
+	client.reliablePackets = make(map[binary.Triad]bool)
+	client.splitQueue = make(map[uint16]*server.SplitPacket)
+	client.orderReceiveIndex = make(map[byte]binary.Triad)
+	client.sequenceReceiveIndex = make(map[byte]binary.Triad)
+	client.handleQueue = make(map[byte]map[binary.Triad]*protocol.EncapsulatedPacket)
+
+	client.startTime = client.now()
+	client.state = ClientHandshaking
+	client.done = make(chan struct{})
+
+	err := client.sendRequest(&protocol.OpenConnectionRequestOne{
+		ProtocolVersion: byte(client.ProtocolVersion),
+		MTU:             client.MTU,
+	})
+	if err != nil {
+		return err
+	}
+
+	go client.run()
+
+	scheduler, ok := client.Clock.(raknet.Scheduler)
+	if ok {
+		client.scheduleUpdate(scheduler)
+	} else {
+		go client.runUpdate()
+	}
+
+	return nil
+}
+
+// State returns the state of the client
+func (client *Client) State() ClientState {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.state
+}
+
+// Connected returns whether the client completed the handshake
+func (client *Client) Connected() bool {
+	return client.State() == ClientConnected
+}
+
+// Rejection returns the packet the server rejected the connection with
+// such as *protocol.ConnectionBanned. It's nil if the connection wasn't rejected.
+func (client *Client) Rejection() raknet.Packet {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.rejection
+}
+
+// Packets returns payloads of user packets received in the order handled
+func (client *Client) Packets() [][]byte {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	pks := make([][]byte, len(client.packets))
+	copy(pks, client.packets)
+
+	return pks
+}
+
+// LocalAddr returns the client's address
+func (client *Client) LocalAddr() net.Addr {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.conn.LocalAddr()
+}
+
+// MigrationToken returns the session migration token sent from the server
+// It's nil if the server doesn't enable migration.
+func (client *Client) MigrationToken() []byte {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.token
+}
+
+// Migrate moves the client to conn, like a NAT changed the client's address
+// The old connection is closed, and the session is migrated with the token
+// if the server enables migration. The request is resent until the server responds.
+func (client *Client) Migrate(conn net.PacketConn) error {
+	client.mutex.Lock()
+
+	if client.state != ClientConnected {
+		client.mutex.Unlock()
+		return errNotConnected
+	}
+
+	old := client.conn
+
+	pconn, ok := conn.(*PacketConn)
+	if ok {
+		pconn.Track()
+	}
+
+	client.conn = conn
+	client.migrating = client.token != nil
+
+	if client.migrating {
+		client.sendMigration()
+	}
+
+	client.mutex.Unlock()
+
+	return old.Close()
+}
+
+func (client *Client) sendMigration() {
+	pk := &protocol.SessionMigration{
+		ClientGUID: client.GUID,
+		Token:      client.token,
+	}
+
+	err := pk.Encode()
+	if err != nil {
+		return
+	}
+
+	client.lastRequestTime = client.now()
+
+	client.conn.WriteTo(pk.Bytes(), client.addr)
+}
+
+// Send sends a payload to the server
+func (client *Client) Send(b []byte, reliability raknet.Reliability, channel int) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.state != ClientConnected {
+		return errNotConnected
+	}
+
+	return client.send(b, reliability, channel)
+}
+
+// Close sends a disconnection notification if connected, and closes the client
+func (client *Client) Close() error {
+	client.mutex.Lock()
+
+	if client.state == ClientConnected {
+		client.send([]byte{protocol.IDDisconnectionNotification}, raknet.Unreliable, raknet.DefaultChannel)
+	}
+
+	client.state = ClientDisconnected
+	client.closed = true
+	done := client.done
+	conn := client.conn
+
+	client.mutex.Unlock()
+
+	err := conn.Close()
+
+	if done != nil {
+		<-done
+	}
+
+	return err
+}
+
+func (client *Client) now() time.Time {
+	if client.Clock != nil {
+		return client.Clock.Now()
+	}
+
+	return time.Now()
+}
+
+// timestamp returns milliseconds from the time the client started
+func (client *Client) timestamp() int64 {
+	return int64(client.now().Sub(client.startTime) / time.Millisecond)
+}
+
+func (client *Client) run() {
+	defer close(client.done)
+
+	buf := make([]byte, 2048)
+	for {
+		client.mutex.Lock()
+		conn := client.conn
+		client.mutex.Unlock()
+
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			client.mutex.Lock()
+			migrated := client.conn != conn
+			client.mutex.Unlock()
+
+			if migrated {
+				continue
+			}
+
+			return
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		// Received packets may be kept in queues, so they don't share buf
+		b := make([]byte, n)
+		copy(b, buf[:n])
+
+		client.mutex.Lock()
+		client.handle(b)
+		client.mutex.Unlock()
+	}
+}
+
+// runUpdate updates the client every raknet.UpdateInterval until it's closed
+func (client *Client) runUpdate() {
+	ticker := time.NewTicker(raknet.UpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.done:
+			return
+		case <-ticker.C:
+		}
+
+		client.mutex.Lock()
+		client.update()
+		client.mutex.Unlock()
+	}
+}
+
+// scheduleUpdate updates the client every raknet.UpdateInterval on the scheduler until it's closed
+func (client *Client) scheduleUpdate(scheduler raknet.Scheduler) {
+	scheduler.AfterFunc(raknet.UpdateInterval, func() {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+
+		if client.closed {
+			return
+		}
+
+		client.update()
+		client.scheduleUpdate(scheduler)
+	})
+}
+
+func (client *Client) update() {
+	now := client.now()
+
+	if client.state == ClientHandshaking && client.request != nil &&
+		now.Sub(client.lastRequestTime) >= RequestRetryInterval {
+		client.sendRequest(client.request)
+	}
+
+	if client.migrating && now.Sub(client.lastRequestTime) >= RequestRetryInterval {
+		client.sendMigration()
+	}
+
+	var indexes []int
+	for index, sent := range client.recovery {
+		if now.Sub(sent.time) >= raknet.RecoverySendInterval {
+			indexes = append(indexes, index)
+		}
+	}
+
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		client.resend(index)
+	}
+}
+
+func (client *Client) sendRequest(pk raknet.Packet) error {
+	// Encode appends to the buffer, so encode a new packet on each retry
+	npk := pk.New()
+	switch p := pk.(type) {
+	case *protocol.OpenConnectionRequestOne:
+		*npk.(*protocol.OpenConnectionRequestOne) = protocol.OpenConnectionRequestOne{
+			ProtocolVersion: p.ProtocolVersion,
+			MTU:             p.MTU,
+		}
+	case *protocol.OpenConnectionRequestTwo:
+		*npk.(*protocol.OpenConnectionRequestTwo) = protocol.OpenConnectionRequestTwo{
+			Address:    p.Address,
+			MTU:        p.MTU,
+			ClientGuid: p.ClientGuid,
+			Connection: p.Connection,
+		}
+	}
+
+	err := npk.Encode()
+	if err != nil {
+		return err
+	}
+
+	client.request = pk
+	client.lastRequestTime = client.now()
+
+	_, err = client.conn.WriteTo(npk.Bytes(), client.addr)
+
+	return err
+}
+
+func (client *Client) handle(b []byte) {
+	pk, ok := client.protocol.Packet(b[0])
+	if !ok {
+		return
+	}
+
+	pk.SetBytes(b)
+
+	err := pk.Decode()
+	if err != nil {
+		return
+	}
+
+	switch npk := pk.(type) {
+	case *protocol.OpenConnectionResponseOne:
+		if client.state != ClientHandshaking {
+			return
+		}
+
+		mtu := int(npk.MTU)
+		if mtu <= 0 || mtu > client.MTU {
+			mtu = client.MTU
+		}
+
+		client.MTU = mtu
+
+		client.sendRequest(&protocol.OpenConnectionRequestTwo{
+			Address:    raknet.NewSystemAddressUDP(client.addr),
+			MTU:        uint16(mtu),
+			ClientGuid: client.GUID,
+			Connection: raknet.ConnectionGoRaknet,
+		})
+	case *protocol.OpenConnectionResponseTwo:
+		if client.state != ClientHandshaking || client.request == nil {
+			return
+		}
+
+		client.request = nil
+
+		req := &protocol.ConnectionRequest{
+			ClientGuid: client.GUID,
+			Timestamp:  client.timestamp(),
+		}
+
+		err := req.Encode()
+		if err != nil {
+			return
+		}
+
+		client.send(req.Bytes(), raknet.ReliableOrdered, raknet.DefaultChannel)
+	case *protocol.IncompatibleProtocol, *protocol.AlreadyConnected, *protocol.NoFreeIncomingConnections,
+		*protocol.ConnectionBanned, *protocol.IPRecentlyConnected:
+		// Rejections to duplicated requests after the session opened are ignored
+		if client.state != ClientHandshaking || client.request == nil {
+			return
+		}
+
+		client.rejection = pk
+		client.state = ClientDisconnected
+	case *protocol.Acknowledge:
+		for _, record := range npk.Records {
+			for _, index := range record.Numbers() {
+				if npk.Type == protocol.TypeNACK {
+					client.resend(index)
+				} else {
+					delete(client.recovery, index)
+				}
+			}
+		}
+	case *protocol.CustomPacket:
+		client.migrating = false
+
+		client.handleCustomPacket(npk)
+	}
+}
+
+func (client *Client) handleCustomPacket(cpk *protocol.CustomPacket) {
+	if cpk.Index > client.receiveSequence+1 {
+		client.sendACK(protocol.TypeNACK, &raknet.Record{
+			Index:    int(client.receiveSequence + 1),
+			EndIndex: int(cpk.Index - 1),
+		})
+	}
+
+	if cpk.Index > client.receiveSequence {
+		client.receiveSequence = cpk.Index
+	}
+
+	client.sendACK(protocol.TypeACK, &raknet.Record{
+		Index: int(cpk.Index),
+	})
+
+	for _, epk := range cpk.Messages {
+		client.handleEncapsulated(epk)
+	}
+}
+
+func (client *Client) handleEncapsulated(epk *protocol.EncapsulatedPacket) {
+	if epk.Split {
+		spk, ok := client.splitQueue[epk.SplitID]
+		if !ok {
+			spk = &server.SplitPacket{
+				SplitID:     int(epk.SplitID),
+				SplitCount:  int(epk.SplitCount),
+				Reliability: epk.Reliability,
+			}
+
+			client.splitQueue[epk.SplitID] = spk
+		}
+
+		payload := spk.Update(epk)
+		if payload == nil {
+			return
+		}
+
+		delete(client.splitQueue, epk.SplitID)
+
+		epk.Payload = payload
+	}
+
+	if epk.Reliability.IsReliable() {
+		if client.reliablePackets[epk.MessageIndex] {
+			return
+		}
+
+		client.reliablePackets[epk.MessageIndex] = true
+	}
+
+	if epk.Reliability.IsOrdered() {
+		queue, ok := client.handleQueue[epk.OrderChannel]
+		if !ok {
+			queue = make(map[binary.Triad]*protocol.EncapsulatedPacket)
+			client.handleQueue[epk.OrderChannel] = queue
+		}
+
+		queue[epk.OrderIndex] = epk
+
+		index := client.orderReceiveIndex[epk.OrderChannel]
+		for {
+			p, ok := queue[index]
+			if !ok {
+				break
+			}
+
+			delete(queue, index)
+
+			index = index.Bump()
+			client.orderReceiveIndex[epk.OrderChannel] = index
+
+			client.handleMessage(p.Payload)
+		}
+	} else if epk.Reliability.IsSequenced() {
+		if epk.OrderIndex >= client.sequenceReceiveIndex[epk.OrderChannel] {
+			client.sequenceReceiveIndex[epk.OrderChannel] = epk.OrderIndex.Bump()
+			client.handleMessage(epk.Payload)
+		}
+	} else {
+		client.handleMessage(epk.Payload)
+	}
+}
+
+func (client *Client) handleMessage(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+
+	switch b[0] {
+	case protocol.IDConnectionRequestAccepted:
+		if client.state != ClientHandshaking {
+			return
+		}
+
+		pk := &protocol.ConnectionRequestAccepted{}
+		pk.SetBytes(b)
+
+		err := pk.Decode()
+		if err != nil {
+			return
+		}
+
+		// The client sends as many addresses as the server sent
+		npk := &protocol.NewIncomingConnection{
+			ServerAddress:   raknet.NewSystemAddressUDP(client.addr),
+			ServerTimestamp: pk.ServerTimestamp,
+			ClientTimestamp: client.timestamp(),
+			AddressCount:    pk.AddressCount,
+		}
+
+		err = npk.Encode()
+		if err != nil {
+			return
+		}
+
+		client.send(npk.Bytes(), raknet.ReliableOrdered, raknet.DefaultChannel)
+
+		client.state = ClientConnected
+	case protocol.IDConnectedPing:
+		pk := &protocol.ConnectedPing{}
+		pk.SetBytes(b)
+
+		err := pk.Decode()
+		if err != nil {
+			return
+		}
+
+		pong := &protocol.ConnectedPong{
+			Timestamp:     pk.Timestamp,
+			TimestampPong: client.timestamp(),
+		}
+
+		err = pong.Encode()
+		if err != nil {
+			return
+		}
+
+		client.send(pong.Bytes(), raknet.Unreliable, raknet.DefaultChannel)
+	case protocol.IDSessionMigration:
+		pk := &protocol.SessionMigration{}
+		pk.SetBytes(b)
+
+		err := pk.Decode()
+		if err != nil {
+			return
+		}
+
+		client.token = pk.Token
+	case protocol.IDDisconnectionNotification:
+		client.state = ClientDisconnected
+	default:
+		if b[0] >= protocol.IDUserPacketEnum {
+			payload := make([]byte, len(b))
+			copy(payload, b)
+
+			client.packets = append(client.packets, payload)
+		}
+	}
+}
+
+// send sends the payload in datagrams
+// Split payloads are sent in a datagram per part.
+func (client *Client) send(b []byte, reliability raknet.Reliability, channel int) error {
+	if channel >= raknet.MaxChannels {
+		return errors.New("invalid channel")
+	}
+
+	epk := &protocol.EncapsulatedPacket{
+		Reliability:  reliability,
+		OrderChannel: byte(channel),
+		Payload:      b,
+	}
+
+	if reliability.IsOrdered() {
+		epk.OrderIndex = client.orderSendIndex[channel]
+		client.orderSendIndex[channel] = epk.OrderIndex.Bump()
+	} else if reliability.IsSequenced() {
+		epk.OrderIndex = client.sequenceSendIndex[channel]
+		client.sequenceSendIndex[channel] = epk.OrderIndex.Bump()
+	}
+
+	base := protocol.CalcCPacketBaseSize()
+	if base+protocol.CalcEPacketSize(reliability, false, b) <= client.MTU {
+		if reliability.IsReliable() {
+			epk.MessageIndex = server.BumpTriad(&client.messageIndex)
+		}
+
+		return client.sendDatagram([]*protocol.EncapsulatedPacket{epk})
+	}
+
+	parts := util.SplitBytesSlice(b, client.MTU-(base+protocol.CalcEPacketSize(reliability, true, []byte{})))
+	splitID := server.BumpUInt16(&client.splitID)
+
+	for i, part := range parts {
+		spk := &protocol.EncapsulatedPacket{
+			Reliability:  reliability,
+			OrderChannel: epk.OrderChannel,
+			OrderIndex:   epk.OrderIndex,
+			Split:        true,
+			SplitCount:   int32(len(parts)),
+			SplitID:      splitID,
+			SplitIndex:   int32(i),
+			Payload:      part,
+		}
+
+		if reliability.IsReliable() {
+			spk.MessageIndex = server.BumpTriad(&client.messageIndex)
+		}
+
+		err := client.sendDatagram([]*protocol.EncapsulatedPacket{spk})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (client *Client) sendDatagram(epks []*protocol.EncapsulatedPacket) error {
+	cpk := protocol.NewCustomPacket(protocol.IDCustom4)
+	cpk.Index = server.BumpTriad(&client.sendSequence)
+	cpk.Messages = epks
+
+	err := cpk.Encode()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.conn.WriteTo(cpk.Bytes(), client.addr)
+	if err != nil {
+		return err
+	}
+
+	cpk.RemoveUnreliables()
+	if len(cpk.Messages) > 0 {
+		client.recovery[int(cpk.Index)] = &sentDatagram{
+			epks: cpk.Messages,
+			time: client.now(),
+		}
+	}
+
+	return nil
+}
+
+func (client *Client) resend(index int) {
+	sent, ok := client.recovery[index]
+	if !ok {
+		return
+	}
+
+	delete(client.recovery, index)
+
+	client.sendDatagram(sent.epks)
+}
+
+func (client *Client) sendACK(typ protocol.ACKType, records ...*raknet.Record) {
+	ack := &protocol.Acknowledge{
+		Type:    typ,
+		Records: records,
+	}
+
+	err := ack.Encode()
+	if err != nil {
+		return
+	}
+
+	client.conn.WriteTo(ack.Bytes(), client.addr)
+}
@@ -0,0 +1,140 @@
+package raknettest
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Epoch is the time a new Clock starts at
+var Epoch = time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// NewClock returns a new virtual clock started at Epoch
+func NewClock() *Clock {
+	return &Clock{
+		now: Epoch,
+	}
+}
+
+// Clock is a virtual clock moved only by Advance
+// It implements raknet.Scheduler, so it can be set to server.Server.Clock
+// and the server's updates run on the clock.
+type Clock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*timer
+
+	// sequence is a counter of timers
+	// It keeps the order of timers at the same time.
+	sequence uint64
+}
+
+// timer is a function called at the time
+type timer struct {
+	time     time.Time
+	sequence uint64
+	f        func()
+}
+
+// Now returns the current time of the clock
+func (clock *Clock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now
+}
+
+// AfterFunc calls f after d passed on the clock
+// f is called in the goroutine advancing the clock, with the clock set to its time.
+func (clock *Clock) AfterFunc(d time.Duration, f func()) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.sequence++
+
+	t := &timer{
+		time:     clock.now.Add(d),
+		sequence: clock.sequence,
+		f:        f,
+	}
+
+	// Timers are sorted by the time, and the order they were added
+	i := sort.Search(len(clock.timers), func(i int) bool {
+		return clock.timers[i].time.After(t.time)
+	})
+
+	clock.timers = append(clock.timers, nil)
+	copy(clock.timers[i+1:], clock.timers[i:])
+	clock.timers[i] = t
+}
+
+// Advance moves the clock forward by d
+// Timers are called in the order of their time, with the clock set to the time.
+func (clock *Clock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	target := clock.Now().Add(d)
+
+	for clock.runTimer(target) {
+	}
+
+	clock.set(target)
+}
+
+// next returns the time of the next timer
+func (clock *Clock) next() (time.Time, bool) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	if len(clock.timers) == 0 {
+		return time.Time{}, false
+	}
+
+	return clock.timers[0].time, true
+}
+
+// runTimer calls the next timer not after t, and returns whether it's called
+// The clock is set to the time of the timer.
+func (clock *Clock) runTimer(t time.Time) bool {
+	clock.mutex.Lock()
+
+	if len(clock.timers) == 0 || clock.timers[0].time.After(t) {
+		clock.mutex.Unlock()
+		return false
+	}
+
+	tm := clock.timers[0]
+	clock.timers[0] = nil
+	clock.timers = clock.timers[1:]
+
+	if tm.time.After(clock.now) {
+		clock.now = tm.time
+	}
+
+	clock.mutex.Unlock()
+
+	tm.f()
+
+	return true
+}
+
+// set moves the clock forward to t
+func (clock *Clock) set(t time.Time) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	if t.After(clock.now) {
+		clock.now = t
+	}
+}
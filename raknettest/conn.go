@@ -0,0 +1,240 @@
+package raknettest
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errClosed = errors.New("use of closed connection")
+)
+
+// timeoutError is returned when a read deadline passed
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func newPacketConn(network *Network, addr *net.UDPAddr) *PacketConn {
+	conn := &PacketConn{
+		network: network,
+		addr:    addr,
+	}
+
+	conn.cond = sync.NewCond(&conn.mutex)
+
+	return conn
+}
+
+// PacketConn is a net.PacketConn on Network
+// Deadlines are in real time, not the network's clock.
+type PacketConn struct {
+	network *Network
+	addr    *net.UDPAddr
+
+	mutex        sync.Mutex
+	cond         *sync.Cond
+	queue        []*datagram
+	closed       bool
+	readDeadline time.Time
+	timer        *time.Timer
+
+	// readers is the number of goroutines waiting in ReadFrom
+	readers int
+
+	// tracked is whether Settle waits until read datagrams are handled, see Track
+	tracked bool
+
+	// handling is the number of datagrams read and not handled yet
+	// A datagram is handled when its reader calls ReadFrom again.
+	handling int
+}
+
+// ReadFrom reads a datagram sent to the connection
+func (conn *PacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	conn.mutex.Lock()
+
+	if conn.handling > 0 {
+		conn.handling--
+	}
+
+	conn.readers++
+	conn.cond.Broadcast()
+
+	defer func() {
+		conn.readers--
+		conn.mutex.Unlock()
+	}()
+
+	for {
+		if conn.closed {
+			return 0, nil, errClosed
+		}
+
+		if len(conn.queue) > 0 {
+			break
+		}
+
+		if !conn.readDeadline.IsZero() && !time.Now().Before(conn.readDeadline) {
+			return 0, nil, timeoutError{}
+		}
+
+		conn.cond.Wait()
+	}
+
+	dg := conn.queue[0]
+	conn.queue[0] = nil
+	conn.queue = conn.queue[1:]
+
+	if conn.tracked {
+		conn.handling++
+	}
+
+	// Bytes over b are discarded like a udp socket
+	n = copy(b, dg.b)
+
+	return n, dg.from, nil
+}
+
+// WriteTo sends a datagram to addr on the network
+func (conn *PacketConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	conn.mutex.Lock()
+	closed := conn.closed
+	conn.mutex.Unlock()
+
+	if closed {
+		return 0, errClosed
+	}
+
+	conn.network.send(conn.addr, addr, b)
+
+	return len(b), nil
+}
+
+func (conn *PacketConn) push(dg *datagram) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.closed {
+		return
+	}
+
+	conn.queue = append(conn.queue, dg)
+	conn.cond.Broadcast()
+}
+
+// Track makes Settle wait until datagrams read from the connection are handled,
+// not only read. A datagram is regarded as handled when its reader calls ReadFrom again,
+// so the connection must be read by one goroutine in a loop.
+// Connections of Serve and Dial are tracked.
+func (conn *PacketConn) Track() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.tracked = true
+}
+
+// busy returns whether datagrams delivered to the connection are left to read or handle
+func (conn *PacketConn) busy() bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return len(conn.queue) > 0 || conn.handling > 0
+}
+
+// waitReader waits until a goroutine reads the connection
+// It returns false if the connection is closed.
+func (conn *PacketConn) waitReader() bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	for conn.readers == 0 && !conn.closed {
+		conn.cond.Wait()
+	}
+
+	return !conn.closed
+}
+
+// Len returns the number of datagrams not read yet
+func (conn *PacketConn) Len() int {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return len(conn.queue)
+}
+
+// Close closes the connection
+// Datagrams sent to the closed connection are dropped.
+func (conn *PacketConn) Close() error {
+	conn.mutex.Lock()
+
+	if conn.closed {
+		conn.mutex.Unlock()
+		return errClosed
+	}
+
+	conn.closed = true
+	conn.queue = nil
+
+	if conn.timer != nil {
+		conn.timer.Stop()
+	}
+
+	conn.cond.Broadcast()
+	conn.mutex.Unlock()
+
+	conn.network.remove(conn)
+
+	return nil
+}
+
+// LocalAddr returns the address of the connection
+func (conn *PacketConn) LocalAddr() net.Addr {
+	return conn.addr
+}
+
+// SetDeadline sets the read deadline
+// Writes never block on the network.
+func (conn *PacketConn) SetDeadline(t time.Time) error {
+	return conn.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (conn *PacketConn) SetReadDeadline(t time.Time) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.readDeadline = t
+
+	if conn.timer != nil {
+		conn.timer.Stop()
+		conn.timer = nil
+	}
+
+	if !t.IsZero() {
+		conn.timer = time.AfterFunc(time.Until(t), func() {
+			conn.mutex.Lock()
+			conn.cond.Broadcast()
+			conn.mutex.Unlock()
+		})
+	}
+
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes never block on the network
+func (conn *PacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,414 @@
+// Package raknettest provides an in-memory network to test Raknet servers
+//
+// Network carries datagrams between PacketConns without real sockets,
+// with latency, jitter, loss, duplication, reordering and MTU on a virtual clock.
+// A server.Server is served on a PacketConn from the network, and Client connects to it.
+package raknettest
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/beito123/go-raknet/server"
+)
+
+var (
+	errAddressInUse = errors.New("address already in use")
+)
+
+// SettleTimeout is the maximum real time Settle waits for the server and clients
+// It's reached only if they're blocked, such as a handler never returning
+// or a PacketConn from Listen not read.
+var SettleTimeout = time.Second
+
+// NewNetwork returns a new network
+// Random decisions of the network are made from seed for each pair of addresses,
+// so the same seed and the same order of sending on each pair give the same result.
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		Clock: NewClock(),
+		seed:  seed,
+		rand:  rand.New(rand.NewSource(seed)),
+		conns: make(map[string]*PacketConn),
+		links: make(map[string]*link),
+	}
+}
+
+// Network is a virtual packet network
+// Datagrams are delivered when the clock passes their delivery time, in batches
+// sorted by the time, the sender and the order sent. The server and clients handle
+// each batch before the next one, so the result doesn't depend on goroutine scheduling.
+type Network struct {
+
+	// Clock is the clock of the network
+	// It should be shared with the server and clients on the network.
+	Clock *Clock
+
+	// Latency is the delay of each datagram
+	Latency time.Duration
+
+	// Jitter is the maximum random delay added to Latency
+	Jitter time.Duration
+
+	// Loss is the probability a datagram is lost, between 0 and 1
+	Loss float64
+
+	// Duplicate is the probability a datagram is delivered twice, between 0 and 1
+	Duplicate float64
+
+	// Reorder is the probability a datagram is delayed behind later datagrams, between 0 and 1
+	Reorder float64
+
+	// MTU is the maximum datagram size, bigger datagrams are dropped
+	// if it's 0 or less, the size isn't limited.
+	MTU int
+
+	// Drop decides whether a datagram is dropped, besides Loss
+	// It's used to lose specific packets, such as the first reply from the server.
+	// It's called with the network locked, so it mustn't use the network.
+	Drop func(from net.Addr, to net.Addr, b []byte) bool
+
+	mutex sync.Mutex
+	seed  int64
+	rand  *rand.Rand
+	conns map[string]*PacketConn
+
+	// links is the state of each pair of addresses, by "from>to"
+	links map[string]*link
+
+	// servers is servers served by Serve, waited in Settle until their queues are empty
+	servers []*server.Server
+
+	// pending contains datagrams waiting for their delivery time
+	pending []*datagram
+
+	sent      int
+	delivered int
+	dropped   int
+}
+
+// link is a one-way path between two addresses
+type link struct {
+	rand *rand.Rand
+
+	// sequence is a counter of datagrams sent on the link
+	// It keeps the order of datagrams delivered at the same time.
+	sequence uint64
+}
+
+type datagram struct {
+	from     *net.UDPAddr
+	to       string
+	b        []byte
+	time     time.Time
+	sequence uint64
+
+	// key is the sender's address to sort datagrams delivered at the same time
+	key string
+}
+
+// Listen returns a new PacketConn on the address
+// address is an ip address and a port, such as "10.0.0.1:19132".
+func (network *Network) Listen(address string) (*PacketConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	_, ok := network.conns[addr.String()]
+	if ok {
+		return nil, errAddressInUse
+	}
+
+	conn := newPacketConn(network, addr)
+
+	network.conns[addr.String()] = conn
+
+	return conn, nil
+}
+
+// Count returns the numbers of sent, delivered and dropped datagrams
+// Duplicated datagrams are counted as delivered twice.
+func (network *Network) Count() (sent int, delivered int, dropped int) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	return network.sent, network.delivered, network.dropped
+}
+
+func (network *Network) remove(conn *PacketConn) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	if network.conns[conn.addr.String()] == conn {
+		delete(network.conns, conn.addr.String())
+	}
+}
+
+func (network *Network) send(from *net.UDPAddr, to net.Addr, b []byte) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	network.sent++
+
+	l := network.link(from.String(), to.String())
+
+	if network.MTU > 0 && len(b) > network.MTU {
+		network.dropped++
+		return
+	}
+
+	if l.chance(network.Loss) {
+		network.dropped++
+		return
+	}
+
+	if network.Drop != nil && network.Drop(from, to, b) {
+		network.dropped++
+		return
+	}
+
+	copies := 1
+	if l.chance(network.Duplicate) {
+		copies = 2
+	}
+
+	now := network.Clock.Now()
+
+	for i := 0; i < copies; i++ {
+		buf := make([]byte, len(b))
+		copy(buf, b)
+
+		l.sequence++
+
+		network.pending = append(network.pending, &datagram{
+			from:     from,
+			to:       to.String(),
+			b:        buf,
+			time:     now.Add(network.delay(l)),
+			sequence: l.sequence,
+			key:      from.String(),
+		})
+	}
+}
+
+// link returns the link from the address to the address
+// The network must be locked.
+func (network *Network) link(from string, to string) *link {
+	key := from + ">" + to
+
+	l, ok := network.links[key]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+
+		l = &link{
+			rand: rand.New(rand.NewSource(network.seed ^ int64(h.Sum64()))),
+		}
+
+		network.links[key] = l
+	}
+
+	return l
+}
+
+// delay returns a delay for a datagram on the link
+func (network *Network) delay(l *link) time.Duration {
+	d := network.Latency
+
+	if network.Jitter > 0 {
+		d += time.Duration(l.rand.Int63n(int64(network.Jitter) + 1))
+	}
+
+	if l.chance(network.Reorder) {
+		d += network.Latency + network.Jitter + time.Millisecond
+	}
+
+	return d
+}
+
+func (l *link) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+
+	return l.rand.Float64() < p
+}
+
+// deliver puts the datagram to the receiver
+// The network must be locked.
+func (network *Network) deliver(dg *datagram) {
+	conn, ok := network.conns[dg.to]
+	if !ok {
+		network.dropped++
+		return
+	}
+
+	network.delivered++
+
+	conn.push(dg)
+}
+
+// deliverDue delivers datagrams reached their time, and returns whether any datagram is delivered
+func (network *Network) deliverDue() bool {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	now := network.Clock.Now()
+
+	sort.SliceStable(network.pending, func(i, j int) bool {
+		a, b := network.pending[i], network.pending[j]
+		if !a.time.Equal(b.time) {
+			return a.time.Before(b.time)
+		}
+
+		if a.key != b.key {
+			return a.key < b.key
+		}
+
+		return a.sequence < b.sequence
+	})
+
+	n := 0
+	for _, dg := range network.pending {
+		if dg.time.After(now) {
+			break
+		}
+
+		network.deliver(dg)
+		n++
+	}
+
+	for i := 0; i < n; i++ {
+		network.pending[i] = nil
+	}
+
+	network.pending = network.pending[n:]
+
+	return n > 0
+}
+
+// next returns the time of the next datagram or timer
+func (network *Network) next() (time.Time, bool) {
+	t, ok := network.Clock.next()
+
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	for _, dg := range network.pending {
+		if !ok || dg.time.Before(t) {
+			t, ok = dg.time, true
+		}
+	}
+
+	return t, ok
+}
+
+// Advance moves the clock forward by d
+// Datagrams and timers are handled in the order of their time, settling the network after each of them.
+func (network *Network) Advance(d time.Duration) {
+	target := network.Clock.Now().Add(d)
+
+	for {
+		network.Settle()
+
+		t, ok := network.next()
+		if !ok || t.After(target) {
+			break
+		}
+
+		network.Clock.set(t)
+	}
+
+	network.Clock.set(target)
+	network.Settle()
+}
+
+// Settle handles datagrams and timers reached their time until nothing is left to do
+// The server and clients handle packets in their goroutines, so call it
+// after sending to let them respond. Advance settles the network itself.
+func (network *Network) Settle() {
+	for {
+		if !network.wait() {
+			return
+		}
+
+		if network.deliverDue() {
+			continue
+		}
+
+		if network.Clock.runTimer(network.Clock.Now()) {
+			continue
+		}
+
+		return
+	}
+}
+
+// wait waits until all delivered datagrams are handled, and returns false on SettleTimeout
+func (network *Network) wait() bool {
+	deadline := time.Now().Add(SettleTimeout)
+
+	for !network.idle() {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		runtime.Gosched()
+	}
+
+	return true
+}
+
+// idle returns whether all datagrams delivered to connections are read and handled,
+// and the queues of running servers are empty
+func (network *Network) idle() bool {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	for _, conn := range network.conns {
+		if conn.busy() {
+			return false
+		}
+	}
+
+	for _, ser := range network.servers {
+		if ser.IsRunning() && ser.Pending() > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Run advances the clock by step until d passes
+// It's the same as Advance(d), but the clock stops at each step such as to send between them.
+func (network *Network) Run(d time.Duration, step time.Duration) {
+	if step <= 0 {
+		step = d
+	}
+
+	network.Settle()
+
+	for passed := time.Duration(0); passed < d; passed += step {
+		network.Advance(step)
+	}
+}
@@ -0,0 +1,222 @@
+package raknettest_test
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/identifier"
+	"github.com/beito123/go-raknet/raknettest"
+	"github.com/beito123/go-raknet/server"
+)
+
+// echo serves an echo server on the network, and returns a connected client
+func echo(t *testing.T, network *raknettest.Network) (*server.Server, *raknettest.Client) {
+	t.Helper()
+
+	ser := &server.Server{
+		MaxConnections: 10,
+		Identifier:     identifier.Base{Connection: raknet.ConnectionGoRaknet},
+	}
+
+	ser.Handlers = server.Handlers{&server.HandlerFuncs{
+		HandleSessionPacketFunc: func(session *server.Session, pk raknet.Packet) {
+			session.Send(append([]byte(nil), pk.Bytes()...), raknet.ReliableOrdered, raknet.DefaultChannel)
+		},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	err := network.Serve(ctx, ser, "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := network.Dial("10.0.0.2:5000", "10.0.0.1:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { client.Close() })
+
+	for i := 0; i < 100 && !client.Connected(); i++ {
+		network.Advance(100 * time.Millisecond)
+	}
+
+	if !client.Connected() {
+		t.Fatal("the client didn't connect")
+	}
+
+	return ser, client
+}
+
+// payloads returns user packets with a split one in every 5 packets
+func payloads(n int) [][]byte {
+	pks := make([][]byte, n)
+	for i := range pks {
+		size := 10
+		if i%5 == 0 {
+			size = 4000
+		}
+
+		pks[i] = bytes.Repeat([]byte{byte(i)}, size)
+		pks[i][0] = 0x90
+	}
+
+	return pks
+}
+
+// exchange sends the payloads to the echo server, and advances the network until echoed
+func exchange(t *testing.T, network *raknettest.Network, client *raknettest.Client, pks [][]byte) {
+	t.Helper()
+
+	for _, pk := range pks {
+		err := client.Send(pk, raknet.ReliableOrdered, raknet.DefaultChannel)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 300 && len(client.Packets()) < len(pks); i++ {
+		network.Advance(10 * time.Millisecond)
+	}
+
+	got := client.Packets()
+	if len(got) != len(pks) {
+		t.Fatalf("echoed %d packets, want %d", len(got), len(pks))
+	}
+
+	for i := range pks {
+		if !bytes.Equal(got[i], pks[i]) {
+			t.Fatalf("packet %d is different", i)
+		}
+	}
+}
+
+func TestClockAfterFunc(t *testing.T) {
+	clock := raknettest.NewClock()
+
+	var calls []int
+	var times []time.Duration
+	add := func(d time.Duration, n int) {
+		clock.AfterFunc(d, func() {
+			calls = append(calls, n)
+			times = append(times, clock.Now().Sub(raknettest.Epoch))
+		})
+	}
+
+	add(20*time.Millisecond, 3)
+	add(10*time.Millisecond, 1)
+	add(10*time.Millisecond, 2)
+	add(time.Second, 4)
+
+	clock.Advance(50 * time.Millisecond)
+
+	if len(calls) != 3 || calls[0] != 1 || calls[1] != 2 || calls[2] != 3 {
+		t.Fatalf("called %v, want [1 2 3]", calls)
+	}
+
+	if times[0] != 10*time.Millisecond || times[2] != 20*time.Millisecond {
+		t.Fatalf("called at %v", times)
+	}
+
+	if clock.Now().Sub(raknettest.Epoch) != 50*time.Millisecond {
+		t.Fatalf("clock is at %v", clock.Now().Sub(raknettest.Epoch))
+	}
+}
+
+func TestNetworkEcho(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser, client := echo(t, network)
+
+	exchange(t, network, client, payloads(20))
+
+	if ser.Count() != 1 {
+		t.Fatalf("%d sessions, want 1", ser.Count())
+	}
+
+	_, _, dropped := network.Count()
+	if dropped != 0 {
+		t.Fatalf("%d datagrams dropped on a perfect network", dropped)
+	}
+}
+
+func TestNetworkLossy(t *testing.T) {
+	network := raknettest.NewNetwork(2)
+	network.Latency = 20 * time.Millisecond
+	network.Jitter = 10 * time.Millisecond
+	network.Loss = 0.2
+	network.Duplicate = 0.1
+	network.Reorder = 0.2
+	network.MTU = 1400
+
+	_, client := echo(t, network)
+
+	exchange(t, network, client, payloads(20))
+}
+
+func TestNetworkDeterministic(t *testing.T) {
+	run := func() [3]int {
+		network := raknettest.NewNetwork(3)
+		network.Latency = 10 * time.Millisecond
+		network.Jitter = 10 * time.Millisecond
+		network.Loss = 0.1
+		network.Reorder = 0.1
+
+		_, client := echo(t, network)
+
+		exchange(t, network, client, payloads(10))
+
+		sent, delivered, dropped := network.Count()
+
+		return [3]int{sent, delivered, dropped}
+	}
+
+	first := run()
+	for i := 0; i < 3; i++ {
+		got := run()
+		if got != first {
+			t.Fatalf("sent/delivered/dropped %v, want %v with the same seed", got, first)
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	network := raknettest.NewNetwork(4)
+
+	conn, err := network.Listen("10.0.0.3:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, client := echo(t, network)
+
+	_, before, _ := network.Count()
+	now := network.Clock.Now()
+
+	// A datagram without latency is delivered on Settle without moving the clock
+	conn.WriteTo([]byte{0x90}, client.LocalAddr())
+	network.Settle()
+
+	if !network.Clock.Now().Equal(now) {
+		t.Fatal("Settle moved the clock")
+	}
+
+	_, delivered, _ := network.Count()
+	if delivered != before+1 {
+		t.Fatalf("%d datagrams delivered, want 1", delivered-before)
+	}
+}
@@ -0,0 +1,52 @@
+package raknettest
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+
+	"github.com/beito123/go-raknet/server"
+)
+
+// Serve serves the server on the address in the network
+// The server's Clock is set to the network's clock if it's nil.
+// It returns after the server started reading, and Settle waits for the server from then.
+func (network *Network) Serve(ctx context.Context, ser *server.Server, address string) error {
+	conn, err := network.Listen(address)
+	if err != nil {
+		return err
+	}
+
+	conn.Track()
+
+	if ser.Clock == nil {
+		ser.Clock = network.Clock
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		err := ser.Serve(ctx, conn)
+		if err != nil {
+			conn.Close()
+		}
+
+		errs <- err
+	}()
+
+	if !conn.waitReader() {
+		return <-errs
+	}
+
+	network.mutex.Lock()
+	network.servers = append(network.servers, ser)
+	network.mutex.Unlock()
+
+	return nil
+}
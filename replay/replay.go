@@ -0,0 +1,490 @@
+// Package replay replays recorded datagrams of clients to a Raknet server
+//
+// Datagrams sent from clients in a recording, such as a pcap file captured in production,
+// are sent to a server on an in-memory network with the original timing on a virtual clock.
+// Responses of the server are compared with the recorded ones, to reproduce bugs
+// in reliability and handshakes.
+package replay
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/beito123/go-raknet/pcap"
+	"github.com/beito123/go-raknet/protocol"
+	"github.com/beito123/go-raknet/raknettest"
+	"github.com/beito123/go-raknet/server"
+)
+
+var (
+	errNoDatagrams = errors.New("no datagrams in the recording")
+	errNoServer    = errors.New("no requests to a server in the recording")
+)
+
+// DefaultStep is the default step of the virtual clock
+var DefaultStep = 10 * time.Millisecond
+
+// DefaultDrain is the default time waiting for responses after the last datagram
+var DefaultDrain = time.Second
+
+// Recording is datagrams between clients and a server in order of time
+type Recording struct {
+
+	// Server is the server's address in the recording
+	Server *net.UDPAddr
+
+	// Datagrams is datagrams from and to the server
+	Datagrams []*pcap.Datagram
+}
+
+// ReadPcap reads a recording of the server's address from a pcap file
+// Datagrams not from or to the address are skipped.
+// if addr is nil, the destination of the first open connection request 1 or unconnected ping
+// is used as the server's address, as clients send them first.
+func ReadPcap(r io.Reader, addr *net.UDPAddr) (*Recording, error) {
+	reader, err := pcap.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var datagrams []*pcap.Datagram
+
+	for {
+		dg, err := reader.ReadDatagram()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if addr == nil && isRequest(dg.Payload) {
+			addr = dg.Dst
+		}
+
+		datagrams = append(datagrams, dg)
+	}
+
+	if addr == nil {
+		return nil, errNoServer
+	}
+
+	rec := &Recording{
+		Server: addr,
+	}
+
+	for _, dg := range datagrams {
+		if equalAddr(dg.Src, rec.Server) || equalAddr(dg.Dst, rec.Server) {
+			rec.Datagrams = append(rec.Datagrams, dg)
+		}
+	}
+
+	if len(rec.Datagrams) == 0 {
+		return nil, errNoDatagrams
+	}
+
+	// Captures from several interfaces can be out of order
+	sort.SliceStable(rec.Datagrams, func(i, j int) bool {
+		return rec.Datagrams[i].Time.Before(rec.Datagrams[j].Time)
+	})
+
+	return rec, nil
+}
+
+// Clients returns the addresses of clients in the recording in order of their first datagram
+func (rec *Recording) Clients() []*net.UDPAddr {
+	var clients []*net.UDPAddr
+
+	seen := make(map[string]bool)
+
+	for _, dg := range rec.Datagrams {
+		client := dg.Src
+		if equalAddr(client, rec.Server) {
+			client = dg.Dst
+		}
+
+		if !seen[client.String()] {
+			seen[client.String()] = true
+			clients = append(clients, client)
+		}
+	}
+
+	return clients
+}
+
+// CompareFunc returns whether a response of the server matches the recorded one
+type CompareFunc func(recorded []byte, actual []byte) bool
+
+// ComparePacketID compares the packet IDs of datagrams
+// Other bytes, such as timestamps and sequence numbers, can be different in each run.
+func ComparePacketID(recorded []byte, actual []byte) bool {
+	if len(recorded) == 0 || len(actual) == 0 {
+		return len(recorded) == len(actual)
+	}
+
+	return recorded[0] == actual[0]
+}
+
+// SkipFunc returns whether a datagram is left out of the comparison
+type SkipFunc func(b []byte) bool
+
+// SkipACKs skips ACK and NACK datagrams
+// They depend on the timing of the server's updates more than other datagrams.
+func SkipACKs(b []byte) bool {
+	return len(b) > 0 && (b[0] == protocol.IDACK || b[0] == protocol.IDNACK)
+}
+
+// Replayer replays a recording to a server
+type Replayer struct {
+
+	// Network is the network the server and clients are on
+	// Its clock is used as the server's clock if the server has no clock.
+	// if it's nil, a new network without delay and loss is used.
+	Network *raknettest.Network
+
+	// Speed is the speed of the replay, such as 2 to replay twice as fast as the recording
+	// if it's 0 or less, the original timing is kept.
+	Speed float64
+
+	// MaxGap is the maximum time between datagrams in the replay
+	// Longer gaps in the recording, such as idle time, are compressed to it.
+	// if it's 0 or less, gaps aren't limited.
+	MaxGap time.Duration
+
+	// Step is the time the virtual clock advances at once
+	// if it's 0 or less, DefaultStep is used.
+	Step time.Duration
+
+	// Drain is the time waiting for responses after the last datagram
+	// if it's 0 or less, DefaultDrain is used.
+	Drain time.Duration
+
+	// Compare decides whether a response matches the recorded one
+	// if it's nil, ComparePacketID is used.
+	Compare CompareFunc
+
+	// Skip decides whether a datagram is left out of the comparison, such as SkipACKs
+	// if it's nil, all datagrams are compared.
+	Skip SkipFunc
+}
+
+// Result is a result of a replay
+type Result struct {
+
+	// Sent is datagrams sent to the server
+	Sent []*pcap.Datagram
+
+	// Responses is datagrams sent from the server to the clients
+	Responses []*pcap.Datagram
+
+	// Diffs is differences between the recorded and actual responses
+	Diffs []Diff
+}
+
+// Equal returns whether the responses matched the recording
+func (result *Result) Equal() bool {
+	return len(result.Diffs) == 0
+}
+
+// Diff is a response different from the recording
+type Diff struct {
+
+	// Client is the client's address
+	Client *net.UDPAddr
+
+	// Index is the index of the response in responses to the client
+	Index int
+
+	// Recorded is the recorded response, nil if the server sent an extra response
+	Recorded *pcap.Datagram
+
+	// Actual is the actual response, nil if the server didn't send the response
+	Actual *pcap.Datagram
+}
+
+func (diff Diff) String() string {
+	switch {
+	case diff.Recorded == nil:
+		return fmt.Sprintf("%s #%d: unexpected 0x%02x", diff.Client, diff.Index, firstByte(diff.Actual.Payload))
+	case diff.Actual == nil:
+		return fmt.Sprintf("%s #%d: missing 0x%02x", diff.Client, diff.Index, firstByte(diff.Recorded.Payload))
+	}
+
+	return fmt.Sprintf("%s #%d: recorded 0x%02x (%d bytes), actual 0x%02x (%d bytes)", diff.Client, diff.Index,
+		firstByte(diff.Recorded.Payload), len(diff.Recorded.Payload),
+		firstByte(diff.Actual.Payload), len(diff.Actual.Payload))
+}
+
+// Replay serves the server on the recorded address of the network, and replays the recording
+// Datagrams from the clients are sent from their recorded addresses, and the server is
+// closed after the replay. The server must not be started.
+// Responses after Drain, such as disconnections sent when the server is closed, aren't compared.
+func (rep *Replayer) Replay(ctx context.Context, ser *server.Server, rec *Recording) (*Result, error) {
+	if len(rec.Datagrams) == 0 {
+		return nil, errNoDatagrams
+	}
+
+	network := rep.Network
+	if network == nil {
+		network = raknettest.NewNetwork(1)
+	}
+
+	step := rep.Step
+	if step <= 0 {
+		step = DefaultStep
+	}
+
+	drain := rep.Drain
+	if drain <= 0 {
+		drain = DefaultDrain
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	err := network.Serve(ctx, ser, rec.Server.String())
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &recorder{
+		network: network,
+		server:  rec.Server,
+	}
+
+	conns := make(map[string]net.PacketConn)
+	for _, client := range rec.Clients() {
+		conn, err := network.Listen(client.String())
+		if err != nil {
+			rc.close(conns)
+			return nil, err
+		}
+
+		// Settle waits until the responses are recorded, not for a while on the system clock
+		conn.Track()
+
+		conns[client.String()] = conn
+
+		go rc.read(conn, client)
+	}
+
+	defer rc.close(conns)
+
+	result := &Result{}
+
+	start := network.Clock.Now()
+	offset := time.Duration(0)
+	last := rec.Datagrams[0].Time
+
+	for _, dg := range rec.Datagrams {
+		if !equalAddr(dg.Dst, rec.Server) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		offset += rep.gap(dg.Time.Sub(last))
+		last = dg.Time
+
+		passed := network.Clock.Now().Sub(start)
+		if offset > passed {
+			network.Run(offset-passed, step)
+		}
+
+		_, err := conns[dg.Src.String()].WriteTo(dg.Payload, rec.Server)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Sent = append(result.Sent, &pcap.Datagram{
+			Time:    network.Clock.Now(),
+			Src:     dg.Src,
+			Dst:     rec.Server,
+			Payload: dg.Payload,
+		})
+
+		network.Settle()
+	}
+
+	network.Run(drain, step)
+
+	result.Responses = rc.stop()
+	result.Diffs = rep.diff(rec, result.Responses)
+
+	return result, nil
+}
+
+// gap returns the time in the replay for the time between datagrams in the recording
+func (rep *Replayer) gap(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+
+	if rep.Speed > 0 {
+		d = time.Duration(float64(d) / rep.Speed)
+	}
+
+	if rep.MaxGap > 0 && d > rep.MaxGap {
+		d = rep.MaxGap
+	}
+
+	return d
+}
+
+// diff compares responses to each client in order
+func (rep *Replayer) diff(rec *Recording, responses []*pcap.Datagram) []Diff {
+	compare := rep.Compare
+	if compare == nil {
+		compare = ComparePacketID
+	}
+
+	recorded := rep.byClient(rec.Datagrams, rec.Server)
+	actual := rep.byClient(responses, rec.Server)
+
+	var diffs []Diff
+
+	for _, client := range rec.Clients() {
+		expected := recorded[client.String()]
+		got := actual[client.String()]
+
+		for i := 0; i < len(expected) || i < len(got); i++ {
+			diff := Diff{
+				Client: client,
+				Index:  i,
+			}
+
+			if i < len(expected) {
+				diff.Recorded = expected[i]
+			}
+
+			if i < len(got) {
+				diff.Actual = got[i]
+			}
+
+			if diff.Recorded != nil && diff.Actual != nil && compare(diff.Recorded.Payload, diff.Actual.Payload) {
+				continue
+			}
+
+			diffs = append(diffs, diff)
+		}
+	}
+
+	return diffs
+}
+
+// byClient returns datagrams from the server by the client's address without skipped ones
+func (rep *Replayer) byClient(datagrams []*pcap.Datagram, addr *net.UDPAddr) map[string][]*pcap.Datagram {
+	clients := make(map[string][]*pcap.Datagram)
+
+	for _, dg := range datagrams {
+		if !equalAddr(dg.Src, addr) {
+			continue
+		}
+
+		if rep.Skip != nil && rep.Skip(dg.Payload) {
+			continue
+		}
+
+		clients[dg.Dst.String()] = append(clients[dg.Dst.String()], dg)
+	}
+
+	return clients
+}
+
+// recorder records datagrams received by clients
+type recorder struct {
+	network *raknettest.Network
+	server  *net.UDPAddr
+
+	mutex     sync.Mutex
+	responses []*pcap.Datagram
+	stopped   bool
+}
+
+func (rc *recorder) read(conn net.PacketConn, client *net.UDPAddr) {
+	buf := make([]byte, 65535)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		src, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		dg := &pcap.Datagram{
+			Time:    rc.network.Clock.Now(),
+			Src:     src,
+			Dst:     client,
+			Payload: append([]byte(nil), buf[:n]...),
+		}
+
+		rc.mutex.Lock()
+		if !rc.stopped {
+			rc.responses = append(rc.responses, dg)
+		}
+		rc.mutex.Unlock()
+	}
+}
+
+// stop stops recording, and returns the responses
+// Packets sent while the server is closed aren't recorded.
+func (rc *recorder) stop() []*pcap.Datagram {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	rc.stopped = true
+
+	return rc.responses
+}
+
+func (rc *recorder) close(conns map[string]net.PacketConn) {
+	rc.stop()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func equalAddr(a *net.UDPAddr, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
+
+// isRequest returns whether the datagram is sent from a client to a server before other datagrams
+func isRequest(b []byte) bool {
+	switch firstByte(b) {
+	case protocol.IDOpenConnectionRequest1, protocol.IDUnconnectedPing, protocol.IDUnconnectedPingOpenConnections:
+		return true
+	}
+
+	return false
+}
+
+func firstByte(b []byte) byte {
+	if len(b) == 0 {
+		return 0
+	}
+
+	return b[0]
+}
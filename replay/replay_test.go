@@ -0,0 +1,152 @@
+package replay_test
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/identifier"
+	"github.com/beito123/go-raknet/pcap"
+	"github.com/beito123/go-raknet/raknettest"
+	"github.com/beito123/go-raknet/replay"
+	"github.com/beito123/go-raknet/server"
+)
+
+var (
+	serverAddr = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132}
+	clientAddr = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}
+)
+
+// newServer returns a new server echoing user packets
+func newServer() *server.Server {
+	return &server.Server{
+		MaxConnections: 10,
+		Identifier:     identifier.Base{Connection: raknet.ConnectionGoRaknet},
+		Handlers: server.Handlers{&server.HandlerFuncs{
+			HandleSessionPacketFunc: func(session *server.Session, pk raknet.Packet) {
+				session.Send(append([]byte(nil), pk.Bytes()...), raknet.ReliableOrdered, raknet.DefaultChannel)
+			},
+		}},
+	}
+}
+
+// record records a client exchanging packets with an echo server, and returns the pcap file
+// A datagram between other hosts is written first.
+func record(t *testing.T) []byte {
+	t.Helper()
+
+	network := raknettest.NewNetwork(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := network.Serve(ctx, newServer(), serverAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	w, err := pcap.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.WriteDatagram(network.Clock.Now(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 7000},
+		&net.UDPAddr{IP: net.IPv4(10, 0, 0, 8), Port: 7000}, []byte{0x84, 0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := network.Listen(clientAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Track()
+
+	client := raknettest.NewClient(&pcap.Conn{
+		PacketConn: conn,
+		Writer:     w,
+		Clock:      network.Clock,
+	}, serverAddr, network.Clock)
+
+	err = client.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100 && !client.Connected(); i++ {
+		network.Advance(100 * time.Millisecond)
+	}
+
+	if !client.Connected() {
+		t.Fatal("the client didn't connect")
+	}
+
+	for i := 0; i < 10; i++ {
+		err := client.Send([]byte{0x90, byte(i)}, raknet.ReliableOrdered, raknet.DefaultChannel)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		network.Advance(50 * time.Millisecond)
+	}
+
+	network.Advance(time.Second)
+
+	if len(client.Packets()) != 10 {
+		t.Fatalf("echoed %d packets, want 10", len(client.Packets()))
+	}
+
+	// Datagrams after it aren't written to buf
+	w.Close()
+	client.Close()
+
+	return buf.Bytes()
+}
+
+func TestReplay(t *testing.T) {
+	rec, err := replay.ReadPcap(bytes.NewReader(record(t)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Server.String() != serverAddr.String() {
+		t.Fatalf("the server is %s, want %s", rec.Server, serverAddr)
+	}
+
+	clients := rec.Clients()
+	if len(clients) != 1 || clients[0].String() != clientAddr.String() {
+		t.Fatalf("the clients are %v, want [%s]", clients, clientAddr)
+	}
+
+	rep := &replay.Replayer{
+		Skip: replay.SkipACKs,
+	}
+
+	result, err := rep.Replay(context.Background(), newServer(), rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Sent) == 0 || len(result.Responses) == 0 {
+		t.Fatalf("%d datagrams sent and %d responses", len(result.Sent), len(result.Responses))
+	}
+
+	if !result.Equal() {
+		t.Fatalf("the responses are different from the recording: %v", result.Diffs)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Accept is returned by AdmissionFunc to accept the connection
+const Accept = RejectNone
+
+// AdmissionRequest is a request from a new client to connect the server
+type AdmissionRequest struct {
+	// Addr is the client's address
+	Addr *net.UDPAddr
+
+	// GUID is the client's GUID
+	GUID int64
+
+	// MTU is the MTU requested by the client
+	MTU int
+
+	// ProtocolVersion is the Raknet protocol version of the client
+	ProtocolVersion int
+}
+
+// AdmissionFunc decides whether the server accepts a new client.
+// It returns Accept to accept the client, or a reason to reject it.
+//
+// The reason is sent to the client as its packet:
+// RejectNoFreeIncomingConnections, RejectConnectionBanned,
+// RejectIncompatibleProtocol and RejectAlreadyConnected.
+type AdmissionFunc func(req *AdmissionRequest) RejectReason
+
+// pendingConnection is a connection received OpenConnectionRequestOne
+type pendingConnection struct {
+	ProtocolVersion int
+	Time            time.Time
+
+	// ctx is the context of the handshake returned by the server's Observer
+	ctx context.Context
+}
+
+func (ser *Server) storePendingConnection(addr net.Addr, protocolVersion int, ctx context.Context) {
+	ser.pendingConnections.Set(addr.String(), &pendingConnection{
+		ProtocolVersion: protocolVersion,
+		Time:            ser.now(),
+		ctx:             ctx,
+	})
+}
+
+// pendingContext returns the context of the pending connection, such as when the request was resent
+// if there's no pending connection from the address, it returns ctx.
+func (ser *Server) pendingContext(ctx context.Context, addr net.Addr) context.Context {
+	value, ok := ser.pendingConnections.Get(addr.String())
+	if !ok {
+		return ctx
+	}
+
+	pending, ok := value.(*pendingConnection)
+	if !ok {
+		return ctx
+	}
+
+	return pending.ctx
+}
+
+// popPendingConnection returns the pending connection and removes it
+func (ser *Server) popPendingConnection(addr net.Addr) (*pendingConnection, bool) {
+	value, ok := ser.pendingConnections.Pop(addr.String())
+	if !ok {
+		return nil, false
+	}
+
+	pending, ok := value.(*pendingConnection)
+	if !ok {
+		return nil, false
+	}
+
+	if ser.now().Sub(pending.Time) >= ser.HandshakeTimeout {
+		if ser.Observer != nil {
+			ser.Observer.HandshakeAbandoned(pending.ctx)
+		}
+
+		return nil, false
+	}
+
+	return pending, true
+}
+
+// cleanPendingConnections removes pending connections not completed in the handshake timeout
+func (ser *Server) cleanPendingConnections() {
+	for item := range ser.pendingConnections.IterBuffered() {
+		pending, ok := item.Val.(*pendingConnection)
+		if !ok {
+			ser.pendingConnections.Remove(item.Key)
+			continue
+		}
+
+		if ser.now().Sub(pending.Time) >= ser.HandshakeTimeout {
+			ser.pendingConnections.Remove(item.Key)
+
+			if ser.Observer != nil {
+				ser.Observer.HandshakeAbandoned(pending.ctx)
+			}
+		}
+	}
+}
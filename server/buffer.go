@@ -0,0 +1,58 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import "sync"
+
+// Buffers on the receive and send paths are taken from a pool sized to the MTU.
+//
+// Ownership rules:
+//
+// A received datagram is valid only while the server handles it.
+// Packets passed to handlers refer to the buffer, so handlers must copy them to keep.
+// Parts kept over reads, such as split packets and ordered packets waiting
+// for earlier packets, are copied by the session.
+//
+// A payload passed to SendPacket is owned by the session until it's sent,
+// so it must not be modified after the call.
+//
+// Datagrams and ACKs are encoded into a pooled buffer, and the buffer is queued
+// to the writer of the connection, which puts it back after it's written.
+// Packets passed to HandleSendPacket refer to the buffer, except custom packets
+// of sessions, which are copied as the handlers are called after they're queued.
+
+// bufferPool is a pool of byte slices
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{
+		pool: sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, size)
+				return &b
+			},
+		},
+	}
+}
+
+// Get returns a buffer from the pool
+// A pointer is used not to allocate when the buffer is put.
+func (p *bufferPool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// Put puts the buffer back to the pool
+// b mustn't be used after that.
+func (p *bufferPool) Put(b *[]byte) {
+	*b = (*b)[:cap(*b)]
+	p.pool.Put(b)
+}
@@ -0,0 +1,163 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/binary"
+	"github.com/beito123/go-raknet/identifier"
+	"github.com/beito123/go-raknet/protocol"
+)
+
+// discardConn is a net.PacketConn discarding written datagrams
+type discardConn struct{}
+
+func (discardConn) ReadFrom(b []byte) (int, net.Addr, error)     { return 0, nil, net.ErrClosed }
+func (discardConn) WriteTo(b []byte, addr net.Addr) (int, error) { return len(b), nil }
+func (discardConn) Close() error                                 { return nil }
+func (discardConn) LocalAddr() net.Addr                          { return &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132} }
+func (discardConn) SetDeadline(t time.Time) error                { return nil }
+func (discardConn) SetReadDeadline(t time.Time) error            { return nil }
+func (discardConn) SetWriteDeadline(t time.Time) error           { return nil }
+
+// newTestSession returns a connected session of a server not serving
+// Datagrams sent to the session's client are discarded.
+func newTestSession() *Session {
+	ser := &Server{
+		Identifier: identifier.Base{Connection: raknet.ConnectionGoRaknet},
+	}
+
+	ser.init()
+
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}
+
+	session := &Session{
+		ID:              1,
+		addr:            addr,
+		conn:            discardConn{},
+		remoteAddr:      addr,
+		shard:           hashAddr(addr),
+		GUID:            1,
+		Logger:          ser.logger,
+		packetLogger:    ser.packetLogger,
+		MTU:             raknet.MaxMTU,
+		ProtocolVersion: raknet.NetworkProtocol,
+		state:           int32(StateConnected),
+		Server:          ser,
+	}
+
+	session.Init()
+
+	ser.storeSession(session)
+
+	return session
+}
+
+func TestBufferPool(t *testing.T) {
+	pool := newBufferPool(raknet.MaxMTU)
+
+	buf := pool.Get()
+	*buf = (*buf)[:10]
+	pool.Put(buf)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf := pool.Get()
+		*buf = append((*buf)[:0], 1, 2, 3)
+		pool.Put(buf)
+	})
+
+	if allocs != 0 {
+		t.Fatalf("the pool allocates %v times", allocs)
+	}
+}
+
+// maxReceiveAllocs is the number of allocations to receive a datagram with a message
+// They're for decoding the datagram, the session's key from the address and the ACK.
+// Buffers of the datagram and the ACK are taken from the pool.
+const maxReceiveAllocs = 10
+
+// testMessages returns a message of an unreliable user packet
+func testMessages() []*protocol.EncapsulatedPacket {
+	return []*protocol.EncapsulatedPacket{{
+		Reliability: raknet.Unreliable,
+		Payload:     []byte{0x90, 1, 2, 3},
+	}}
+}
+
+// receive returns a function handling a new datagram with the messages for the session
+func receive(session *Session, epks []*protocol.EncapsulatedPacket) func() {
+	var index binary.Triad
+	buf := make([]byte, 0, raknet.MaxMTU)
+	ctx := context.Background()
+
+	return func() {
+		buf = protocol.AppendCustomPacket(buf[:0], protocol.IDCustom4, BumpTriad(&index), epks)
+		session.Server.handlePacket(ctx, session.conn, session.remoteAddr, buf)
+	}
+}
+
+func TestSendAllocs(t *testing.T) {
+	session := newTestSession()
+	epks := testMessages()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		session.SendCustomPacket(epks, false)
+	})
+
+	if allocs != 0 {
+		t.Fatalf("sending a datagram allocates %v times", allocs)
+	}
+}
+
+func TestReceiveAllocs(t *testing.T) {
+	session := newTestSession()
+
+	allocs := testing.AllocsPerRun(100, receive(session, testMessages()))
+	if allocs > maxReceiveAllocs {
+		t.Fatalf("receiving a datagram allocates %v times, want %d or less", allocs, maxReceiveAllocs)
+	}
+}
+
+func BenchmarkBufferPool(b *testing.B) {
+	pool := newBufferPool(raknet.MaxMTU)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		pool.Put(pool.Get())
+	}
+}
+
+func BenchmarkSend(b *testing.B) {
+	session := newTestSession()
+	epks := testMessages()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		session.SendCustomPacket(epks, false)
+	}
+}
+
+func BenchmarkReceive(b *testing.B) {
+	session := newTestSession()
+	f := receive(session, testMessages())
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		f()
+	}
+}
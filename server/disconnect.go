@@ -0,0 +1,58 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+// DisconnectReason is a reason why a session was closed
+type DisconnectReason int
+
+const (
+	// DisconnectClientRequested is a disconnection requested by the client
+	DisconnectClientRequested DisconnectReason = iota
+
+	// DisconnectTimeout is a disconnection by no response from the client
+	DisconnectTimeout
+
+	// DisconnectKicked is a disconnection by the server with a message
+	DisconnectKicked
+
+	// DisconnectBanned is a disconnection by banning the client's address
+	DisconnectBanned
+
+	// DisconnectProtocolError is a disconnection by invalid packets from the client
+	DisconnectProtocolError
+
+	// DisconnectServerShutdown is a disconnection by shutting down the server
+	DisconnectServerShutdown
+)
+
+// String returns the name of the reason
+func (reason DisconnectReason) String() string {
+	switch reason {
+	case DisconnectClientRequested:
+		return "ClientRequested"
+	case DisconnectTimeout:
+		return "Timeout"
+	case DisconnectKicked:
+		return "Kicked"
+	case DisconnectBanned:
+		return "Banned"
+	case DisconnectProtocolError:
+		return "ProtocolError"
+	case DisconnectServerShutdown:
+		return "ServerShutdown"
+	}
+
+	return "Unknown"
+}
+
+// notifies returns whether the server sends DisconnectionNotification to the client
+func (reason DisconnectReason) notifies() bool {
+	return reason != DisconnectClientRequested && reason != DisconnectTimeout
+}
@@ -11,52 +11,347 @@ package server
 
 import (
 	"net"
+	"time"
 
 	raknet "github.com/beito123/go-raknet"
 )
 
 // Handler handles processing from server
-type Handler interface {
+// It implements one or more of the handler interfaces below, such as PacketHandler
+// and OpenedConnHandler, and is notified only of events of the interfaces it implements.
+// Each interface has one method, so handlers implement only the events they need.
+// Embed BaseHandler to implement most of them, or use HandlerFuncs to set functions.
+//
+// Packets passed to handlers refer to the server's buffers, and are valid only
+// during the call. Handlers must copy them to keep after returning.
+type Handler interface{}
+
+// StartServerHandler is a handler notified when the server is started
+type StartServerHandler interface {
 
 	// Start is called when the server is started
 	StartServer()
+}
+
+// CloseServerHandler is a handler notified when the server is closed
+type CloseServerHandler interface {
 
 	// Close is called when the server is closed
 	CloseServer()
+}
+
+// PingHandler is a handler notified of pings from clients
+type PingHandler interface {
 
 	// HandlePing is called when a ping packet is received
 	HandlePing(addr net.Addr)
+}
+
+// OpenedPreConnHandler is a handler notified of new sessions in the handshake
+type OpenedPreConnHandler interface {
 
 	// OpenedPreConn is called when a new client is created before
 	OpenedPreConn(addr net.Addr)
+}
+
+// ClosedPreConnHandler is a handler notified of sessions closed in the handshake
+type ClosedPreConnHandler interface {
+
+	// ClosedPreConn is called once when a client is closed before completing the handshake
+	ClosedPreConn(uid int64)
+}
+
+// OpenedConnHandler is a handler notified of connected sessions
+type OpenedConnHandler interface {
 
 	// OpenedConn is called when a new client is created
 	OpenedConn(uid int64, addr net.Addr)
+}
 
-	// ClosedPreConn is called when a client is closed before
-	ClosedPreConn(uid int64)
+// ClosedConnHandler is a handler notified of connected sessions closed
+type ClosedConnHandler interface {
+
+	// ClosedConn is called once when a connected client is closed
+	// message is a detail of the reason, it may be empty.
+	ClosedConn(uid int64, reason DisconnectReason, message string)
+}
+
+// LegacyClosedConnHandler is a ClosedConnHandler of handlers written before disconnect reasons
+// It's notified only if the handler doesn't implement ClosedConnHandler.
+type LegacyClosedConnHandler interface {
 
-	// ClosedConn is called when a client is closed
+	// ClosedConn is called once when a connected client is closed
 	ClosedConn(uid int64)
+}
+
+// RejectHandler is a handler notified of rejected connections
+type RejectHandler interface {
+
+	// RejectedConn is called when a new connection is rejected
+	RejectedConn(addr net.Addr, reason RejectReason)
+}
+
+// MigrationHandler is a handler notified of sessions moved to new addresses
+type MigrationHandler interface {
+
+	// MigratedConn is called when a session moved to a new client address
+	MigratedConn(uid int64, from net.Addr, to net.Addr)
+}
+
+// TimeoutHandler is a handler notified of timed out sessions
+type TimeoutHandler interface {
 
 	// Timeout is called when a client is timed out
 	Timedout(uid int64)
+}
+
+// AddedBlockedAddressHandler is a handler notified of blocked addresses
+type AddedBlockedAddressHandler interface {
 
 	// AddedBlockedAddress is called when a client is added blocked address
 	AddedBlockedAddress(ip net.IP, reason string)
+}
+
+// RemovedBlockedAddressHandler is a handler notified of unblocked addresses
+type RemovedBlockedAddressHandler interface {
 
 	// RemovedBlockedAddress is called when a client is removed blocked address
 	RemovedBlockedAddress(ip net.IP)
+}
 
-	// HandleSendPacket handles a packet sent from the server to a client
-	HandleSendPacket(addr net.Addr, pk raknet.Packet)
-
-	// HandleRawPacket handles a raw packet no processed in Raknet server
-	HandleRawPacket(addr net.Addr, pk raknet.Packet)
+// PacketHandler is a handler of message packets from clients
+type PacketHandler interface {
 
 	// HandlePacket handles a message packet
 	HandlePacket(uid int64, pk raknet.Packet)
+}
+
+// UnknownPacketHandler is a handler of unknown message packets from clients
+type UnknownPacketHandler interface {
 
 	// HandleUnknownPacket handles a unknown packet
 	HandleUnknownPacket(uid int64, pk raknet.Packet)
 }
+
+// RawPacketHandler is a handler of raw datagrams from clients
+type RawPacketHandler interface {
+
+	// HandleRawPacket handles a raw packet no processed in Raknet server
+	HandleRawPacket(addr net.Addr, pk raknet.Packet)
+}
+
+// SendPacketHandler is a handler of raw datagrams sent to clients
+type SendPacketHandler interface {
+
+	// HandleSendPacket handles a packet sent from the server to a client
+	HandleSendPacket(addr net.Addr, pk raknet.Packet)
+}
+
+// OpenedSessionHandler is an OpenedConnHandler passed the sessions
+// Handlers reply and keep their state with the session, such as Send and SetValue.
+type OpenedSessionHandler interface {
+
+	// OpenedSession is called when a session completed the handshake
+	OpenedSession(session *Session)
+}
+
+// ClosedSessionHandler is a ClosedConnHandler passed the sessions
+type ClosedSessionHandler interface {
+
+	// ClosedSession is called once when a connected session is closed
+	// message is a detail of the reason, it may be empty.
+	ClosedSession(session *Session, reason DisconnectReason, message string)
+}
+
+// SessionPacketHandler is a PacketHandler passed the sessions
+type SessionPacketHandler interface {
+
+	// HandleSessionPacket handles a message packet of the session
+	HandleSessionPacket(session *Session, pk raknet.Packet)
+}
+
+// RTTHandler is a handler notified of round-trip times measured with pings
+type RTTHandler interface {
+
+	// MeasuredRTT is called when a round-trip time to a client is measured
+	MeasuredRTT(uid int64, rtt time.Duration)
+}
+
+// BaseHandler is a handler doing nothing
+// It's embedded in handlers to implement all handler interfaces except LegacyClosedConnHandler,
+// RTTHandler and the handlers passed the sessions.
+type BaseHandler struct{}
+
+func (BaseHandler) StartServer()                                                  {}
+func (BaseHandler) CloseServer()                                                  {}
+func (BaseHandler) HandlePing(addr net.Addr)                                      {}
+func (BaseHandler) OpenedPreConn(addr net.Addr)                                   {}
+func (BaseHandler) OpenedConn(uid int64, addr net.Addr)                           {}
+func (BaseHandler) RejectedConn(addr net.Addr, reason RejectReason)               {}
+func (BaseHandler) MigratedConn(uid int64, from net.Addr, to net.Addr)            {}
+func (BaseHandler) ClosedPreConn(uid int64)                                       {}
+func (BaseHandler) ClosedConn(uid int64, reason DisconnectReason, message string) {}
+func (BaseHandler) Timedout(uid int64)                                            {}
+func (BaseHandler) AddedBlockedAddress(ip net.IP, reason string)                  {}
+func (BaseHandler) RemovedBlockedAddress(ip net.IP)                               {}
+func (BaseHandler) HandleSendPacket(addr net.Addr, pk raknet.Packet)              {}
+func (BaseHandler) HandleRawPacket(addr net.Addr, pk raknet.Packet)               {}
+func (BaseHandler) HandlePacket(uid int64, pk raknet.Packet)                      {}
+func (BaseHandler) HandleUnknownPacket(uid int64, pk raknet.Packet)               {}
+
+// HandlerFuncs is a handler calling the functions set to it
+// Functions not set are skipped. It can be added to Handlers as a value or a pointer.
+type HandlerFuncs struct {
+	StartServerFunc           func()
+	CloseServerFunc           func()
+	HandlePingFunc            func(addr net.Addr)
+	OpenedPreConnFunc         func(addr net.Addr)
+	OpenedConnFunc            func(uid int64, addr net.Addr)
+	RejectedConnFunc          func(addr net.Addr, reason RejectReason)
+	MigratedConnFunc          func(uid int64, from net.Addr, to net.Addr)
+	ClosedPreConnFunc         func(uid int64)
+	ClosedConnFunc            func(uid int64, reason DisconnectReason, message string)
+	TimedoutFunc              func(uid int64)
+	AddedBlockedAddressFunc   func(ip net.IP, reason string)
+	RemovedBlockedAddressFunc func(ip net.IP)
+	HandleSendPacketFunc      func(addr net.Addr, pk raknet.Packet)
+	HandleRawPacketFunc       func(addr net.Addr, pk raknet.Packet)
+	HandlePacketFunc          func(uid int64, pk raknet.Packet)
+	HandleUnknownPacketFunc   func(uid int64, pk raknet.Packet)
+	MeasuredRTTFunc           func(uid int64, rtt time.Duration)
+	OpenedSessionFunc         func(session *Session)
+	ClosedSessionFunc         func(session *Session, reason DisconnectReason, message string)
+	HandleSessionPacketFunc   func(session *Session, pk raknet.Packet)
+}
+
+func (funcs HandlerFuncs) StartServer() {
+	if funcs.StartServerFunc != nil {
+		funcs.StartServerFunc()
+	}
+}
+
+func (funcs HandlerFuncs) CloseServer() {
+	if funcs.CloseServerFunc != nil {
+		funcs.CloseServerFunc()
+	}
+}
+
+func (funcs HandlerFuncs) HandlePing(addr net.Addr) {
+	if funcs.HandlePingFunc != nil {
+		funcs.HandlePingFunc(addr)
+	}
+}
+
+func (funcs HandlerFuncs) OpenedPreConn(addr net.Addr) {
+	if funcs.OpenedPreConnFunc != nil {
+		funcs.OpenedPreConnFunc(addr)
+	}
+}
+
+func (funcs HandlerFuncs) OpenedConn(uid int64, addr net.Addr) {
+	if funcs.OpenedConnFunc != nil {
+		funcs.OpenedConnFunc(uid, addr)
+	}
+}
+
+func (funcs HandlerFuncs) RejectedConn(addr net.Addr, reason RejectReason) {
+	if funcs.RejectedConnFunc != nil {
+		funcs.RejectedConnFunc(addr, reason)
+	}
+}
+
+func (funcs HandlerFuncs) MigratedConn(uid int64, from net.Addr, to net.Addr) {
+	if funcs.MigratedConnFunc != nil {
+		funcs.MigratedConnFunc(uid, from, to)
+	}
+}
+
+func (funcs HandlerFuncs) ClosedPreConn(uid int64) {
+	if funcs.ClosedPreConnFunc != nil {
+		funcs.ClosedPreConnFunc(uid)
+	}
+}
+
+func (funcs HandlerFuncs) ClosedConn(uid int64, reason DisconnectReason, message string) {
+	if funcs.ClosedConnFunc != nil {
+		funcs.ClosedConnFunc(uid, reason, message)
+	}
+}
+
+func (funcs HandlerFuncs) Timedout(uid int64) {
+	if funcs.TimedoutFunc != nil {
+		funcs.TimedoutFunc(uid)
+	}
+}
+
+func (funcs HandlerFuncs) AddedBlockedAddress(ip net.IP, reason string) {
+	if funcs.AddedBlockedAddressFunc != nil {
+		funcs.AddedBlockedAddressFunc(ip, reason)
+	}
+}
+
+func (funcs HandlerFuncs) RemovedBlockedAddress(ip net.IP) {
+	if funcs.RemovedBlockedAddressFunc != nil {
+		funcs.RemovedBlockedAddressFunc(ip)
+	}
+}
+
+func (funcs HandlerFuncs) HandleSendPacket(addr net.Addr, pk raknet.Packet) {
+	if funcs.HandleSendPacketFunc != nil {
+		funcs.HandleSendPacketFunc(addr, pk)
+	}
+}
+
+func (funcs HandlerFuncs) HandleRawPacket(addr net.Addr, pk raknet.Packet) {
+	if funcs.HandleRawPacketFunc != nil {
+		funcs.HandleRawPacketFunc(addr, pk)
+	}
+}
+
+func (funcs HandlerFuncs) HandlePacket(uid int64, pk raknet.Packet) {
+	if funcs.HandlePacketFunc != nil {
+		funcs.HandlePacketFunc(uid, pk)
+	}
+}
+
+func (funcs HandlerFuncs) HandleUnknownPacket(uid int64, pk raknet.Packet) {
+	if funcs.HandleUnknownPacketFunc != nil {
+		funcs.HandleUnknownPacketFunc(uid, pk)
+	}
+}
+
+func (funcs HandlerFuncs) MeasuredRTT(uid int64, rtt time.Duration) {
+	if funcs.MeasuredRTTFunc != nil {
+		funcs.MeasuredRTTFunc(uid, rtt)
+	}
+}
+
+func (funcs HandlerFuncs) OpenedSession(session *Session) {
+	if funcs.OpenedSessionFunc != nil {
+		funcs.OpenedSessionFunc(session)
+	}
+}
+
+func (funcs HandlerFuncs) ClosedSession(session *Session, reason DisconnectReason, message string) {
+	if funcs.ClosedSessionFunc != nil {
+		funcs.ClosedSessionFunc(session, reason, message)
+	}
+}
+
+func (funcs HandlerFuncs) HandleSessionPacket(session *Session, pk raknet.Packet) {
+	if funcs.HandleSessionPacketFunc != nil {
+		funcs.HandleSessionPacketFunc(session, pk)
+	}
+}
+
+// hasSendPacketHandler returns whether any handler handles sent packets
+func (handlers Handlers) hasSendPacketHandler() bool {
+	for _, handler := range handlers {
+		_, ok := handler.(SendPacketHandler)
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
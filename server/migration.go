@@ -0,0 +1,174 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"net"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/protocol"
+)
+
+// MigrationToken returns the token to migrate the session to a new address
+// It's nil if migration isn't enabled or the session isn't connected yet.
+func (session *Session) MigrationToken() []byte {
+	session.sendMutex.Lock()
+	defer session.sendMutex.Unlock()
+
+	if session.migrationToken == nil {
+		return nil
+	}
+
+	token := make([]byte, len(session.migrationToken))
+	copy(token, session.migrationToken)
+
+	return token
+}
+
+// sendMigrationToken generates a new token and sends it to the client
+func (session *Session) sendMigrationToken() {
+	token := make([]byte, protocol.MigrationTokenSize)
+
+	_, err := rand.Read(token)
+	if err != nil {
+		session.Logger.Error("Failed to generate a migration token", "error", err)
+		return
+	}
+
+	pk := &protocol.SessionMigration{
+		ClientGUID: session.GUID,
+		Token:      token,
+	}
+
+	err = pk.Encode()
+	if err != nil {
+		session.Logger.Warn("Failed to encode a packet", "packet", pk.ID(), "error", err)
+		return
+	}
+
+	session.sendMutex.Lock()
+	session.migrationToken = token
+	session.sendMutex.Unlock()
+
+	_, err = session.SendPacket(pk, raknet.ReliableOrdered, raknet.DefaultChannel)
+	if err != nil {
+		session.Logger.Warn("Failed to send a packet", "packet", pk.ID(), "error", err)
+	}
+}
+
+// validMigrationToken returns whether the token is the session's token
+func (session *Session) validMigrationToken(token []byte) bool {
+	session.sendMutex.Lock()
+	defer session.sendMutex.Unlock()
+
+	if session.migrationToken == nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(session.migrationToken, token) == 1
+}
+
+// migrateSession moves the session requested by the client to the address
+// Invalid requests are counted per ip address, and ignored over the limit.
+func (ser *Server) migrateSession(conn net.PacketConn, raddr net.Addr, addr *net.UDPAddr, pk *protocol.SessionMigration) {
+	if ser.countMigrationFailures(addr.IP) >= raknet.MaxMigrationFailuresPerSecond {
+		return
+	}
+
+	session, ok := ser.GetSessionGUID(pk.ClientGUID)
+	if !ok || session.State() != StateConnected || !session.validMigrationToken(pk.Token) {
+		ser.addMigrationFailure(addr.IP)
+
+		ser.logger.Debug("Invalid session migration", "addr", addr, "guid", pk.ClientGUID)
+
+		return
+	}
+
+	from, ok := ser.moveSession(session, conn, raddr, addr)
+	if !ok {
+		return
+	}
+
+	for _, handler := range ser.Handlers {
+		h, ok := handler.(MigrationHandler)
+		if ok {
+			h.MigratedConn(session.GUID, from, addr)
+		}
+	}
+
+	// A token seen on the old path can't be used again
+	session.sendMigrationToken()
+}
+
+// moveSession moves the session to the address, and returns the old address
+// It returns false if the session can't be moved to the address now.
+func (ser *Server) moveSession(session *Session, conn net.PacketConn, raddr net.Addr, addr *net.UDPAddr) (*net.UDPAddr, bool) {
+	// Requests are handled by several readers, so the checks and the move are done at once
+	ser.migrationMutex.Lock()
+	defer ser.migrationMutex.Unlock()
+
+	// The request is resent until the client receives packets on the new address
+	from := session.Addr()
+
+	if equalUDPAddr(from, addr) {
+		return nil, false
+	}
+
+	if ser.HasSession(addr) {
+		ser.logger.Debug("Session migration to the address used already", "addr", addr, "guid", session.GUID)
+		return nil, false
+	}
+
+	if !session.lastMigrationTime.IsZero() &&
+		ser.now().Sub(session.lastMigrationTime) < ser.MigrationInterval {
+		return nil, false
+	}
+
+	if ser.MaxConnectionsPerIP > 0 && !from.IP.Equal(addr.IP) &&
+		ser.CountIP(addr.IP) >= ser.MaxConnectionsPerIP {
+		return nil, false
+	}
+
+	ser.removeSession(session)
+
+	session.setPath(conn, raddr, addr)
+	session.lastMigrationTime = ser.now()
+
+	ser.storeSession(session)
+
+	return from, true
+}
+
+func (ser *Server) countMigrationFailures(ip net.IP) int {
+	value, ok := ser.migrationFailures.Get(ip.String())
+	if !ok {
+		return 0
+	}
+
+	count, ok := value.(int)
+	if !ok {
+		return 0
+	}
+
+	return count
+}
+
+func (ser *Server) addMigrationFailure(ip net.IP) {
+	ser.migrationFailures.Upsert(ip.String(), 1, func(exist bool, value interface{}, n interface{}) interface{} {
+		count, ok := value.(int)
+		if !exist || !ok {
+			return n
+		}
+
+		return count + 1
+	})
+}
@@ -0,0 +1,91 @@
+package server_test
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/raknettest"
+	"github.com/beito123/go-raknet/server"
+)
+
+func TestShardedMigration(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser := serveWith(t, network, &server.Server{
+		MaxConnections:    10,
+		Shards:            4,
+		MigrationEnabled:  true,
+		MigrationInterval: time.Millisecond,
+	})
+
+	client := dial(t, network)
+
+	for i := 0; i < 10 && client.MigrationToken() == nil; i++ {
+		network.Advance(10 * time.Millisecond)
+	}
+
+	if client.MigrationToken() == nil {
+		t.Fatal("the client didn't receive a migration token")
+	}
+
+	sent := 0
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			client.Send([]byte{0x90, byte(sent)}, raknet.ReliableOrdered, raknet.DefaultChannel)
+			sent++
+		}
+	}
+
+	// Datagrams from the old and the new addresses are handled in the session's shard
+	for i := 0; i < 8; i++ {
+		conn, err := network.Listen(fmt.Sprintf("10.0.1.%d:5000", i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		send(5)
+
+		err = client.Migrate(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		send(5)
+
+		for j := 0; j < 100 && len(client.Packets()) < sent; j++ {
+			network.Advance(10 * time.Millisecond)
+		}
+
+		addr := session(t, ser).Addr()
+		if addr.String() != conn.LocalAddr().String() {
+			t.Fatalf("the session is on %s, want %s", addr, conn.LocalAddr())
+		}
+	}
+
+	got := client.Packets()
+	if len(got) != sent {
+		t.Fatalf("echoed %d packets, want %d", len(got), sent)
+	}
+
+	for i, pk := range got {
+		if pk[1] != byte(i) {
+			t.Fatalf("packet %d is echoed at %d", pk[1], i)
+		}
+	}
+
+	if ser.Count() != 1 || ser.HasSession(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}) {
+		t.Fatal("the session is stored with the old address")
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"net"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+)
+
+// HandshakeStep is a step of the handshake from a client
+type HandshakeStep int
+
+const (
+	// StepOpenConnectionRequestOne is the first request of the offline handshake
+	// Clients may resend it with smaller MTUs until they get a response.
+	StepOpenConnectionRequestOne HandshakeStep = iota
+
+	// StepOpenConnectionRequestTwo is the second request, which creates the session
+	StepOpenConnectionRequestTwo
+
+	// StepConnectionRequest is the first request of the online handshake
+	StepConnectionRequest
+
+	// StepNewIncomingConnection completes the handshake
+	StepNewIncomingConnection
+)
+
+func (step HandshakeStep) String() string {
+	switch step {
+	case StepOpenConnectionRequestOne:
+		return "OpenConnectionRequestOne"
+	case StepOpenConnectionRequestTwo:
+		return "OpenConnectionRequestTwo"
+	case StepConnectionRequest:
+		return "ConnectionRequest"
+	case StepNewIncomingConnection:
+		return "NewIncomingConnection"
+	}
+
+	return "Unknown"
+}
+
+// Handshake is a step of the handshake accepted by the server
+type Handshake struct {
+	Step HandshakeStep
+
+	// Addr is the client's address
+	Addr *net.UDPAddr
+
+	// GUID is the client's GUID, it's 0 at StepOpenConnectionRequestOne
+	GUID int64
+
+	// MTU is the MTU requested by the client
+	MTU int
+
+	// ProtocolVersion is the Raknet protocol version of the client
+	ProtocolVersion int
+}
+
+// Observer observes the connection lifecycle of clients, such as to record tracing spans
+// It's called in goroutines handling packets, so it should return quickly.
+type Observer interface {
+
+	// Handshake is called when the server accepted a step of the handshake
+	// ctx is the context returned at the previous step, or the server's context at the first step.
+	// The context returned at StepOpenConnectionRequestTwo becomes the session's context,
+	// and ones returned at later steps are ignored.
+	Handshake(ctx context.Context, hs *Handshake) context.Context
+
+	// HandshakeAbandoned is called when a client sent OpenConnectionRequestOne,
+	// but a session wasn't created until raknet.SessionTimeout
+	HandshakeAbandoned(ctx context.Context)
+
+	// Closed is called when a session is closed, in the handshake or after connected
+	Closed(ctx context.Context, session *Session, reason DisconnectReason, message string)
+
+	// Delivered is called after handlers handled a message of the session
+	// start and d are the time passing the message to handlers and the time they took,
+	// which are measured with the system clock.
+	Delivered(ctx context.Context, session *Session, pk raknet.Packet, start time.Time, d time.Duration)
+}
+
+// observeHandshake notifies the observer of the step, and returns the context of the next steps
+func (ser *Server) observeHandshake(ctx context.Context, hs *Handshake) context.Context {
+	if ser.Observer == nil {
+		return ctx
+	}
+
+	return ser.Observer.Handshake(ctx, hs)
+}
+
+// observeHandshake notifies the server's observer of the step of the session
+func (session *Session) observeHandshake(step HandshakeStep) {
+	if session.Server.Observer == nil {
+		return
+	}
+
+	session.Server.Observer.Handshake(session.Context(), &Handshake{
+		Step:            step,
+		Addr:            session.Addr(),
+		GUID:            session.GUID,
+		MTU:             session.MTU,
+		ProtocolVersion: session.ProtocolVersion,
+	})
+}
+
+// Context returns the session's context
+// It's canceled when the session is closed, and has values of the server's Observer, such as a tracing span.
+func (session *Session) Context() context.Context {
+	if session.ctx == nil {
+		return context.Background()
+	}
+
+	return session.ctx
+}
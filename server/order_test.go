@@ -0,0 +1,89 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"testing"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/binary"
+	"github.com/beito123/go-raknet/protocol"
+)
+
+// tracerFunc is a Tracer calling the function
+type tracerFunc func(event *TraceEvent)
+
+func (f tracerFunc) Trace(event *TraceEvent) {
+	f(event)
+}
+
+func TestStaleOrderedMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		start binary.Triad
+		// indexes are the order indexes of the messages received
+		indexes []binary.Triad
+		next    binary.Triad
+		want    []Delivery
+	}{
+		// The second message has a new message index, but the order index was delivered already
+		{"stale", 0, []binary.Triad{0, 0, 1}, 2,
+			[]Delivery{Delivered, DeliveryDuplicate, Delivered}},
+
+		// Indexes wrap around like Bump, so 1 is after binary.MaxTriad and is kept until it's delivered
+		{"wraparound", binary.MaxTriad - 1, []binary.Triad{binary.MaxTriad - 1, binary.MaxTriad - 2, 1, binary.MaxTriad}, 2,
+			[]Delivery{Delivered, DeliveryDuplicate, DeliveryBuffered, Delivered, Delivered}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := newTestSession()
+			session.orderReceiveIndex[raknet.DefaultChannel] = test.start
+
+			var deliveries []Delivery
+			session.Server.Tracer = tracerFunc(func(event *TraceEvent) {
+				if event.Layer == LayerDelivery {
+					deliveries = append(deliveries, event.Delivery)
+				}
+			})
+
+			for i, orderIndex := range test.indexes {
+				session.handleEncapsulated(&protocol.EncapsulatedPacket{
+					Reliability:  raknet.ReliableOrdered,
+					MessageIndex: binary.Triad(i),
+					OrderIndex:   orderIndex,
+					Payload:      []byte{0x90, byte(i)},
+				})
+			}
+
+			if len(deliveries) != len(test.want) {
+				t.Fatalf("deliveries are %v, want %v", deliveries, test.want)
+			}
+
+			for i := range test.want {
+				if deliveries[i] != test.want[i] {
+					t.Fatalf("deliveries are %v, want %v", deliveries, test.want)
+				}
+			}
+
+			if len(session.handleQueue[raknet.DefaultChannel]) != 0 {
+				t.Fatalf("%d messages are left in the order queue", len(session.handleQueue[raknet.DefaultChannel]))
+			}
+
+			if session.orderReceiveIndex[raknet.DefaultChannel] != test.next {
+				t.Fatalf("the next order index is %d, want %d", session.orderReceiveIndex[raknet.DefaultChannel], test.next)
+			}
+
+			if session.Stats().DuplicatesDropped != 1 {
+				t.Fatalf("%d duplicates dropped, want 1", session.Stats().DuplicatesDropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/protocol"
+)
+
+// batchReader reads several datagrams at once
+type batchReader interface {
+
+	// ReadBatch reads datagrams into bufs, and returns the number of read datagrams
+	// The length of each read buffer is set to the size of its datagram,
+	// and its address is set to addrs at the same index.
+	ReadBatch(bufs []*[]byte, addrs []net.Addr) (n int, err error)
+}
+
+// received is a datagram waiting to be handled in a shard
+type received struct {
+	conn net.PacketConn
+	addr net.Addr
+	buf  *[]byte
+}
+
+// shards handle received datagrams in several goroutines
+// Each session is assigned to one of them, so packets from a client are handled in order.
+type shards struct {
+	queues []chan received
+}
+
+func newShards(ctx context.Context, ser *Server) *shards {
+	s := &shards{
+		queues: make([]chan received, ser.Shards),
+	}
+
+	for i := range s.queues {
+		s.queues[i] = make(chan received, raknet.ShardQueueSize)
+
+		go s.run(ctx, ser, s.queues[i])
+	}
+
+	return s
+}
+
+func (s *shards) run(ctx context.Context, ser *Server, queue chan received) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rv := <-queue:
+			ser.handlePacket(ctx, rv.conn, rv.addr, *rv.buf)
+			ser.buffers.Put(rv.buf)
+
+			atomic.AddInt64(&ser.pending, -1)
+		}
+	}
+}
+
+// dispatch passes the datagram to the shard for the session or the address
+// It blocks while the shard's queue is full, so the reader slows down with the shard.
+func (s *shards) dispatch(ctx context.Context, ser *Server, rv received) {
+	queue := s.queues[s.shard(ser, rv)%uint32(len(s.queues))]
+
+	atomic.AddInt64(&ser.pending, 1)
+
+	select {
+	case <-ctx.Done():
+		atomic.AddInt64(&ser.pending, -1)
+		ser.buffers.Put(rv.buf)
+	case queue <- rv:
+	}
+}
+
+// shard returns the shard's hash for the datagram
+// A session keeps the shard of its first address, so its datagrams are handled
+// in one goroutine after it migrated, including the migration request.
+func (s *shards) shard(ser *Server, rv received) uint32 {
+	session, ok := ser.restoreSession(rv.addr)
+	if ok {
+		return session.shard
+	}
+
+	b := *rv.buf
+	if ser.MigrationEnabled && b[0] == protocol.IDSessionMigration {
+		pk := &protocol.SessionMigration{}
+		pk.SetBytes(b)
+
+		err := pk.Decode()
+		if err == nil {
+			session, ok := ser.GetSessionGUID(pk.ClientGUID)
+			if ok {
+				return session.shard
+			}
+		}
+	}
+
+	return hashAddr(rv.addr)
+}
+
+// serveConn reads packets from the connection, and handles them
+func (ser *Server) serveConn(ctx context.Context, conn net.PacketConn) error {
+	if ser.BatchReads {
+		batch := newBatchReader(conn)
+		if batch != nil {
+			return ser.serveConnBatch(ctx, conn, batch)
+		}
+	}
+
+	for {
+		buf := ser.buffers.Get()
+
+		n, raddr, err := conn.ReadFrom(*buf)
+		if err != nil {
+			ser.buffers.Put(buf)
+
+			return ser.readError(ctx, err)
+		}
+
+		ser.receive(ctx, conn, raddr, buf, n)
+	}
+}
+
+// serveConnBatch reads packets from the connection with the batch reader, and handles them
+func (ser *Server) serveConnBatch(ctx context.Context, conn net.PacketConn, batch batchReader) error {
+	bufs := make([]*[]byte, raknet.MaxReadBatch)
+	addrs := make([]net.Addr, raknet.MaxReadBatch)
+
+	for {
+		for i := range bufs {
+			if bufs[i] == nil {
+				bufs[i] = ser.buffers.Get()
+			}
+		}
+
+		n, err := batch.ReadBatch(bufs, addrs)
+		if err != nil {
+			return ser.readError(ctx, err)
+		}
+
+		for i := 0; i < n; i++ {
+			ser.receive(ctx, conn, addrs[i], bufs[i], len(*bufs[i]))
+
+			bufs[i] = nil
+			addrs[i] = nil
+		}
+	}
+}
+
+func (ser *Server) readError(ctx context.Context, err error) error {
+	select {
+	case <-ctx.Done():
+		//Shutting down listener
+		ser.logger.Info("Shutting down listener")
+		return nil
+	default:
+		return err
+	}
+}
+
+// receive handles the datagram of n bytes in buf, or passes it to a shard
+// It takes the ownership of buf.
+func (ser *Server) receive(ctx context.Context, conn net.PacketConn, addr net.Addr, buf *[]byte, n int) {
+	// The buffer has a byte over the MTU to find bigger packets
+	if n <= 0 || n > ser.MTU {
+		ser.buffers.Put(buf)
+		return
+	}
+
+	*buf = (*buf)[:n]
+
+	if ser.shards != nil {
+		ser.shards.dispatch(ctx, ser, received{conn: conn, addr: addr, buf: buf})
+		return
+	}
+
+	ser.handlePacket(ctx, conn, addr, *buf)
+
+	ser.buffers.Put(buf)
+}
@@ -0,0 +1,136 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	raknet "github.com/beito123/go-raknet"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// mmsgReader reads datagrams with recvmmsg
+type mmsgReader struct {
+	read func(ms []ipv4.Message, flags int) (int, error)
+	msgs []ipv4.Message
+}
+
+// newBatchReader returns a reader with recvmmsg for *net.UDPConn, or nil for others
+func newBatchReader(conn net.PacketConn) batchReader {
+	c, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+
+	laddr, ok := c.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+
+	r := &mmsgReader{
+		msgs: make([]ipv4.Message, raknet.MaxReadBatch),
+	}
+
+	for i := range r.msgs {
+		r.msgs[i].Buffers = make([][]byte, 1)
+	}
+
+	// ipv4.Message and ipv6.Message are the same type
+	if laddr.IP.To4() != nil {
+		r.read = ipv4.NewPacketConn(c).ReadBatch
+	} else {
+		r.read = ipv6.NewPacketConn(c).ReadBatch
+	}
+
+	return r
+}
+
+func (r *mmsgReader) ReadBatch(bufs []*[]byte, addrs []net.Addr) (int, error) {
+	n := len(bufs)
+	if n > len(r.msgs) {
+		n = len(r.msgs)
+	}
+
+	for i := 0; i < n; i++ {
+		r.msgs[i].Buffers[0] = (*bufs[i])[:cap(*bufs[i])]
+	}
+
+	read, err := r.read(r.msgs[:n], 0)
+
+	for i := 0; i < read; i++ {
+		*bufs[i] = (*bufs[i])[:r.msgs[i].N]
+		addrs[i] = r.msgs[i].Addr
+	}
+
+	for i := 0; i < n; i++ {
+		r.msgs[i].Buffers[0] = nil
+		r.msgs[i].Addr = nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return read, nil
+}
+
+// listenPacket listens on the address with n sockets
+// The sockets share the port with SO_REUSEPORT, and the kernel spreads clients over them.
+func listenPacket(network string, addr *net.UDPAddr, n int) ([]net.PacketConn, error) {
+	if n <= 1 {
+		conn, err := net.ListenUDP(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return []net.PacketConn{conn}, nil
+	}
+
+	config := net.ListenConfig{
+		Control: func(network string, address string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+
+			return serr
+		},
+	}
+
+	conns := make([]net.PacketConn, 0, n)
+	address := addr.String()
+
+	for i := 0; i < n; i++ {
+		conn, err := config.ListenPacket(context.Background(), network, address)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+
+			return nil, err
+		}
+
+		// Others listen on the port given to the first socket
+		if i == 0 {
+			address = conn.LocalAddr().String()
+		}
+
+		conns = append(conns, conn)
+	}
+
+	return conns, nil
+}
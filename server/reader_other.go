@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import "net"
+
+// newBatchReader returns nil, recvmmsg is only supported on Linux
+func newBatchReader(conn net.PacketConn) batchReader {
+	return nil
+}
+
+// listenPacket listens on the address with a socket
+// SO_REUSEPORT is only used on Linux, so n is ignored.
+func listenPacket(network string, addr *net.UDPAddr, n int) ([]net.PacketConn, error) {
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return []net.PacketConn{conn}, nil
+}
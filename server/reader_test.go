@@ -0,0 +1,130 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/beito123/go-raknet/protocol"
+)
+
+func TestShardMigration(t *testing.T) {
+	session := newTestSession()
+
+	ser := session.Server
+	ser.MigrationEnabled = true
+
+	s := &shards{queues: make([]chan received, 4)}
+	n := uint32(len(s.queues))
+
+	route := func(addr net.Addr, b []byte) uint32 {
+		return s.shard(ser, received{addr: addr, buf: &b}) % n
+	}
+
+	// The new address is assigned to another shard by its hash
+	from := session.Addr()
+	to := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 5000}
+	for hashAddr(to)%n == hashAddr(from)%n {
+		to.Port++
+	}
+
+	pk := &protocol.SessionMigration{
+		ClientGUID: session.GUID,
+		Token:      make([]byte, protocol.MigrationTokenSize),
+	}
+
+	err := pk.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if route(to, pk.Bytes()) != session.shard%n {
+		t.Fatal("the migration request is handled in another shard than the session")
+	}
+
+	ser.removeSession(session)
+	session.setPath(session.Conn(), to, to)
+	ser.storeSession(session)
+
+	if route(to, []byte{protocol.IDCustom4}) != session.shard%n {
+		t.Fatal("datagrams from the new address are handled in another shard than the session")
+	}
+
+	if route(from, []byte{protocol.IDCustom4}) != hashAddr(from)%n {
+		t.Fatal("datagrams from an unknown address aren't assigned by the address")
+	}
+}
+
+// slowStore is a SessionStore taking time to remove sessions
+type slowStore struct {
+	SessionStore
+}
+
+func (store slowStore) Remove(session *Session) {
+	time.Sleep(10 * time.Millisecond)
+
+	store.SessionStore.Remove(session)
+}
+
+func TestConcurrentMigration(t *testing.T) {
+	session := newTestSession()
+
+	ser := session.Server
+	ser.MigrationEnabled = true
+
+	// Other requests are handled while the session is moved
+	ser.sessions = slowStore{ser.sessions}
+
+	session.migrationToken = make([]byte, protocol.MigrationTokenSize)
+
+	var migrated int32
+	ser.Handlers = Handlers{&HandlerFuncs{
+		MigratedConnFunc: func(uid int64, from net.Addr, to net.Addr) {
+			atomic.AddInt32(&migrated, 1)
+		},
+	}}
+
+	// Requests to other addresses are handled by readers at the same time,
+	// and the session is moved once in MigrationInterval
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			<-start
+
+			to := &net.UDPAddr{IP: net.IPv4(10, 0, 1, byte(i+1)), Port: 5000}
+
+			ser.migrateSession(session.Conn(), to, to, &protocol.SessionMigration{
+				ClientGUID: session.GUID,
+				Token:      make([]byte, protocol.MigrationTokenSize),
+			})
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	if atomic.LoadInt32(&migrated) != 1 {
+		t.Fatalf("the session migrated %d times, want 1", migrated)
+	}
+
+	if ser.Count() != 1 || !ser.HasSession(session.Addr()) {
+		t.Fatal("the session isn't stored with the new address only")
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/protocol"
+)
+
+// RejectReason is a reason why the server rejected a new connection
+type RejectReason int
+
+const (
+	// RejectNone means the connection wasn't rejected
+	RejectNone RejectReason = iota
+
+	// RejectAlreadyConnected is a connection from a client connected already
+	RejectAlreadyConnected
+
+	// RejectNoFreeIncomingConnections is a connection over the connection limits
+	RejectNoFreeIncomingConnections
+
+	// RejectConnectionBanned is a connection from a blocked address
+	RejectConnectionBanned
+
+	// RejectIncompatibleProtocol is a connection with an unsupported network protocol
+	RejectIncompatibleProtocol
+
+	// RejectIPRecentlyConnected is a connection from an address connected recently
+	RejectIPRecentlyConnected
+
+	// RejectHandshakeNotStarted is a OpenConnectionRequestTwo without OpenConnectionRequestOne
+	// Nothing is sent to the client.
+	RejectHandshakeNotStarted
+)
+
+// String returns the name of the reason
+func (reason RejectReason) String() string {
+	switch reason {
+	case RejectNone:
+		return "None"
+	case RejectAlreadyConnected:
+		return "AlreadyConnected"
+	case RejectNoFreeIncomingConnections:
+		return "NoFreeIncomingConnections"
+	case RejectConnectionBanned:
+		return "ConnectionBanned"
+	case RejectIncompatibleProtocol:
+		return "IncompatibleProtocol"
+	case RejectIPRecentlyConnected:
+		return "IPRecentlyConnected"
+	case RejectHandshakeNotStarted:
+		return "HandshakeNotStarted"
+	}
+
+	return "Unknown"
+}
+
+// rejectPacket returns a packet to tell the client the reason
+func (ser *Server) rejectPacket(reason RejectReason) raknet.Packet {
+	switch reason {
+	case RejectAlreadyConnected:
+		return &protocol.AlreadyConnected{}
+	case RejectNoFreeIncomingConnections:
+		return &protocol.NoFreeIncomingConnections{}
+	case RejectConnectionBanned:
+		return &protocol.ConnectionBanned{
+			ServerGUID: ser.uid,
+		}
+	case RejectIncompatibleProtocol:
+		return &protocol.IncompatibleProtocol{
+			NetworkProtocol: byte(ser.NetworkProtocol),
+			ServerGuid:      ser.uid,
+		}
+	case RejectIPRecentlyConnected:
+		return &protocol.IPRecentlyConnected{
+			ServerGUID: ser.uid,
+		}
+	}
+
+	return nil
+}
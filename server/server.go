@@ -13,6 +13,8 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/beito123/go-raknet/identifier"
@@ -34,15 +36,32 @@ const (
 	StateClosed
 )
 
+func (state ServerState) String() string {
+	switch state {
+	case StateNew:
+		return "New"
+	case StateRunning:
+		return "Running"
+	case StateClosed:
+		return "Closed"
+	}
+
+	return "Unknown"
+}
+
 var (
-	errAlreadyRunning           = errors.New("already running")
-	errServerClosed             = errors.New("server closed")
+	errAlreadyRunning = errors.New("already running")
+	errServerClosed   = errors.New("server closed")
 )
 
 type Handlers []Handler
 
 type Server struct {
-	Logger          raknet.Logger
+	// Logger logs messages of the server and sessions
+	// Warnings for received packets are limited to raknet.MaxPacketWarnings per raknet.PacketWarningInterval.
+	// if it's nil, messages are discarded.
+	Logger raknet.Logger
+
 	Handlers        Handlers
 	MaxConnections  int
 	MTU             int
@@ -50,22 +69,155 @@ type Server struct {
 	NetworkProtocol int
 	protocol        *protocol.Protocol
 
+	// Network is the network to listen in ListenAndServe
+	// "udp" listens IPv4 and IPv6 on dual-stack, "udp4" and "udp6" listen only the version.
+	// if it's empty, "udp" is used.
+	Network string
+
+	// NetworkProtocols is Raknet protocol versions accepted by the server
+	// if it's empty, the server accepts only NetworkProtocol.
+	// NetworkProtocol is sent to clients with an incompatible version,
+	// if it's 0 or less, the newest version of them is used.
+	NetworkProtocols []int
+
+	// InternalAddressCount is the number of internal addresses sent in the handshake
+	// Minecraft clients expect raknet.MinecraftSystemAddressCount.
+	// if it's 0 or less, raknet.SystemAddressCount is used.
+	InternalAddressCount int
+
 	cancel context.CancelFunc
 
 	UUID uuid.UUID
 
 	// BroadcastingEnabled broadcast the server for the outside
 	// if it enabled, the server send UnconnectedPong when received UnconnectPing.
+	// It's read when the server is started, use SetBroadcastingEnabled to change it while running.
 	BroadcastingEnabled bool
 
-	conn   *net.UDPConn
-	port   uint16
-	state  ServerState
-	uid    int64
-	pongid int64
-
-	sessions         cmap.ConcurrentMap
-	blockedAddresses cmap.ConcurrentMap
+	// MaxConnectionsPerIP is the maximum number of sessions from the same ip address
+	// if it's 0 or less, the number isn't limited.
+	MaxConnectionsPerIP int
+
+	// ReconnectCooldown is the time until an ip address can connect again after connected
+	// if it's 0 or less, the server doesn't check recent connections.
+	ReconnectCooldown time.Duration
+
+	// HandshakeTimeout is the time until a session not completed the handshake is closed
+	// if it's 0 or less, raknet.HandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+
+	// MaxHandshakingSessions is the maximum number of sessions in the handshake
+	// if it's 0 or less, the number isn't limited.
+	MaxHandshakingSessions int
+
+	// MaxPendingConnections is the maximum number of clients sent OpenConnectionRequestOne
+	// and not OpenConnectionRequestTwo yet
+	// if it's 0 or less, raknet.MaxPendingConnections is used.
+	MaxPendingConnections int
+
+	// Clock is a source of the current time used in the server and sessions
+	// if it's nil, the system clock is used.
+	Clock raknet.Clock
+
+	// MigrationEnabled enables moving a connected session to a new client address
+	// such as when the client's NAT changed the port. The client proves the session
+	// with the GUID and a token sent from the server. The token is sent in plain text,
+	// so it only protects from attackers who can't see the traffic.
+	MigrationEnabled bool
+
+	// MigrationInterval is the minimum time between migrations of a session
+	// if it's 0 or less, raknet.MigrationInterval is used.
+	MigrationInterval time.Duration
+
+	// Admission decides whether the server accepts a new client
+	// if it's nil, the server accepts all valid clients.
+	Admission AdmissionFunc
+
+	// Writers is the number of goroutines writing datagrams on each connection
+	// Datagrams to an address are always written by the same goroutine, so they're sent in order.
+	// if it's 0 or less, 1 is used.
+	Writers int
+
+	// WriteQueueSize is the number of datagrams waiting to be written by each writer goroutine
+	// Sessions stop sending new packets while the queue is almost full,
+	// and other datagrams are dropped when it's full.
+	// if it's 0 or less, raknet.WriteQueueSize is used.
+	WriteQueueSize int
+
+	// BatchWrites writes queued datagrams at once with sendmmsg
+	// It's only supported for *net.UDPConn on Linux, and ignored on others.
+	BatchWrites bool
+
+	// WriteError is called when the server failed to write a datagram
+	// if it's nil, errors are logged as debug messages.
+	WriteError WriteErrorFunc
+
+	// BatchReads reads datagrams at once with recvmmsg
+	// It's only supported for *net.UDPConn on Linux, and ignored on others.
+	BatchReads bool
+
+	// Listeners is the number of sockets opened on each address in ListenAndServe
+	// The sockets share the port with SO_REUSEPORT, and each of them has a reader goroutine.
+	// It's only supported on Linux, if it's 1 or less, or on others, a socket is opened.
+	Listeners int
+
+	// SessionStore stores sessions of the server
+	// if it's nil, sessions are stored in memory.
+	SessionStore SessionStore
+
+	// Observer observes the connection lifecycle of clients, such as to record tracing spans
+	// Sessions have contexts returned by it, which handlers get by Session.Context.
+	// if it's nil, the lifecycle isn't observed.
+	Observer Observer
+
+	// Tracer is notified of datagrams and messages of sessions at each layer, such as a JSONTracer
+	// if it's nil, packets aren't traced.
+	Tracer Tracer
+
+	// Shards is the number of goroutines handling received packets
+	// Each session is assigned to one of them by its first address, and keeps it after migration,
+	// so packets from a client are handled in order.
+	// if it's 0 or less, packets are handled in the reader goroutine of each connection.
+	Shards int
+
+	conns     []net.PacketConn
+	writers   map[net.PacketConn]*writer
+	shards    *shards
+	buffers   *bufferPool
+	port      uint16
+	uid       int64
+	pongid    int64
+	startTime time.Time
+
+	// state is the ServerState, read and written atomically
+	state int32
+
+	// broadcasting is BroadcastingEnabled while the server is running, 1 if it's enabled
+	broadcasting int32
+
+	// pending is the number of datagrams queued in shards and writers, see Pending
+	pending int64
+
+	// lastCleanupTime is the last time expired entries were cleaned in update
+	lastCleanupTime time.Time
+
+	// logger is Logger, or a NopLogger if it's nil
+	logger raknet.Logger
+
+	// packetLogger logs warnings for received packets with a rate limit
+	packetLogger raknet.Logger
+
+	sessions           SessionStore
+	sessionCounts      *sessionCounts
+	sessionID          uint64
+	stats              *netStats
+	blockedAddresses   cmap.ConcurrentMap
+	recentConnections  cmap.ConcurrentMap
+	pendingConnections cmap.ConcurrentMap
+	migrationFailures  cmap.ConcurrentMap
+
+	// migrationMutex is a mutex for migrations, so a session is moved by one request at a time
+	migrationMutex sync.Mutex
 }
 
 func (s *Server) Cancel() context.CancelFunc {
@@ -84,26 +236,55 @@ func (s *Server) Shutdown() {
 }
 
 func (ser *Server) State() ServerState {
-	return ser.state
+	return ServerState(atomic.LoadInt32(&ser.state))
 }
 
 func (ser *Server) IsRunning() bool {
-	return ser.state == StateRunning
+	return ser.State() == StateRunning
 }
 
 func (ser *Server) IsClosed() bool {
-	return ser.state == StateClosed
+	return ser.State() == StateClosed
+}
+
+// Pending returns the number of datagrams queued in the server
+// They're received datagrams waiting for shards and datagrams waiting for writers.
+func (ser *Server) Pending() int {
+	return int(atomic.LoadInt64(&ser.pending))
 }
 
 func (ser *Server) init() {
+	ser.logger = ser.Logger
+	if ser.logger == nil {
+		ser.logger = raknet.NopLogger{}
+	}
+
+	ser.packetLogger = raknet.NewRateLimitedLogger(ser.logger, raknet.MaxPacketWarnings, raknet.PacketWarningInterval)
+
 	// init maps
-	ser.sessions = cmap.New()
+	ser.stats = newNetStats(nil)
+	ser.stats.packets = new(packetCounts)
+
+	ser.sessions = ser.SessionStore
+	if ser.sessions == nil {
+		ser.sessions = NewSessionStore()
+	}
+
+	ser.sessionCounts = newSessionCounts()
+
 	ser.blockedAddresses = cmap.New()
+	ser.recentConnections = cmap.New()
+	ser.pendingConnections = cmap.New()
+	ser.migrationFailures = cmap.New()
 
 	// readly protocols
 	ser.protocol = new(protocol.Protocol)
 	ser.protocol.RegisterPackets()
 
+	ser.startTime = ser.now()
+
+	ser.SetBroadcastingEnabled(ser.BroadcastingEnabled)
+
 	ser.uid = binary.ReadLong(ser.UUID.Bytes()[:8])
 	ser.pongid = binary.ReadLong(ser.UUID.Bytes()[8:16])
 
@@ -111,6 +292,45 @@ func (ser *Server) init() {
 	if ser.MTU < raknet.MinMTU {
 		ser.MTU = raknet.MaxMTU
 	}
+
+	ser.buffers = newBufferPool(ser.MTU + 1)
+
+	if ser.Writers <= 0 {
+		ser.Writers = 1
+	}
+
+	if ser.WriteQueueSize <= 0 {
+		ser.WriteQueueSize = raknet.WriteQueueSize
+	}
+
+	if ser.HandshakeTimeout <= 0 {
+		ser.HandshakeTimeout = raknet.HandshakeTimeout
+	}
+
+	if ser.MaxPendingConnections <= 0 {
+		ser.MaxPendingConnections = raknet.MaxPendingConnections
+	}
+
+	if ser.MigrationInterval <= 0 {
+		ser.MigrationInterval = raknet.MigrationInterval
+	}
+
+	if ser.InternalAddressCount <= 0 {
+		ser.InternalAddressCount = raknet.SystemAddressCount
+	}
+
+	// The newest version is sent to clients with an incompatible version
+	if ser.NetworkProtocol <= 0 {
+		for _, version := range ser.NetworkProtocols {
+			if version > ser.NetworkProtocol {
+				ser.NetworkProtocol = version
+			}
+		}
+	}
+
+	if ser.NetworkProtocol <= 0 {
+		ser.NetworkProtocol = raknet.NetworkProtocol
+	}
 }
 
 func (ser *Server) Start(ip string, port int) {
@@ -119,7 +339,9 @@ func (ser *Server) Start(ip string, port int) {
 	go ser.ListenAndServe(ctx, &net.UDPAddr{IP: net.ParseIP(ip), Port: port})
 }
 
-func (ser *Server) ListenAndServe(ctx context.Context, addr *net.UDPAddr) error {
+// ListenAndServe listens on the addresses and serves a Raknet server
+// Sessions, blocked addresses and the guid are shared by all addresses.
+func (ser *Server) ListenAndServe(ctx context.Context, addrs ...*net.UDPAddr) error {
 	switch ser.State() {
 	case StateRunning:
 		return errAlreadyRunning
@@ -127,16 +349,39 @@ func (ser *Server) ListenAndServe(ctx context.Context, addr *net.UDPAddr) error
 		return errServerClosed
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		return err
+	if len(addrs) == 0 {
+		return errors.New("no addresses to listen")
+	}
+
+	network := ser.Network
+	if network == "" {
+		network = "udp"
+	}
+
+	conns := make([]net.PacketConn, 0, len(addrs))
+	for _, addr := range addrs {
+		cs, err := listenPacket(network, addr, ser.Listeners)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+
+			return err
+		}
+
+		conns = append(conns, cs...)
 	}
 
-	return ser.Serve(ctx, conn)
+	return ser.Serve(ctx, conns...)
 }
 
-// Serve serves a Raknet server
-func (ser *Server) Serve(ctx context.Context, l *net.UDPConn) error {
+// Serve serves a Raknet server on the connections
+// Sessions, blocked addresses and the guid are shared by all connections,
+// and each session replies through the connection received its packets.
+//
+// conns can be any packet-oriented transport, such as *net.UDPConn or an in-memory pipe.
+// Their addresses must be *net.UDPAddr or strings resolvable as udp addresses.
+func (ser *Server) Serve(ctx context.Context, conns ...net.PacketConn) error {
 	switch ser.State() {
 	case StateRunning:
 		return errAlreadyRunning
@@ -144,114 +389,191 @@ func (ser *Server) Serve(ctx context.Context, l *net.UDPConn) error {
 		return errServerClosed
 	}
 
-	ser.conn = l
+	if len(conns) == 0 {
+		return errors.New("no connections to serve")
+	}
+
+	ser.conns = conns
 
 	ser.init()
 
+	ser.writers = make(map[net.PacketConn]*writer, len(conns))
+	for _, conn := range conns {
+		ser.writers[conn] = newWriter(ser, conn)
+	}
+
+	if ser.Shards > 0 {
+		ser.shards = newShards(ctx, ser)
+	}
+
+	ser.lastCleanupTime = ser.now()
+
+	atomic.StoreInt32(&ser.state, int32(StateRunning))
+
 	// Waits close command from context.Context
 	go func() {
 		<-ctx.Done()
 
-		ser.state = StateClosed
+		atomic.StoreInt32(&ser.state, int32(StateClosed))
 
-		err := ser.conn.Close()
-		if err != nil {
-			ser.Logger.Warn(err)
+		// Close all sessions
+		ser.RangeSessions(func(key string, session *Session) bool {
+			ser.closeSession(session, DisconnectServerShutdown, "Server closed")
+
+			return true
+		})
+
+		for _, w := range ser.writers {
+			w.Close()
+		}
+
+		for _, conn := range ser.conns {
+			err := conn.Close()
+			if err != nil {
+				ser.logger.Warn("Failed to close a connection", "error", err)
+			}
 		}
 
 		for _, handler := range ser.Handlers {
-			handler.CloseServer()
+			h, ok := handler.(CloseServerHandler)
+			if ok {
+				h.CloseServer()
+			}
 		}
 	}()
 
 	// Updates the sessions connected already
-	// in another thread
-	go func() {
-		for {
-			time.Sleep(1 * time.Nanosecond) // lower cpu usage
+	// A Scheduler clock runs the updates on the clock, otherwise they run in another thread
+	scheduler, ok := ser.Clock.(raknet.Scheduler)
+	if ok {
+		ser.scheduleUpdate(ctx, scheduler)
+	} else {
+		go ser.runUpdate(ctx)
+	}
 
-			select {
-			case <-ctx.Done():
-				break
-			default:
-			}
+	for _, handler := range ser.Handlers {
+		h, ok := handler.(StartServerHandler)
+		if ok {
+			h.StartServer()
+		}
+	}
 
-			err := ser.RangeSessions(func(key string, session *Session) bool {
-				if !session.update() {
-					ser.removeSession(session.Addr)
-				}
+	// Reads packets from each udp socket, and handles them
+	// in their threads
+	errs := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn net.PacketConn) {
+			errs <- ser.serveConn(ctx, conn)
+		}(conn)
+	}
 
-				if session.PacketReceivedCount >= raknet.MaxPacketsPerSecond {
-					ser.AddBlockedAddress(session.Addr.IP, &Expire{
-						Time:     time.Now(),
-						Duration: raknet.MaxPacketsPerSecondBlock,
-					}, "Too many packets")
-				}
+	var err error
+	for range conns {
+		e := <-errs
+		if e != nil && err == nil {
+			err = e
+		}
+	}
 
-				return true
-			})
+	return err
+}
 
-			if err != nil {
-				ser.Logger.Warn(err)
-				break
-			}
+// runUpdate updates the server every raknet.UpdateInterval until ctx is done
+func (ser *Server) runUpdate(ctx context.Context) {
+	ticker := time.NewTicker(raknet.UpdateInterval) // lower cpu usage
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
-		// Close all sessions
-		ser.RangeSessions(func(key string, session *Session) bool {
-			ser.removeSession(session.Addr)
+		ser.update()
+	}
+}
 
-			return true
-		})
-	}()
+// scheduleUpdate updates the server every raknet.UpdateInterval on the scheduler until ctx is done
+func (ser *Server) scheduleUpdate(ctx context.Context, scheduler raknet.Scheduler) {
+	scheduler.AfterFunc(raknet.UpdateInterval, func() {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
 
-	for _, handler := range ser.Handlers {
-		handler.StartServer()
-	}
+		ser.update()
+		ser.scheduleUpdate(ctx, scheduler)
+	})
+}
 
-	// Reads packets from udp socket, and handles them
-	// in main thread
-	var buf = make([]byte, 2048)
-	for {
-		_, addr, err := l.ReadFromUDP(buf)
-		if err != nil {
-			select {
-			case <-ctx.Done():
-				//Shutting down listener
-				ser.Logger.Info("Shutting down listenner")
-				return nil
-			default:
-				return err
-			}
+// update updates the sessions, and cleans expired entries every second
+func (ser *Server) update() {
+	sendQueueDepth, recoveryQueueDepth := 0, 0
+
+	err := ser.RangeSessions(func(key string, session *Session) bool {
+		if !session.update() {
+			ser.removeSession(session)
+			return true
 		}
 
-		ser.Logger.Debug("Connection:" + addr.String())
+		sendQueueDepth += session.sendQueue.Size()
+		recoveryQueueDepth += session.recoveryQueue.Len()
 
-		if len(buf) <= 0 {
-			continue
+		if session.PacketReceivedCount >= raknet.MaxPacketsPerSecond {
+			ser.AddBlockedAddress(session.Addr().IP, &Expire{
+				Time:     ser.now(),
+				Duration: raknet.MaxPacketsPerSecondBlock,
+			}, "Too many packets")
 		}
 
-		ser.handlePacket(ctx, addr, buf)
+		return true
+	})
+
+	if err != nil {
+		ser.logger.Error("Failed to update sessions", "error", err)
+	}
+
+	ser.stats.queueDepths(sendQueueDepth, recoveryQueueDepth)
+
+	if ser.now().Sub(ser.lastCleanupTime) >= time.Second {
+		ser.cleanRecentConnections()
+		ser.cleanPendingConnections()
+		ser.cleanBlockedAddresses()
+		ser.migrationFailures.Clear()
+		ser.lastCleanupTime = ser.now()
 	}
 }
 
-func (ser *Server) handlePacket(ctx context.Context, addr *net.UDPAddr, b []byte) {
+func (ser *Server) handlePacket(ctx context.Context, conn net.PacketConn, raddr net.Addr, b []byte) {
 	if len(b) <= 0 {
 		return
 	}
 
+	// raddr is kept to write to the client, addr is used in the server
+	addr, err := ser.udpAddr(raddr)
+	if err != nil {
+		ser.packetLogger.Warn("Invalid address", "addr", raddr, "error", err)
+		return
+	}
+
+	ser.stats.datagramReceived(b[0], len(b))
+
 	// check blocked address
 	if ser.HasBlockedAddress(addr.IP) {
 		return
 	}
 
-	ser.Logger.Debug("handle a packet from " + addr.String())
+	if ser.logger.Enabled(raknet.LevelDebug) {
+		ser.logger.Debug("Handle a packet", "addr", addr, "packet", b[0])
+	}
 
 	// new packet
 
 	pk, ok := ser.protocol.Packet(b[0])
 	if !ok {
-		ser.Logger.Warn("unknown packet id: 0x", pk.ID())
+		ser.packetLogger.Warn("Unknown packet", "addr", addr, "packet", b[0])
 		return
 	}
 
@@ -259,7 +581,7 @@ func (ser *Server) handlePacket(ctx context.Context, addr *net.UDPAddr, b []byte
 
 	switch npk := pk.(type) {
 	case *protocol.UnconnectedPing, *protocol.UnconnectedPingOpenConnections:
-		if !ser.BroadcastingEnabled {
+		if !ser.IsBroadcastingEnabled() {
 			return
 		}
 
@@ -267,7 +589,7 @@ func (ser *Server) handlePacket(ctx context.Context, addr *net.UDPAddr, b []byte
 
 		err := ping.Decode()
 		if err != nil {
-			ser.Logger.Warn(err)
+			ser.packetLogger.Warn("Failed to decode a packet", "addr", addr, "packet", b[0], "error", err)
 			return
 		}
 
@@ -281,7 +603,10 @@ func (ser *Server) handlePacket(ctx context.Context, addr *net.UDPAddr, b []byte
 		}
 
 		for _, handler := range ser.Handlers {
-			handler.HandlePing(addr)
+			h, ok := handler.(PingHandler)
+			if ok {
+				h.HandlePing(raddr)
+			}
 		}
 
 		pong := &protocol.UnconnectedPong{
@@ -293,89 +618,100 @@ func (ser *Server) handlePacket(ctx context.Context, addr *net.UDPAddr, b []byte
 
 		err = pong.Encode()
 		if err != nil {
-			ser.Logger.Warn(err)
+			ser.packetLogger.Warn("Failed to encode a packet", "addr", addr, "packet", pong.ID(), "error", err)
 			return
 		}
 
-		ser.SendRawPacket(addr, pong.Bytes())
+		ser.sendRawPacket(conn, raddr, pong.Bytes())
 
 		return
 	}
 
-	for _, hand := range ser.Handlers {
-		hand.HandleRawPacket(addr, pk)
+	for _, handler := range ser.Handlers {
+		h, ok := handler.(RawPacketHandler)
+		if ok {
+			h.HandleRawPacket(raddr, pk)
+		}
 	}
 
 	switch npk := pk.(type) {
 	case *protocol.OpenConnectionRequestOne:
 		err := npk.Decode()
 		if err != nil {
-			ser.Logger.Warn(err)
+			ser.packetLogger.Warn("Failed to decode a packet", "addr", addr, "packet", b[0], "error", err)
+			return
+		}
+
+		if !npk.Magic {
 			return
 		}
 
 		session, ok := ser.GetSession(addr)
 		if ok {
-			if session.State == StateConnected {
-				ser.CloseSession(session.Addr, "Client re-instantiated connection")
+			if session.State() == StateConnected {
+				ser.closeSession(session, DisconnectClientRequested, "Client re-instantiated connection")
+			} else if session.State() == StateHandshaking {
+				// The request was resent or duplicated, the session is kept
+				ser.sendOpenConnectionResponseOne(conn, raddr, session.MTU)
+				return
 			}
 		}
 
-		if !npk.Magic {
+		reason := ser.validateNewConnection(addr)
+		if reason != RejectNone {
+			ser.reject(conn, raddr, reason)
 			return
 		}
 
-		epk := ser.validateNewConnection(addr)
-		if epk != nil {
-			err = epk.Encode()
-			if err != nil {
-				ser.Logger.Warn(err)
-				return
-			}
+		if !ser.SupportsProtocol(int(npk.ProtocolVersion)) {
+			ser.reject(conn, raddr, RejectIncompatibleProtocol)
+
+			ser.logger.Debug("Invalid connection with an incompatible network protocol",
+				"addr", addr, "client", npk.ProtocolVersion, "server", ser.NetworkProtocol)
 
-			ser.SendRawPacket(addr, epk.Bytes())
 			return
 		}
 
-		if int(npk.ProtocolVersion) != ser.NetworkProtocol {
-			rpk := &protocol.IncompatibleProtocol{}
+		if npk.MTU > ser.MTU {
+			ser.logger.Debug("Invalid connection with over server MTU",
+				"addr", addr, "client", npk.MTU, "server", ser.MTU)
+			return
+		}
 
-			rpk.NetworkProtocol = byte(ser.NetworkProtocol)
-			rpk.ServerGuid = ser.uid
+		if !ser.pendingConnections.Has(addr.String()) &&
+			ser.pendingConnections.Count() >= ser.MaxPendingConnections {
+			ser.reject(conn, raddr, RejectNoFreeIncomingConnections)
+			return
+		}
 
-			err = rpk.Encode()
-			if err != nil {
-				ser.Logger.Warn(err)
-				return
-			}
+		hctx := ser.observeHandshake(ser.pendingContext(ctx, addr), &Handshake{
+			Step:            StepOpenConnectionRequestOne,
+			Addr:            addr,
+			MTU:             int(npk.MTU),
+			ProtocolVersion: int(npk.ProtocolVersion),
+		})
 
-			ser.SendRawPacket(addr, rpk.Bytes())
+		ser.storePendingConnection(addr, int(npk.ProtocolVersion), hctx)
 
-			ser.Logger.Debug("Invalid connection with an incompatible network protocol.",
-				" client: ", npk.ProtocolVersion, " server: ", ser.NetworkProtocol)
+		ser.sendOpenConnectionResponseOne(conn, raddr, npk.MTU)
 
+		return
+	case *protocol.SessionMigration:
+		if !ser.MigrationEnabled {
 			return
 		}
 
-		if npk.MTU > ser.MTU {
-			ser.Logger.Debug("Invalid connection with over server MTU.",
-				" client: ", npk.MTU, " server:", ser.MTU)
+		err := npk.Decode()
+		if err != nil {
+			ser.packetLogger.Warn("Failed to decode a packet", "addr", addr, "packet", b[0], "error", err)
 			return
 		}
 
-		rpk := &protocol.OpenConnectionResponseOne{
-			ServerGUID:  ser.uid,
-			MTU:         uint16(npk.MTU),
-			UseSecurity: false, // we no supported
-		}
-
-		err = rpk.Encode()
-		if err != nil {
-			ser.Logger.Warn(err)
+		if !npk.Magic {
 			return
 		}
 
-		ser.SendRawPacket(addr, rpk.Bytes())
+		ser.migrateSession(conn, raddr, addr, npk)
 
 		return
 	case *protocol.OpenConnectionRequestTwo:
@@ -384,28 +720,21 @@ func (ser *Server) handlePacket(ctx context.Context, addr *net.UDPAddr, b []byte
 			return
 		}
 
-		epk := ser.validateNewConnection(addr)
-		if epk != nil {
-			err = epk.Encode()
-			if err != nil {
-				ser.Logger.Warn(err)
-				return
-			}
+		// The request was resent or duplicated, replies again
+		session, ok := ser.GetSession(addr)
+		if ok && session.State() == StateHandshaking && session.GUID == npk.ClientGuid {
+			ser.sendOpenConnectionResponseTwo(conn, raddr, addr, session.MTU)
+			return
+		}
 
-			ser.SendRawPacket(addr, epk.Bytes())
+		reason := ser.validateNewConnection(addr)
+		if reason != RejectNone {
+			ser.reject(conn, raddr, reason)
 			return
 		}
 
 		if ser.HasSessionGUID(npk.ClientGuid) {
-			rpk := &protocol.AlreadyConnected{}
-
-			err = rpk.Encode()
-			if err != nil {
-				ser.Logger.Warn(err)
-				return
-			}
-
-			ser.SendRawPacket(addr, rpk.Bytes())
+			ser.reject(conn, raddr, RejectAlreadyConnected)
 			return
 		}
 
@@ -413,155 +742,350 @@ func (ser *Server) handlePacket(ctx context.Context, addr *net.UDPAddr, b []byte
 			return
 		}
 
-		rpk := &protocol.OpenConnectionResponseTwo{}
-		rpk.ServerGuid = ser.uid
-		rpk.ClientAddress = ser.newSystemAddress(addr)
-		rpk.MTU = npk.MTU
-		rpk.EncrtptionEnabled = false
-		rpk.Connection = ser.Identifier.ConnectionType()
-
-		err = rpk.Encode()
-		if err != nil {
+		pending, ok := ser.popPendingConnection(addr)
+		if !ok {
+			ser.reject(conn, raddr, RejectHandshakeNotStarted)
 			return
 		}
 
+		protocolVersion := pending.ProtocolVersion
+		hctx := pending.ctx
+
+		if ser.Admission != nil {
+			reason := ser.Admission(&AdmissionRequest{
+				Addr:            addr,
+				GUID:            npk.ClientGuid,
+				MTU:             int(npk.MTU),
+				ProtocolVersion: protocolVersion,
+			})
+
+			if reason != Accept {
+				if ser.Observer != nil {
+					ser.Observer.HandshakeAbandoned(hctx)
+				}
+
+				ser.reject(conn, raddr, reason)
+				return
+			}
+		}
+
 		for _, handler := range ser.Handlers {
-			handler.OpenedPreConn(addr)
+			h, ok := handler.(OpenedPreConnHandler)
+			if ok {
+				h.OpenedPreConn(raddr)
+			}
 		}
 
-		session := &Session{
-			Addr:   addr,
-			Conn:   ser.conn,
-			GUID:   npk.ClientGuid,
-			Logger: ser.Logger,
-			MTU:    ser.MTU,
-			State:  StateHandshaking,
-			Server: ser,
+		id := atomic.AddUint64(&ser.sessionID, 1)
+
+		session = &Session{
+			ID:              id,
+			addr:            addr,
+			conn:            conn,
+			remoteAddr:      raddr,
+			shard:           hashAddr(raddr),
+			GUID:            npk.ClientGuid,
+			Logger:          ser.logger.With("session", id, "guid", npk.ClientGuid),
+			packetLogger:    ser.packetLogger.With("session", id, "guid", npk.ClientGuid),
+			MTU:             int(npk.MTU),
+			ProtocolVersion: protocolVersion,
+			state:           int32(StateHandshaking),
+			Server:          ser,
 		}
 
 		session.Init()
 
-		ser.storeSession(addr, session)
+		session.ctx, session.cancel = context.WithCancel(ser.observeHandshake(hctx, &Handshake{
+			Step:            StepOpenConnectionRequestTwo,
+			Addr:            addr,
+			GUID:            session.GUID,
+			MTU:             session.MTU,
+			ProtocolVersion: protocolVersion,
+		}))
+
+		ser.storeSession(session)
 
-		ser.SendRawPacket(addr, rpk.Bytes())
+		if ser.ReconnectCooldown > 0 {
+			ser.recentConnections.Set(addr.IP.String(), ser.now())
+		}
+
+		ser.sendOpenConnectionResponseTwo(conn, raddr, addr, session.MTU)
 
 		return
 	}
 
 	session, ok := ser.GetSession(addr)
 	if !ok {
-		ser.Logger.Debug("Invalid connctions from " + addr.String())
+		if ser.logger.Enabled(raknet.LevelDebug) {
+			ser.logger.Debug("Invalid connection", "addr", addr, "packet", b[0])
+		}
 		return
 	}
 
+	session.stats.datagramReceived(b[0], len(b))
+
 	switch npk := pk.(type) {
 	case *protocol.Acknowledge:
+		err := npk.Decode()
+		if err != nil {
+			session.packetLogger.Warn("Failed to decode a packet", "packet", b[0], "error", err)
+			return
+		}
+
+		session.traceACK(TraceIn, b[0], len(b), npk.Records)
+
 		session.handleACKPacket(npk)
 	case *protocol.CustomPacket:
+		err := npk.Decode()
+		if err != nil {
+			session.packetLogger.Warn("Failed to decode a packet", "packet", b[0], "error", err)
+			return
+		}
+
+		session.traceDatagram(TraceIn, b[0], len(b), npk.Index, npk.Messages)
+
 		session.handleCustomPacket(npk)
 	default:
 		session.handlePacket(npk, raknet.DefaultChannel)
 	}
 }
 
-func (ser *Server) newSystemAddress(addr *net.UDPAddr) *raknet.SystemAddress {
-	return &raknet.SystemAddress{
-		IP:   addr.IP,
-		Port: uint16(addr.Port),
+// sendOpenConnectionResponseOne replies to OpenConnectionRequestOne
+func (ser *Server) sendOpenConnectionResponseOne(conn net.PacketConn, addr net.Addr, mtu int) {
+	rpk := &protocol.OpenConnectionResponseOne{
+		ServerGUID:  ser.uid,
+		MTU:         uint16(mtu),
+		UseSecurity: false, // we no supported
+	}
+
+	err := rpk.Encode()
+	if err != nil {
+		ser.packetLogger.Warn("Failed to encode a packet", "addr", addr, "packet", rpk.ID(), "error", err)
+		return
+	}
+
+	ser.sendRawPacket(conn, addr, rpk.Bytes())
+}
+
+// sendOpenConnectionResponseTwo replies to OpenConnectionRequestTwo
+func (ser *Server) sendOpenConnectionResponseTwo(conn net.PacketConn, raddr net.Addr, addr *net.UDPAddr, mtu int) {
+	rpk := &protocol.OpenConnectionResponseTwo{}
+	rpk.ServerGuid = ser.uid
+	rpk.ClientAddress = ser.newSystemAddress(addr)
+	rpk.MTU = uint16(mtu)
+	rpk.EncrtptionEnabled = false
+	rpk.Connection = ser.Identifier.ConnectionType()
+
+	err := rpk.Encode()
+	if err != nil {
+		ser.packetLogger.Warn("Failed to encode a packet", "addr", addr, "packet", rpk.ID(), "error", err)
+		return
+	}
+
+	ser.sendRawPacket(conn, raddr, rpk.Bytes())
+}
+
+// now returns the current time of the server's clock
+func (ser *Server) now() time.Time {
+	if ser.Clock != nil {
+		return ser.Clock.Now()
+	}
+
+	return time.Now()
+}
+
+// Timestamp returns milliseconds from the time the server started
+// used for the handshake packets
+func (ser *Server) Timestamp() int64 {
+	return int64(ser.now().Sub(ser.startTime) / time.Millisecond)
+}
+
+// internalAddresses returns the server's addresses sent in the handshake
+func (ser *Server) internalAddresses() []*raknet.SystemAddress {
+	var addrs []*raknet.SystemAddress
+	for _, conn := range ser.conns {
+		addr, err := ser.udpAddr(conn.LocalAddr())
+		if err != nil {
+			continue
+		}
+
+		addrs = append(addrs, ser.newSystemAddress(addr))
+	}
+
+	return addrs
+}
+
+// messagePacket returns a packet for a payload of encapsulated packets
+// Raknet's internal packets are returned as their types, others as RawPacket.
+func (ser *Server) messagePacket(b []byte) raknet.Packet {
+	if len(b) > 0 && b[0] < protocol.IDCustom0 {
+		pk, ok := ser.protocol.Packet(b[0])
+		if ok {
+			pk.SetBytes(b)
+			return pk
+		}
 	}
+
+	return protocol.NewRawPacket(b)
+}
+
+// SupportsProtocol returns whether the server accepts the Raknet protocol version
+func (ser *Server) SupportsProtocol(version int) bool {
+	if len(ser.NetworkProtocols) == 0 {
+		return version == ser.NetworkProtocol
+	}
+
+	for _, v := range ser.NetworkProtocols {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (ser *Server) newSystemAddress(addr *net.UDPAddr) *raknet.SystemAddress {
+	return raknet.NewSystemAddressUDP(addr)
 }
 
-// validateNewConnection returns error packets if the sender has problems
-func (ser *Server) validateNewConnection(addr *net.UDPAddr) raknet.Packet {
+// validateNewConnection returns a reason to reject if the sender has problems
+func (ser *Server) validateNewConnection(addr *net.UDPAddr) RejectReason {
 	if ser.HasSession(addr) {
-		return &protocol.AlreadyConnected{}
+		return RejectAlreadyConnected
 	} else if ser.Count() >= ser.MaxConnections && ser.MaxConnections >= 0 {
-		return &protocol.NoFreeIncomingConnections{}
+		return RejectNoFreeIncomingConnections
 	} else if ser.HasBlockedAddress(addr.IP) {
-		return &protocol.ConnectionBanned{}
+		return RejectConnectionBanned
+	} else if ser.MaxConnectionsPerIP > 0 && ser.CountIP(addr.IP) >= ser.MaxConnectionsPerIP {
+		return RejectNoFreeIncomingConnections
+	} else if ser.isRecentlyConnected(addr.IP) {
+		return RejectIPRecentlyConnected
+	} else if ser.MaxHandshakingSessions > 0 && ser.CountHandshaking() >= ser.MaxHandshakingSessions {
+		return RejectNoFreeIncomingConnections
 	}
 
-	return nil
+	return RejectNone
 }
 
-func (ser *Server) Count() int {
-	return ser.sessions.Count()
-}
+// reject sends a packet to tell the client the reason rejected the connection
+func (ser *Server) reject(conn net.PacketConn, addr net.Addr, reason RejectReason) {
+	for _, handler := range ser.Handlers {
+		h, ok := handler.(RejectHandler)
+		if ok {
+			h.RejectedConn(addr, reason)
+		}
+	}
 
-/*
-func (ser *Server) Sessions() map[string]*Sessions {
-	return ser.sessions.Items()
-}*/
+	pk := ser.rejectPacket(reason)
+	if pk == nil {
+		return
+	}
+
+	err := pk.Encode()
+	if err != nil {
+		ser.packetLogger.Warn("Failed to encode a packet", "addr", addr, "packet", pk.ID(), "error", err)
+		return
+	}
 
-func (ser *Server) storeSession(addr net.Addr, session *Session) {
-	ser.sessions.Set(addr.String(), session)
+	ser.sendRawPacket(conn, addr, pk.Bytes())
 }
 
-func (ser *Server) restoreSession(addr net.Addr) (*Session, bool) {
-	value, ok := ser.sessions.Get(addr.String())
+func (ser *Server) isRecentlyConnected(ip net.IP) bool {
+	if ser.ReconnectCooldown <= 0 {
+		return false
+	}
+
+	value, ok := ser.recentConnections.Get(ip.String())
 	if !ok {
-		return nil, false
+		return false
 	}
 
-	session, ok := value.(*Session)
+	connected, ok := value.(time.Time)
 	if !ok {
-		ser.Logger.Warn("Invaild value, wants *Session")
-		return nil, false
+		return false
 	}
 
-	return session, true
+	return ser.now().Sub(connected) < ser.ReconnectCooldown
+}
+
+// cleanRecentConnections removes expired recent connections
+func (ser *Server) cleanRecentConnections() {
+	for item := range ser.recentConnections.IterBuffered() {
+		connected, ok := item.Val.(time.Time)
+		if !ok || ser.now().Sub(connected) >= ser.ReconnectCooldown {
+			ser.recentConnections.Remove(item.Key)
+		}
+	}
+}
+
+// Count returns the number of sessions
+func (ser *Server) Count() int {
+	return ser.sessions.Len()
+}
+
+// CountHandshaking returns the number of sessions not completed the handshake yet
+func (ser *Server) CountHandshaking() int {
+	return ser.sessionCounts.handshakes()
+}
+
+// CountIP returns the number of sessions from the ip address
+func (ser *Server) CountIP(ip net.IP) int {
+	return ser.sessionCounts.ip(ip)
+}
+
+// Sessions returns a snapshot of the sessions by their addresses
+func (ser *Server) Sessions() map[string]*Session {
+	sessions := make(map[string]*Session, ser.sessions.Len())
+
+	ser.sessions.Range(func(session *Session) bool {
+		sessions[session.Addr().String()] = session
+
+		return true
+	})
+
+	return sessions
 }
 
-func (ser *Server) existSession(addr net.Addr) bool {
-	return ser.sessions.Has(addr.String())
+func (ser *Server) storeSession(session *Session) {
+	ser.sessions.Store(session)
+	ser.sessionCounts.add(session)
+}
+
+func (ser *Server) restoreSession(addr net.Addr) (*Session, bool) {
+	return ser.sessions.Get(addr)
 }
 
-func (ser *Server) removeSession(addr net.Addr) {
-	ser.sessions.Remove(addr.String())
+func (ser *Server) removeSession(session *Session) {
+	ser.sessions.Remove(session)
+	ser.sessionCounts.remove(session)
 }
 
 // RangeSessions processes for the sessions instead of "for range".
 // if f returns false, stops the loop.
 //
 // key is string(returned net.Addr.String()), value is *Session
-// returns errors if key and value is invalid.
 func (ser *Server) RangeSessions(f func(key string, value *Session) bool) error {
-	for item := range ser.sessions.IterBuffered() {
-		session, ok := item.Val.(*Session)
-		if !ok {
-			return errors.New("invalid value, wants *Session")
-		}
-
-		if !f(item.Key, session) {
-			break
-		}
-	}
+	ser.sessions.Range(func(session *Session) bool {
+		return f(session.Addr().String(), session)
+	})
 
 	return nil
 }
 
+// GetSessionGUID returns the session with the guid
 func (ser *Server) GetSessionGUID(guid int64) (*Session, bool) {
-	var session *Session
-
-	err := ser.RangeSessions(func(key string, sess *Session) bool {
-		if sess.GUID == guid {
-			session = sess
-			return false
-		}
-
-		return true
-	})
-
-	if err != nil {
-		ser.Logger.Warn(err)
-		return nil, false
-	}
+	return ser.sessions.GetGUID(guid)
+}
 
-	return session, session != nil
+// GetSessionID returns the session with the id assigned by the server
+func (ser *Server) GetSessionID(id uint64) (*Session, bool) {
+	return ser.sessions.GetID(id)
 }
 
 func (ser *Server) HasSession(addr net.Addr) bool {
-	return ser.existSession(addr)
+	_, ok := ser.restoreSession(addr)
+
+	return ok
 }
 
 func (ser *Server) HasSessionGUID(guid int64) bool {
@@ -576,8 +1100,8 @@ func (ser *Server) GetSession(addr *net.UDPAddr) (*Session, bool) {
 		return nil, false
 	}
 
-	if session.State == StateDisconected {
-		ser.CloseSession(addr, "Already closed")
+	if session.State() == StateDisconected {
+		ser.removeSession(session)
 
 		return nil, false
 	}
@@ -585,32 +1109,33 @@ func (ser *Server) GetSession(addr *net.UDPAddr) (*Session, bool) {
 	return session, true
 }
 
-func (ser *Server) closeSession(session *Session) {
-	ser.removeSession(session.Addr)
+// closeSession closes the session with the reason, and removes it from the server
+func (ser *Server) closeSession(session *Session, reason DisconnectReason, message string) {
+	ser.removeSession(session)
 
-	session.Close()
+	session.close(reason, message)
 }
 
+// CloseSession kicks the session with the address
+// reason is a message to tell handlers
 func (ser *Server) CloseSession(addr *net.UDPAddr, reason string) error {
 	session, ok := ser.restoreSession(addr)
 	if !ok {
 		return errors.New("couldn't find the session")
 	}
 
-	ser.closeSession(session)
-
-	return nil
+	return session.Close(reason)
 }
 
+// CloseSessionGUID kicks the session with the guid
+// reason is a message to tell handlers
 func (ser *Server) CloseSessionGUID(guid int64, reason string) error {
 	session, ok := ser.GetSessionGUID(guid)
 	if !ok {
 		return errors.New("couldn't find the session")
 	}
 
-	ser.closeSession(session)
-
-	return nil
+	return session.Close(reason)
 }
 
 func (ser *Server) SendPacket(guid int64, b []byte, reliability raknet.Reliability, channel int) error {
@@ -619,42 +1144,207 @@ func (ser *Server) SendPacket(guid int64, b []byte, reliability raknet.Reliabili
 		return errors.New("not found the session")
 	}
 
-	_, err := session.SendPacketBytes(b, reliability, channel)
-
-	return err
+	return session.Send(b, reliability, channel)
 }
 
+// SendRawPacket sends bytes to the address
+// It's sent through the connection of the session with the address, or the first connection.
+// The bytes are copied to the write queue, so b can be reused after it returns.
 func (ser *Server) SendRawPacket(addr *net.UDPAddr, b []byte) {
+	conn := ser.conns[0]
+
+	var raddr net.Addr = addr
+
+	session, ok := ser.restoreSession(addr)
+	if ok {
+		conn, raddr = session.path()
+	}
+
+	ser.sendRawPacket(conn, raddr, b)
+}
+
+// sendRawPacket sends bytes to the address through the connection
+// b can be reused after it returns.
+func (ser *Server) sendRawPacket(conn net.PacketConn, addr net.Addr, b []byte) {
+	buf := ser.buffers.Get()
+	*buf = append((*buf)[:0], b...)
+
+	ser.sendBuffer(conn, addr, buf)
+}
+
+// sendBuffer queues the buffer to be written to the address through the connection
+// It takes the ownership of buf, which mustn't be used after the call.
+func (ser *Server) sendBuffer(conn net.PacketConn, addr net.Addr, buf *[]byte) bool {
+	if ser.Handlers.hasSendPacketHandler() {
+		ser.handleSendPacket(addr, *buf)
+	}
+
+	return ser.queueBuffer(conn, addr, buf)
+}
+
+// handleSendPacket passes the datagram sent to the address to the handlers
+func (ser *Server) handleSendPacket(addr net.Addr, b []byte) {
 	rpk := protocol.NewRawPacket(b)
-	for _, handler := range ser.Handlers { // For debug
-		handler.HandleSendPacket(addr, rpk)
+	for _, handler := range ser.Handlers {
+		h, ok := handler.(SendPacketHandler)
+		if ok {
+			h.HandleSendPacket(addr, rpk)
+		}
 	}
+}
 
-	go func() { // TODO: rewrite
-		ser.conn.WriteToUDP(b, addr)
-	}()
+// queueBuffer queues the buffer like sendBuffer, but doesn't pass it to the handlers
+func (ser *Server) queueBuffer(conn net.PacketConn, addr net.Addr, buf *[]byte) bool {
+	n := len(*buf)
+	if n == 0 {
+		ser.buffers.Put(buf)
+		return false
+	}
+
+	id := (*buf)[0]
+
+	w, ok := ser.writers[conn]
+	if !ok {
+		_, err := conn.WriteTo(*buf, addr)
+		if err != nil {
+			ser.writeError(addr, err)
+		}
+
+		ser.buffers.Put(buf)
+
+		ok = err == nil
+	} else {
+		ok = w.Write(addr, buf)
+	}
+
+	if ok {
+		ser.stats.datagramSent(id, n)
+	}
+
+	return ok
 }
 
+// writable returns whether sessions can send more datagrams to the address through the connection
+func (ser *Server) writable(conn net.PacketConn, addr net.Addr) bool {
+	w, ok := ser.writers[conn]
+	if !ok {
+		return true
+	}
+
+	return w.Writable(addr)
+}
+
+func (ser *Server) writeError(addr net.Addr, err error) {
+	if ser.WriteError != nil {
+		ser.WriteError(addr, err)
+		return
+	}
+
+	ser.logger.Debug("Failed to write a datagram", "addr", addr, "error", err)
+}
+
+// HasBlockedAddress returns whether the ip address is blocked
+// An expired ban isn't counted, and it's removed in the next cleanup.
 func (ser *Server) HasBlockedAddress(ip net.IP) bool {
-	return ser.blockedAddresses.Has(ip.String())
+	value, ok := ser.blockedAddresses.Get(ip.String())
+	if !ok {
+		return false
+	}
+
+	addr, ok := value.(*BlockedAddress)
+
+	return ok && !addr.expired(ser.now())
 }
 
+// AddBlockedAddress blocks the ip address, and closes its sessions
+// If exp's Time is zero, the current time of the server's Clock is set.
 func (ser *Server) AddBlockedAddress(ip net.IP, exp *Expire, reason string) {
+	if exp != nil && exp.Time.IsZero() {
+		exp.Time = ser.now()
+	}
+
 	for _, handler := range ser.Handlers {
-		handler.AddedBlockedAddress(ip, reason)
+		h, ok := handler.(AddedBlockedAddressHandler)
+		if ok {
+			h.AddedBlockedAddress(ip, reason)
+		}
 	}
 
-	ser.blockedAddresses.Set(ip.String(), exp)
+	ser.blockedAddresses.Set(ip.String(), &BlockedAddress{
+		IP:     ip,
+		Expire: exp,
+		Reason: reason,
+	})
+
+	// Disconnect sessions from the address
+	ser.RangeSessions(func(key string, session *Session) bool {
+		if session.Addr().IP.Equal(ip) {
+			ser.closeSession(session, DisconnectBanned, reason)
+		}
+
+		return true
+	})
 }
 
 func (ser *Server) RemoveBlockedAddress(ip net.IP) {
 	for _, handler := range ser.Handlers {
-		handler.RemovedBlockedAddress(ip)
+		h, ok := handler.(RemovedBlockedAddressHandler)
+		if ok {
+			h.RemovedBlockedAddress(ip)
+		}
 	}
 
 	ser.blockedAddresses.Remove(ip.String())
 }
 
+// cleanBlockedAddresses removes expired bans
+func (ser *Server) cleanBlockedAddresses() {
+	for item := range ser.blockedAddresses.IterBuffered() {
+		addr, ok := item.Val.(*BlockedAddress)
+		if ok && addr.expired(ser.now()) {
+			ser.RemoveBlockedAddress(addr.IP)
+		}
+	}
+}
+
+// CountBlockedAddresses returns the number of blocked addresses
+func (ser *Server) CountBlockedAddresses() int {
+	return ser.blockedAddresses.Count()
+}
+
+// BlockedAddresses returns the blocked addresses
+func (ser *Server) BlockedAddresses() []*BlockedAddress {
+	if ser.blockedAddresses == nil {
+		return nil
+	}
+
+	addrs := make([]*BlockedAddress, 0, ser.blockedAddresses.Count())
+
+	for item := range ser.blockedAddresses.IterBuffered() {
+		addr, ok := item.Val.(*BlockedAddress)
+		if ok && !addr.expired(ser.now()) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// SetBroadcastingEnabled enables or disables broadcasting while the server is running
+func (ser *Server) SetBroadcastingEnabled(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+
+	atomic.StoreInt32(&ser.broadcasting, value)
+}
+
+// IsBroadcastingEnabled returns whether the server sends UnconnectedPong to pings
+func (ser *Server) IsBroadcastingEnabled() bool {
+	return atomic.LoadInt32(&ser.broadcasting) == 1
+}
+
 func (ser *Server) packet(b []byte) (raknet.Packet, error) {
 	if len(b) <= 0 {
 		return nil, errors.New("no enough bytes")
@@ -0,0 +1,380 @@
+package server_test
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/identifier"
+	"github.com/beito123/go-raknet/protocol"
+	"github.com/beito123/go-raknet/raknettest"
+	"github.com/beito123/go-raknet/server"
+)
+
+const (
+	serverAddress = "10.0.0.1:19132"
+	clientAddress = "10.0.0.2:5000"
+)
+
+// serve serves a new server echoing user packets on the network
+func serve(t *testing.T, network *raknettest.Network) *server.Server {
+	t.Helper()
+
+	return serveWith(t, network, &server.Server{
+		MaxConnections: 10,
+	})
+}
+
+// serveWith serves the server echoing user packets on the network
+// The echo handler is added to the server's handlers.
+func serveWith(t *testing.T, network *raknettest.Network, ser *server.Server) *server.Server {
+	t.Helper()
+
+	ser.Identifier = identifier.Base{Connection: raknet.ConnectionGoRaknet}
+
+	ser.Handlers = append(ser.Handlers, &server.HandlerFuncs{
+		HandleSessionPacketFunc: func(session *server.Session, pk raknet.Packet) {
+			session.Send(append([]byte(nil), pk.Bytes()...), raknet.ReliableOrdered, raknet.DefaultChannel)
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	err := network.Serve(ctx, ser, serverAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ser
+}
+
+// dial connects a new client to the server on the network
+func dial(t *testing.T, network *raknettest.Network) *raknettest.Client {
+	t.Helper()
+
+	client, err := network.Dial(clientAddress, serverAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { client.Close() })
+
+	for i := 0; i < 100 && !client.Connected(); i++ {
+		network.Advance(100 * time.Millisecond)
+	}
+
+	if !client.Connected() {
+		t.Fatal("the client didn't connect")
+	}
+
+	return client
+}
+
+// session returns the only session of the server
+func session(t *testing.T, ser *server.Server) *server.Session {
+	t.Helper()
+
+	sessions := ser.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("%d sessions, want 1", len(sessions))
+	}
+
+	for _, session := range sessions {
+		return session
+	}
+
+	return nil
+}
+
+func TestEcho(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	serve(t, network)
+	client := dial(t, network)
+
+	for i := 0; i < 10; i++ {
+		client.Send([]byte{0x90, byte(i)}, raknet.ReliableOrdered, raknet.DefaultChannel)
+	}
+
+	network.Advance(10 * time.Millisecond)
+
+	pks := client.Packets()
+	if len(pks) != 10 {
+		t.Fatalf("echoed %d packets, want 10", len(pks))
+	}
+
+	for i, pk := range pks {
+		if pk[1] != byte(i) {
+			t.Fatalf("packet %d is echoed at %d", pk[1], i)
+		}
+	}
+}
+
+func TestHandshakeResend(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	// The first replies to the open connection requests are lost, so the client resends them
+	lost := make(map[byte]bool)
+	network.Drop = func(from net.Addr, to net.Addr, b []byte) bool {
+		if b[0] != protocol.IDOpenConnectionReply1 && b[0] != protocol.IDOpenConnectionReply2 {
+			return false
+		}
+
+		if lost[b[0]] {
+			return false
+		}
+
+		lost[b[0]] = true
+
+		return true
+	}
+
+	ser := serve(t, network)
+	client := dial(t, network)
+
+	if client.Rejection() != nil {
+		t.Fatalf("the resent request is rejected with %T", client.Rejection())
+	}
+
+	if len(lost) != 2 || ser.Count() != 1 {
+		t.Fatalf("%d replies lost and %d sessions, want 2 and 1", len(lost), ser.Count())
+	}
+}
+
+func TestBanExpiry(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	var removed []string
+	ser := serveWith(t, network, &server.Server{
+		MaxConnections: 10,
+		Handlers: server.Handlers{&server.HandlerFuncs{
+			RemovedBlockedAddressFunc: func(ip net.IP) {
+				removed = append(removed, ip.String())
+			},
+		}},
+	})
+
+	ip := net.IPv4(10, 0, 0, 2)
+	ser.AddBlockedAddress(ip, &server.Expire{Duration: 2 * time.Second}, "test")
+
+	network.Advance(time.Second)
+
+	if !ser.HasBlockedAddress(ip) || len(ser.BlockedAddresses()) != 1 {
+		t.Fatal("the address isn't blocked before the ban expires")
+	}
+
+	// The expired ban isn't counted until it's removed in the cleanup
+	network.Advance(time.Second)
+
+	if ser.HasBlockedAddress(ip) || len(ser.BlockedAddresses()) != 0 {
+		t.Fatal("the address is blocked after the ban expired")
+	}
+
+	network.Advance(time.Second)
+
+	if ser.CountBlockedAddresses() != 0 || len(removed) != 1 || removed[0] != ip.String() {
+		t.Fatalf("%d bans and removed %v after the cleanup", ser.CountBlockedAddresses(), removed)
+	}
+
+	dial(t, network)
+}
+
+// legacyHandler is a handler written before disconnect reasons
+type legacyHandler struct {
+	server.BaseHandler
+
+	closed []int64
+}
+
+func (h *legacyHandler) ClosedConn(uid int64) {
+	h.closed = append(h.closed, uid)
+}
+
+func TestHandlers(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	legacy := &legacyHandler{}
+
+	var reasons []server.DisconnectReason
+	serveWith(t, network, &server.Server{
+		MaxConnections: 10,
+		Handlers: server.Handlers{legacy, server.HandlerFuncs{
+			ClosedConnFunc: func(uid int64, reason server.DisconnectReason, message string) {
+				reasons = append(reasons, reason)
+			},
+		}},
+	})
+
+	client := dial(t, network)
+	client.Close()
+
+	network.Advance(time.Second)
+
+	if len(legacy.closed) != 1 || legacy.closed[0] != client.GUID {
+		t.Fatalf("the legacy handler is notified of %v, want [%d]", legacy.closed, client.GUID)
+	}
+
+	if len(reasons) != 1 || reasons[0] != server.DisconnectClientRequested {
+		t.Fatalf("HandlerFuncs is notified of %v, want [%v]", reasons, server.DisconnectClientRequested)
+	}
+}
+
+// warnLogger is a logger counting warnings
+type warnLogger struct {
+	raknet.NopLogger
+
+	mutex    sync.Mutex
+	warnings int
+}
+
+func (logger *warnLogger) Warn(msg string, fields ...interface{}) {
+	logger.mutex.Lock()
+	logger.warnings++
+	logger.mutex.Unlock()
+}
+
+func (logger *warnLogger) With(fields ...interface{}) raknet.Logger {
+	return logger
+}
+
+func (logger *warnLogger) count() int {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	return logger.warnings
+}
+
+func TestLogger(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	logger := &warnLogger{}
+	ser := serveWith(t, network, &server.Server{
+		MaxConnections: 10,
+		Logger:         logger,
+	})
+
+	dial(t, network)
+
+	conn, err := network.Listen("10.0.0.3:5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Warnings for unknown packets are limited
+	for i := 0; i < raknet.MaxPacketWarnings*2; i++ {
+		conn.WriteTo([]byte{protocol.IDTimestamp}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132})
+	}
+
+	network.Settle()
+
+	if logger.count() != raknet.MaxPacketWarnings {
+		t.Fatalf("%d warnings for unknown packets, want %d", logger.count(), raknet.MaxPacketWarnings)
+	}
+
+	// Warnings of the session aren't limited with them
+	session(t, ser).Logger.Warn("test")
+
+	if logger.count() != raknet.MaxPacketWarnings+1 {
+		t.Fatal("the session's warning is dropped by the limit for received packets")
+	}
+
+	if ser.Logger != logger {
+		t.Fatal("the server's Logger is replaced")
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser := serve(t, network)
+	dial(t, network)
+
+	if ser.Logger != nil {
+		t.Fatalf("the server's Logger is set to %T", ser.Logger)
+	}
+}
+
+// tracerFunc is a Tracer calling the function
+type tracerFunc func(event *server.TraceEvent)
+
+func (f tracerFunc) Trace(event *server.TraceEvent) {
+	f(event)
+}
+
+func TestInternalAddressCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		want  int
+	}{
+		{"default", 0, raknet.SystemAddressCount},
+		{"minecraft", raknet.MinecraftSystemAddressCount, raknet.MinecraftSystemAddressCount},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			network := raknettest.NewNetwork(1)
+
+			var mutex sync.Mutex
+			counts := map[byte]int{}
+
+			serveWith(t, network, &server.Server{
+				MaxConnections:       10,
+				InternalAddressCount: test.count,
+				Tracer: tracerFunc(func(event *server.TraceEvent) {
+					if event.Layer != server.LayerMessage || len(event.Message.Payload) == 0 {
+						return
+					}
+
+					b := append([]byte(nil), event.Message.Payload...)
+
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					switch b[0] {
+					case protocol.IDConnectionRequestAccepted:
+						pk := &protocol.ConnectionRequestAccepted{}
+						pk.SetBytes(b)
+
+						if pk.Decode() == nil {
+							counts[b[0]] = pk.AddressCount
+						}
+					case protocol.IDNewIncomingConnection:
+						pk := &protocol.NewIncomingConnection{}
+						pk.SetBytes(b)
+
+						if pk.Decode() == nil {
+							counts[b[0]] = pk.AddressCount
+						}
+					}
+				}),
+			})
+
+			dial(t, network)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if counts[protocol.IDConnectionRequestAccepted] != test.want {
+				t.Fatalf("the server sent %d addresses, want %d", counts[protocol.IDConnectionRequestAccepted], test.want)
+			}
+
+			if counts[protocol.IDNewIncomingConnection] != test.want {
+				t.Fatalf("the client sent %d addresses, want %d", counts[protocol.IDNewIncomingConnection], test.want)
+			}
+		})
+	}
+}
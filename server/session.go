@@ -10,8 +10,11 @@ package server
  */
 
 import (
+	"context"
 	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/beito123/go-raknet/binary"
@@ -29,23 +32,58 @@ var (
 
 type SessionState int
 
+// maxLatencyTimestamps is the maximum number of pings waiting for pongs
+const maxLatencyTimestamps = 10
+
 const (
 	StateDisconected SessionState = iota
 	StateHandshaking
 	StateConnected
 )
 
+func (state SessionState) String() string {
+	switch state {
+	case StateDisconected:
+		return "Disconnected"
+	case StateHandshaking:
+		return "Handshaking"
+	case StateConnected:
+		return "Connected"
+	}
+
+	return "Unknown"
+}
+
 // Session
 type Session struct {
-	// Addr is the client's address to connect
-	Addr *net.UDPAddr
+	// ID is the session's id assigned by the server
+	// It's unique in the server, unlike GUID sent from the client.
+	ID uint64
 
-	// Conn is a connection for the client
-	Conn *net.UDPConn
+	// addrMutex is a mutex for addr, conn and remoteAddr, changed in migration
+	addrMutex sync.RWMutex
 
-	// Logger is a logger
+	// addr is the client's address to connect, see Addr
+	addr *net.UDPAddr
+
+	// conn is a connection for the client, see Conn
+	conn net.PacketConn
+
+	// remoteAddr is the client's address read from conn, which may not be UDPAddr
+	// Datagrams are written to it, addr is converted from it once.
+	remoteAddr net.Addr
+
+	// shard is the index of the shard handling the session's datagrams
+	// It's chosen from the first address, and kept after migration.
+	shard uint32
+
+	// Logger is a logger with the session's ID and GUID as fields
 	Logger raknet.Logger
 
+	// packetLogger is Logger for warnings of datagrams from the client
+	// It shares the rate limit of the server's warnings for received packets.
+	packetLogger raknet.Logger
+
 	// Server is the server instance.
 	Server *Server
 
@@ -55,19 +93,67 @@ type Session struct {
 	// MTU is the max packet size to receive and send
 	MTU int
 
-	State SessionState
+	// ProtocolVersion is the Raknet protocol version negotiated with the client
+	ProtocolVersion int
+
+	// state is the SessionState, read and written atomically, see State
+	state int32
+
+	// closeMutex is a mutex to close the session once
+	closeMutex sync.Mutex
+
+	// closed is closed when the session is closed
+	closed chan struct{}
+
+	// ctx is the session's context, canceled by cancel when the session is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// valuesMutex is a mutex for values
+	valuesMutex sync.RWMutex
+
+	// values is values set by handlers
+	values map[interface{}]interface{}
+
+	// disconnectReason is the reason why the session was closed
+	disconnectReason DisconnectReason
+
+	// disconnectMessage is a detail of disconnectReason
+	disconnectMessage string
+
+	// createdTime is the time created the session
+	createdTime time.Time
 
 	// connectedTime is the time completed connection with client
 	connectedTime time.Time
 
+	// migrationToken is a token to migrate the session to a new address
+	// It's set when the session is connected if migration is enabled.
+	migrationToken []byte
+
+	// countedIP is the ip address the session is counted with by the server
+	// It's guarded by the server's sessionCounts, as countedHandshaking.
+	countedIP string
+
+	// countedHandshaking is whether the session is counted as a handshaking session
+	countedHandshaking bool
+
+	// lastMigrationTime is the last time the session migrated
+	// It's guarded by the server's migrationMutex.
+	lastMigrationTime time.Time
+
 	// latencyEnabled enables measuring a latency time
 	latencyEnabled bool
 
 	// latencyTimestamps is timestamps of pong packet sent from client
 	latencyTimestamps []int64
 
-	// Latency is the average latency time data
-	Latency *raknet.Latency
+	// stats is network statistics of the session
+	stats *netStats
+
+	// sendMutex is a mutex for the sending state below
+	// Packets are sent from the update loop, handlers and the reading thread.
+	sendMutex sync.Mutex
 
 	// messageIndex is a message index of EncapsulatedPacket
 	messageIndex binary.Triad
@@ -87,7 +173,7 @@ type Session struct {
 
 	// recoveryQueue is a recovery queue by NACK to send the client
 	// When the session received NACK packet, add lost packets.
-	recoveryQueue *util.OrderedMap // map[int][]*protocol.EncapsulatedPacket
+	recoveryQueue *util.OrderedMap // map[int]*recoveryDatagram
 
 	// ackReceiptPackets contains ack index of sent packets to the client
 	ackReceiptPackets map[int]*protocol.EncapsulatedPacket
@@ -147,12 +233,58 @@ type Session struct {
 	LastPingSendTime time.Time
 }
 
+// State returns the state of the session
+func (session *Session) State() SessionState {
+	return SessionState(atomic.LoadInt32(&session.state))
+}
+
+// Addr returns the client's address
+// It can change when the session migrates to a new address.
+func (session *Session) Addr() *net.UDPAddr {
+	session.addrMutex.RLock()
+	defer session.addrMutex.RUnlock()
+
+	return session.addr
+}
+
+// Conn returns the connection for the client
+func (session *Session) Conn() net.PacketConn {
+	session.addrMutex.RLock()
+	defer session.addrMutex.RUnlock()
+
+	return session.conn
+}
+
+// path returns the connection and the address to write datagrams to the client
+func (session *Session) path() (net.PacketConn, net.Addr) {
+	session.addrMutex.RLock()
+	defer session.addrMutex.RUnlock()
+
+	return session.conn, session.remoteAddr
+}
+
+// setPath moves the session to the address on the connection
+func (session *Session) setPath(conn net.PacketConn, raddr net.Addr, addr *net.UDPAddr) {
+	session.addrMutex.Lock()
+	defer session.addrMutex.Unlock()
+
+	session.conn = conn
+	session.remoteAddr = raddr
+	session.addr = addr
+}
+
 func (session *Session) SystemAddress() *raknet.SystemAddress {
-	return raknet.NewSystemAddressBytes([]byte(session.Addr.IP), uint16(session.Addr.Port))
+	return raknet.NewSystemAddressUDP(session.Addr())
 }
 
 func (session *Session) Init() {
-	session.Latency = new(raknet.Latency)
+	session.latencyEnabled = true
+
+	session.stats = newNetStats(session.Server.stats)
+
+	session.closed = make(chan struct{})
+
+	session.createdTime = session.Server.now()
 
 	session.reliablePackets = make(map[binary.Triad]bool)
 	session.splitQueue = make(map[uint16]*SplitPacket)
@@ -169,21 +301,26 @@ func (session *Session) Init() {
 
 	session.handleQueue = make(map[byte]map[binary.Triad]*protocol.EncapsulatedPacket)
 
-	session.LastPacketSendTime = time.Now()
-	session.LastPacketReceiveTime = time.Now()
-	session.LastRecoverySendTime = time.Now()
-	session.LastKeepAliveSendTime = time.Now()
-	session.LastPacketCounterResetTime = time.Now()
+	session.LastPacketSendTime = session.Server.now()
+	session.LastPacketReceiveTime = session.Server.now()
+	session.LastRecoverySendTime = session.Server.now()
+	session.LastKeepAliveSendTime = session.Server.now()
+	session.LastPacketCounterResetTime = session.Server.now()
+}
+
+// Uptime returns the time since the session was created
+func (session *Session) Uptime() time.Duration {
+	return session.Server.now().Sub(session.createdTime)
 }
 
 // Timestamp returns a time from a time connected
 // used for Ping and Pong packets
 func (session *Session) Timestamp() int64 {
-	return int64(time.Now().Sub(session.connectedTime))
+	return int64(session.Server.now().Sub(session.connectedTime))
 }
 
 func (session *Session) handlePacket(pk raknet.Packet, channel int) {
-	if session.State == StateDisconected {
+	if session.State() == StateDisconected {
 		return
 	}
 
@@ -191,7 +328,7 @@ func (session *Session) handlePacket(pk raknet.Packet, channel int) {
 	case *protocol.ConnectedPing:
 		err := npk.Decode()
 		if err != nil {
-			session.Logger.Warn(err)
+			session.packetLogger.Warn("Failed to decode a packet", "packet", npk.ID(), "error", err)
 			return
 		}
 
@@ -202,130 +339,227 @@ func (session *Session) handlePacket(pk raknet.Packet, channel int) {
 
 		err = pong.Encode()
 		if err != nil {
-			session.Logger.Warn(err)
+			session.Logger.Warn("Failed to encode a packet", "packet", pong.ID(), "error", err)
 			return
 		}
 
 		_, err = session.SendPacket(pong, raknet.Unreliable, channel)
 		if err != nil {
-			session.Logger.Warn(err)
+			session.Logger.Warn("Failed to send a packet", "packet", pong.ID(), "error", err)
 		}
 	case *protocol.ConnectedPong:
 		err := npk.Decode()
 		if err != nil {
-			session.Logger.Warn(err)
+			session.packetLogger.Warn("Failed to decode a packet", "packet", npk.ID(), "error", err)
 			return
 		}
 
 		if session.latencyEnabled {
-			unset := func(v []int64, i int) []int64 {
-				return append(v[:i], v[i+1:]...)
-			}
+			session.sendMutex.Lock()
 
 			now := session.Timestamp()
-			for i, ts := range session.latencyTimestamps {
+			answered := false
+
+			// Timestamps of answered or too old pings are removed
+			timestamps := session.latencyTimestamps[:0]
+			for _, ts := range session.latencyTimestamps {
 				if ts == npk.Timestamp {
-					unset(session.latencyTimestamps, i)
+					answered = true
+					continue
+				}
 
-					session.Latency.AddRaw(session.LastPacketReceiveTime.Sub(session.LastPingSendTime))
-				} else {
-					if time.Duration(now-ts) >= raknet.SessionTimeout || len(session.latencyTimestamps) > 10 {
-						unset(session.latencyTimestamps, i)
-					}
+				if time.Duration(now-ts) < raknet.SessionTimeout {
+					timestamps = append(timestamps, ts)
+				}
+			}
+
+			session.latencyTimestamps = timestamps
+
+			session.sendMutex.Unlock()
+
+			if !answered {
+				return
+			}
+
+			rtt := time.Duration(now - npk.Timestamp)
+
+			session.stats.rtt(rtt)
+
+			for _, handler := range session.Server.Handlers {
+				rh, ok := handler.(RTTHandler)
+				if ok {
+					rh.MeasuredRTT(session.GUID, rtt)
 				}
 			}
 		}
-	case *protocol.ConnectionRequestAccepted:
-		if session.State != StateHandshaking {
+	case *protocol.ConnectionRequest:
+		if session.State() != StateHandshaking {
 			return
 		}
 
 		err := npk.Decode()
 		if err != nil {
-			session.Logger.Warn(err) // remove
+			session.packetLogger.Warn("Failed to decode a packet", "packet", npk.ID(), "state", session.State(), "error", err)
 
-			session.Server.CloseSession(session.Addr, "Failed to login")
+			session.Server.closeSession(session, DisconnectProtocolError, "Failed to login")
 			return
 		}
 
-		hpk := &protocol.NewIncomingConnection{
-			ServerAddress:   session.SystemAddress(),
-			ClientTimestamp: npk.ServerTimestamp,
-			ServerTimestamp: npk.ClientTimestamp,
+		if npk.ClientGuid != session.GUID {
+			session.Server.closeSession(session, DisconnectProtocolError, "Invalid client guid")
+			return
+		}
+
+		if npk.UseSecurity {
+			session.Server.closeSession(session, DisconnectProtocolError, "Security isn't supported")
+			return
+		}
+
+		hpk := &protocol.ConnectionRequestAccepted{
+			ClientAddress:     session.SystemAddress(),
+			InternalAddresses: session.Server.internalAddresses(),
+			ClientTimestamp:   npk.Timestamp,
+			ServerTimestamp:   session.Server.Timestamp(),
+			AddressCount:      session.Server.InternalAddressCount,
 		}
 
 		err = hpk.Encode()
 		if err != nil {
-			session.Server.CloseSession(session.Addr, "Failed to login")
+			session.Logger.Warn("Failed to encode a packet", "packet", hpk.ID(), "error", err)
+
+			session.Server.closeSession(session, DisconnectProtocolError, "Failed to login")
+			return
+		}
+
+		session.observeHandshake(StepConnectionRequest)
+
+		_, err = session.SendPacket(hpk, raknet.ReliableOrdered, channel)
+		if err != nil {
+			session.Logger.Warn("Failed to send a packet", "packet", hpk.ID(), "error", err)
+		}
+	case *protocol.NewIncomingConnection:
+		if session.State() != StateHandshaking {
 			return
 		}
 
-		_, err = session.SendPacket(hpk, raknet.ReliableOrderedWithACKReceipt, channel)
+		err := npk.Decode()
 		if err != nil {
-			session.Logger.Warn(err)
+			session.packetLogger.Warn("Failed to decode a packet", "packet", npk.ID(), "state", session.State(), "error", err)
+
+			session.Server.closeSession(session, DisconnectProtocolError, "Failed to login")
+			return
+		}
+
+		session.connectedTime = session.Server.now()
+
+		// A session closed in another goroutine isn't connected again
+		if !atomic.CompareAndSwapInt32(&session.state, int32(StateHandshaking), int32(StateConnected)) {
+			return
+		}
+
+		session.Server.sessionCounts.connected(session)
+
+		if session.Server.MigrationEnabled {
+			session.sendMigrationToken()
 		}
 
-		session.State = StateConnected
-		session.connectedTime = time.Now()
+		session.observeHandshake(StepNewIncomingConnection)
 
 		for _, handler := range session.Server.Handlers {
-			handler.OpenedConn(session.GUID, session.Addr)
+			h, ok := handler.(OpenedConnHandler)
+			if ok {
+				h.OpenedConn(session.GUID, session.Addr())
+			}
+
+			sh, ok := handler.(OpenedSessionHandler)
+			if ok {
+				sh.OpenedSession(session)
+			}
 		}
 	case *protocol.DisconnectionNotification:
 		err := npk.Decode()
 		if err != nil {
-			session.Logger.Warn(err)
+			session.packetLogger.Warn("Failed to decode a packet", "packet", npk.ID(), "error", err)
 			return
 		}
 
-		session.Server.CloseSession(session.Addr, "Server disconnected")
+		session.Server.closeSession(session, DisconnectClientRequested, "Client disconnected")
 	default:
 		if npk.ID() >= protocol.IDUserPacketEnum { // user packet
-			for _, hand := range session.Server.Handlers {
-				hand.HandlePacket(session.GUID, npk)
+			// Handlers get packets only after the handshake, such as OpenedConn
+			if session.State() != StateConnected {
+				session.Logger.Debug("Dropped a packet before the handshake completed", "packet", npk.ID(), "state", session.State())
+				return
+			}
+
+			start := time.Now()
+
+			for _, handler := range session.Server.Handlers {
+				h, ok := handler.(PacketHandler)
+				if ok {
+					h.HandlePacket(session.GUID, npk)
+				}
+
+				sh, ok := handler.(SessionPacketHandler)
+				if ok {
+					sh.HandleSessionPacket(session, npk)
+				}
+			}
+
+			if session.Server.Observer != nil {
+				session.Server.Observer.Delivered(session.Context(), session, npk, start, time.Since(start))
 			}
 		} else { // unknown packet
-			for _, hand := range session.Server.Handlers {
-				hand.HandleUnknownPacket(session.GUID, npk)
+			for _, handler := range session.Server.Handlers {
+				h, ok := handler.(UnknownPacketHandler)
+				if ok {
+					h.HandleUnknownPacket(session.GUID, npk)
+				}
 			}
 		}
 	}
 }
 
 func (session *Session) handleCustomPacket(cpk *protocol.CustomPacket) {
-	if session.State == StateDisconected {
+	if session.State() == StateDisconected {
 		return
 	}
 
-	for _, handler := range session.Server.Handlers { // Debug: I'll remove
-		handler.HandlePacket(session.GUID, cpk)
-	}
-
 	session.PacketReceivedCount++
 
 	// Generate NACK if needed
-	diff := cpk.Index - session.receiveSequenceNumber
-	if diff > 1 { // it need a serial number
+	if cpk.Index > session.receiveSequenceNumber {
+		diff := cpk.Index - session.receiveSequenceNumber
 		if diff > 2 {
 			session.sendACK(protocol.TypeNACK, &raknet.Record{
 				Index:    int(session.receiveSequenceNumber.Add(1)),
 				EndIndex: int(cpk.Index.Sub(1)),
 			})
-		} else {
+		} else if diff > 1 {
 			session.sendACK(protocol.TypeNACK, &raknet.Record{
 				Index: int(cpk.Index.Sub(1)),
 			})
 		}
-	}
 
-	// Handle epks if it's a newer packet
-	if cpk.Index >= session.receiveSequenceNumber {
 		session.receiveSequenceNumber = cpk.Index
-		for _, epk := range cpk.Messages {
-			session.handleEncapsulated(epk)
+	}
+
+	// Older packets are handled too, as they may be late or resent
+	// Duplicated reliable packets are dropped in handleEncapsulated.
+	accepted := true
+	for _, epk := range cpk.Messages {
+		session.traceMessage(LayerMessage, TraceIn, epk)
+
+		if !session.handleEncapsulated(epk) {
+			accepted = false
 		}
+	}
+
+	session.LastPacketReceiveTime = session.Server.now()
 
-		session.LastPacketReceiveTime = time.Now()
+	// Don't send ACK to get the packet again if it couldn't be kept
+	if !accepted {
+		return
 	}
 
 	// Send ACK
@@ -335,57 +569,77 @@ func (session *Session) handleCustomPacket(cpk *protocol.CustomPacket) {
 }
 
 func (session *Session) handleACKPacket(pk *protocol.Acknowledge) {
-	if session.State == StateDisconected {
+	if session.State() == StateDisconected {
 		return
 	}
 
 	switch pk.Type {
 	case protocol.TypeACK:
 		for _, record := range pk.Records {
-			index := record.Index
-
-			_, ok := session.ackReceiptPackets[index]
-			if ok {
+			for _, index := range record.Numbers() {
+				session.sendMutex.Lock()
 				delete(session.ackReceiptPackets, index)
-			}
+				session.sendMutex.Unlock()
 
-			session.recoveryQueue.Remove(index)
+				session.recoveryQueue.Remove(index)
+			}
 		}
 	case protocol.TypeNACK:
-		for i := 0; i < len(pk.Records); i++ {
-			record := pk.Records[i]
-			index := record.Index
-
-			// If the packet is unreliable, send lost packets
-			// but don't send after that
-			p, ok := session.ackReceiptPackets[index]
-			if ok && !p.Reliability.IsReliable() {
-				delete(session.ackReceiptPackets, index)
+		session.stats.nackReceived()
+
+		for _, record := range pk.Records {
+			for _, index := range record.Numbers() {
+				session.resend(index)
 			}
+		}
+	}
 
-			if session.existRecoveryQueue(index) {
-				epks, ok := session.getRecoveryQueue(index)
-				if ok {
-					break
-				}
+	session.LastPacketReceiveTime = session.Server.now()
+}
 
-				nindex, err := session.SendCustomPacket(epks, false)
-				if err != nil {
-					session.Logger.Warn(err)
-					break
-				}
+// resend resends the lost packet with the sequence number
+func (session *Session) resend(index int) {
+	// If the packet is unreliable, send lost packets
+	// but don't send after that
+	session.sendMutex.Lock()
+	p, ok := session.ackReceiptPackets[index]
+	if ok && !p.Reliability.IsReliable() {
+		delete(session.ackReceiptPackets, index)
+	}
+	session.sendMutex.Unlock()
 
-				session.renameRecoveryQueue(index, nindex)
-			}
-		}
+	dg, ok := session.getRecoveryQueue(index)
+	if !ok {
+		return
 	}
 
-	session.LastPacketReceiveTime = time.Now()
+	nindex, err := session.SendCustomPacket(dg.epks, false)
+	if err != nil {
+		session.Logger.Warn("Failed to resend a datagram", "index", index, "error", err)
+		return
+	}
+
+	session.stats.retransmitted()
+
+	session.renameRecoveryQueue(index, nindex)
 }
 
-func (session *Session) handleEncapsulated(epk *protocol.EncapsulatedPacket) {
+// handleEncapsulated handles the encapsulated packet
+// It returns false if the packet couldn't be kept, so the client should resend it.
+func (session *Session) handleEncapsulated(epk *protocol.EncapsulatedPacket) bool {
 	reliability := epk.Reliability
 
+	// Make sure we are not handling a duplicate
+	// Each part of a split packet has its own message index.
+	if reliability.IsReliable() {
+		_, ok := session.reliablePackets[epk.MessageIndex]
+		if ok {
+			session.stats.duplicate()
+			session.traceDelivery(epk, DeliveryDuplicate)
+			return true
+		}
+	}
+
 	if epk.Split {
 		spk, ok := session.splitQueue[epk.SplitID]
 		if !ok {
@@ -399,8 +653,9 @@ func (session *Session) handleEncapsulated(epk *protocol.EncapsulatedPacket) {
 				}
 
 				if len(session.splitQueue)+1 > raknet.MaxSplitsPerQueue {
-					session.Logger.Warn("Failed to make space of split queue")
-					return
+					session.packetLogger.Warn("Failed to make space of split queue", "split", epk.SplitID)
+					session.traceDelivery(epk, DeliveryRejected)
+					return false
 				}
 			}
 
@@ -413,57 +668,87 @@ func (session *Session) handleEncapsulated(epk *protocol.EncapsulatedPacket) {
 			spk = session.splitQueue[epk.SplitID]
 		}
 
+		// The part is marked after it's kept, as it's resent if it couldn't be kept
+		if reliability.IsReliable() {
+			session.reliablePackets[epk.MessageIndex] = true
+		}
+
 		// Add split packet and get complete payload if it's completed
 		payload := spk.Update(epk)
 		if payload == nil {
-			return
+			session.traceDelivery(epk, DeliverySplit)
+			return true
 		}
 
 		epk.Payload = payload
 		delete(session.splitQueue, epk.SplitID)
-	}
-
-	// Make sure we are not handling a duplicate
-	if reliability.IsReliable() {
-		_, ok := session.reliablePackets[epk.MessageIndex]
-		if ok {
-			return
-		}
 
+		session.stats.splitReassembled()
+		session.traceMessage(LayerReassembly, TraceIn, epk)
+	} else if reliability.IsReliable() {
 		session.reliablePackets[epk.MessageIndex] = true
 	}
 
+	// An ordered message before the next index was already delivered, so it'd be kept in the queue forever
+	if reliability.IsOrdered() && epk.OrderIndex.Before(session.orderReceiveIndex[int(epk.OrderChannel)]) {
+		session.stats.duplicate()
+		session.traceDelivery(epk, DeliveryDuplicate)
+		return true
+	}
+
+	session.stats.messageReceived(reliability)
+
 	if epk.OrderChannel >= raknet.MaxChannels {
-		session.Logger.Warn("Invalid channel")
-		return
+		session.packetLogger.Warn("Invalid channel", "channel", epk.OrderChannel)
+		session.traceDelivery(epk, DeliveryInvalidChannel)
+		return true
 	}
 
 	if reliability.IsOrdered() {
-		queue := session.handleQueue[epk.OrderChannel]
+		queue, ok := session.handleQueue[epk.OrderChannel]
+		if !ok {
+			queue = make(map[binary.Triad]*protocol.EncapsulatedPacket)
+			session.handleQueue[epk.OrderChannel] = queue
+		}
+
+		index := session.orderReceiveIndex[int(epk.OrderChannel)]
+
+		// A packet waiting for earlier packets is kept over reads, so copy its payload
+		if epk.OrderIndex != index {
+			epk.Payload = append([]byte(nil), epk.Payload...)
+			session.traceDelivery(epk, DeliveryBuffered)
+		}
 
 		queue[epk.OrderIndex] = epk
 
-		index := session.orderReceiveIndex[int(epk.OrderChannel)]
 		for {
 			p, ok := queue[index]
 			if !ok {
 				break
 			}
 
-			delete(session.handleQueue[epk.OrderChannel], index)
+			delete(queue, index)
 
-			index++
+			index = index.Bump()
+			session.orderReceiveIndex[int(epk.OrderChannel)] = index
 
-			session.handlePacket(protocol.NewRawPacket(p.Payload), int(epk.OrderChannel))
+			session.traceDelivery(p, Delivered)
+			session.handlePacket(session.Server.messagePacket(p.Payload), int(epk.OrderChannel))
 		}
 	} else if reliability.IsSequenced() {
-		if epk.OrderIndex > session.sequenceReceiveIndex[int(epk.OrderChannel)] {
-			session.sequenceReceiveIndex[int(epk.OrderChannel)] = epk.OrderIndex
-			session.handlePacket(protocol.NewRawPacket(epk.Payload), int(epk.OrderChannel))
+		if epk.OrderIndex >= session.sequenceReceiveIndex[int(epk.OrderChannel)] {
+			session.sequenceReceiveIndex[int(epk.OrderChannel)] = epk.OrderIndex.Bump()
+			session.traceDelivery(epk, Delivered)
+			session.handlePacket(session.Server.messagePacket(epk.Payload), int(epk.OrderChannel))
+		} else {
+			session.traceDelivery(epk, DeliverySequencedDropped)
 		}
 	} else {
-		session.handlePacket(protocol.NewRawPacket(epk.Payload), int(epk.OrderChannel))
+		session.traceDelivery(epk, Delivered)
+		session.handlePacket(session.Server.messagePacket(epk.Payload), int(epk.OrderChannel))
 	}
+
+	return true
 }
 
 func (session *Session) addSendQueue(epk *protocol.EncapsulatedPacket) {
@@ -472,30 +757,43 @@ func (session *Session) addSendQueue(epk *protocol.EncapsulatedPacket) {
 
 func (session *Session) bumpOrderSendIndex(channel int) (index binary.Triad) {
 	index = session.orderSendIndex[channel]
-	return BumpTriad(&index)
+	session.orderSendIndex[channel] = index.Bump()
+	return index
 }
 
 func (session *Session) bumpSequenceSendIndex(channel int) (index binary.Triad) {
 	index = session.sequenceSendIndex[channel]
-	return BumpTriad(&index)
+	session.sequenceSendIndex[channel] = index.Bump()
+	return index
 }
 
-func (session *Session) getRecoveryQueue(index int) ([]*protocol.EncapsulatedPacket, bool) {
+// recoveryDatagram is reliable messages of a datagram waiting for an ACK
+type recoveryDatagram struct {
+	epks []*protocol.EncapsulatedPacket
+
+	// time is the time the datagram was sent
+	time time.Time
+}
+
+func (session *Session) getRecoveryQueue(index int) (*recoveryDatagram, bool) {
 	v, ok := session.recoveryQueue.Get(index)
 	if !ok {
 		return nil, false
 	}
 
-	epks, ok := v.([]*protocol.EncapsulatedPacket)
+	dg, ok := v.(*recoveryDatagram)
 	if !ok {
-		panic("Invalid value, wants []*protocol.EncapsulatedPacket")
+		panic("Invalid value, wants *recoveryDatagram")
 	}
 
-	return epks, true
+	return dg, true
 }
 
 func (session *Session) setRecoveryQueue(index int, epks []*protocol.EncapsulatedPacket) {
-	session.recoveryQueue.Set(index, epks)
+	session.recoveryQueue.Set(index, &recoveryDatagram{
+		epks: epks,
+		time: session.Server.now(),
+	})
 }
 
 func (session *Session) removeRecoveryQueue(index int) {
@@ -506,34 +804,28 @@ func (session *Session) existRecoveryQueue(index int) bool {
 	return session.recoveryQueue.Exist(index)
 }
 
+// renameRecoveryQueue moves the datagram resent with the new index
 func (session *Session) renameRecoveryQueue(from int, to int) {
-	epks, ok := session.getRecoveryQueue(from)
+	dg, ok := session.getRecoveryQueue(from)
 	if !ok {
 		return
 	}
 
-	session.setRecoveryQueue(to, epks)
+	session.setRecoveryQueue(to, dg.epks)
 	session.removeRecoveryQueue(from)
 }
 
-func (session *Session) pollRecoveryQueue() (epks []*protocol.EncapsulatedPacket, ok bool) {
-	value, ok := session.recoveryQueue.Pop()
-	if !ok {
-		return nil, false
-	}
-
-	epks, ok = value.([]*protocol.EncapsulatedPacket)
-	if !ok {
-		return nil, false
-	}
-
-	return epks, true
-}
-
 func (session *Session) SendPacket(pk raknet.Packet, reliability raknet.Reliability, channel int) (protocol.EncapsulatedPacket, error) {
 	return session.SendPacketBytes(pk.Bytes(), reliability, channel)
 }
 
+// Send sends a message to the client
+func (session *Session) Send(b []byte, reliability raknet.Reliability, channel int) error {
+	_, err := session.SendPacketBytes(b, reliability, channel)
+
+	return err
+}
+
 func (session *Session) SendPacketBytes(b []byte, reliability raknet.Reliability, channel int) (protocol.EncapsulatedPacket, error) {
 	if channel >= raknet.MaxChannels {
 		return protocol.EncapsulatedPacket{}, errors.New("invalid channel")
@@ -545,6 +837,9 @@ func (session *Session) SendPacketBytes(b []byte, reliability raknet.Reliability
 		Payload:      b,
 	}
 
+	session.sendMutex.Lock()
+	defer session.sendMutex.Unlock()
+
 	if reliability.IsReliable() {
 		epk.MessageIndex = BumpTriad(&session.messageIndex)
 	}
@@ -559,6 +854,8 @@ func (session *Session) SendPacketBytes(b []byte, reliability raknet.Reliability
 		//session.Logger.Debug("Bumped" + )
 	}
 
+	session.stats.messageSent(reliability)
+
 	if needSplit(epk.Reliability, b, session.MTU) {
 		epk.SplitID = BumpUInt16(&session.splitID)
 
@@ -573,32 +870,72 @@ func (session *Session) SendPacketBytes(b []byte, reliability raknet.Reliability
 }
 
 func (session *Session) SendCustomPacket(epks []*protocol.EncapsulatedPacket, updateRecoveryQueue bool) (int, error) {
-	cpk := protocol.NewCustomPacket(protocol.IDCustom4)
-	cpk.Index = BumpTriad(&session.sendSequenceNumber)
-	cpk.Messages = epks
+	session.sendMutex.Lock()
 
-	err := cpk.Encode()
-	if err != nil {
-		return 0, err
+	index := BumpTriad(&session.sendSequenceNumber)
+
+	buf := session.Server.buffers.Get()
+	b := protocol.AppendCustomPacket((*buf)[:0], protocol.IDCustom4, index, epks)
+
+	session.traceDatagram(TraceOut, b[0], len(b), index, epks)
+	for _, epk := range epks {
+		session.traceMessage(LayerMessage, TraceOut, epk)
 	}
 
-	for _, epk := range cpk.Messages {
-		session.ackReceiptPackets[epk.Record.Index] = epk
+	*buf = b
+	conn, raddr := session.path()
+
+	// Handlers are called after sendMutex is unlocked, so they can send packets to the session.
+	// The buffer may be written and reused by then, so they're passed a copy.
+	var sent []byte
+	if session.Server.Handlers.hasSendPacketHandler() {
+		sent = append([]byte(nil), b...)
+	}
+
+	if session.Server.queueBuffer(conn, raddr, buf) {
+		session.stats.datagramSent(b[0], len(b))
 	}
 
-	session.SendRawPacket(cpk)
+	reliables := 0
+	for _, epk := range epks {
+		if epk.Reliability.IsNeededACK() {
+			epk.Record = &raknet.Record{
+				Index: int(index),
+			}
+
+			session.ackReceiptPackets[int(index)] = epk
+		}
 
-	if updateRecoveryQueue {
-		cpk.RemoveUnreliables()
-		if len(cpk.Messages) > 0 {
-			session.setRecoveryQueue(int(cpk.Index), cpk.Messages)
+		if epk.Reliability.IsReliable() {
+			reliables++
 		}
 	}
 
+	if updateRecoveryQueue && reliables > 0 {
+		if reliables < len(epks) {
+			reliable := make([]*protocol.EncapsulatedPacket, 0, reliables)
+			for _, epk := range epks {
+				if epk.Reliability.IsReliable() {
+					reliable = append(reliable, epk)
+				}
+			}
+
+			epks = reliable
+		}
+
+		session.setRecoveryQueue(int(index), epks)
+	}
+
 	session.PacketSentCount++
-	session.LastPacketSendTime = time.Now()
+	session.LastPacketSendTime = session.Server.now()
+
+	session.sendMutex.Unlock()
 
-	return int(cpk.Index), nil
+	if sent != nil {
+		session.Server.handleSendPacket(raddr, sent)
+	}
+
+	return int(index), nil
 }
 
 func (session *Session) splitPacket(epk *protocol.EncapsulatedPacket) []*protocol.EncapsulatedPacket {
@@ -636,109 +973,126 @@ func (session *Session) splitPacket(epk *protocol.EncapsulatedPacket) []*protoco
 }
 
 func (session *Session) sendACK(typ protocol.ACKType, records ...*raknet.Record) {
-	ack := &protocol.Acknowledge{
-		Type:    typ,
-		Records: records,
-	}
+	buf := session.Server.buffers.Get()
+	b := protocol.AppendAcknowledge((*buf)[:0], typ, records)
 
-	err := ack.Encode()
-	if err != nil {
-		session.Logger.Warn(err)
+	session.traceACK(TraceOut, b[0], len(b), records)
 
-		return
+	*buf = b
+	conn, raddr := session.path()
+	if session.Server.sendBuffer(conn, raddr, buf) {
+		session.stats.datagramSent(b[0], len(b))
 	}
 
-	session.SendRawPacket(ack)
+	if typ == protocol.TypeNACK {
+		session.stats.nackSent()
+	}
 
-	session.LastPacketSendTime = time.Now()
+	session.LastPacketSendTime = session.Server.now()
 }
 
 func (session *Session) SendRawPacket(pk raknet.Packet) {
-	session.Server.SendRawPacket(session.Addr, pk.Bytes())
+	b := pk.Bytes()
+
+	buf := session.Server.buffers.Get()
+	*buf = append((*buf)[:0], b...)
+
+	conn, raddr := session.path()
+	if session.Server.sendBuffer(conn, raddr, buf) {
+		session.stats.datagramSent(b[0], len(b))
+	}
 }
 
 func (session *Session) update() bool {
-	if session.State == StateDisconected {
+	if session.State() == StateDisconected {
 		return false
 	}
 
-	current := time.Now()
-
-	// send packets in the send queue
-	if !session.sendQueue.IsEmpty() && session.PacketSentCount < raknet.MaxPacketsPerSecond {
-		var send []*protocol.EncapsulatedPacket
-		sendLen := protocol.CalcCPacketBaseSize()
-
-		session.sendQueue.Range(func(value interface{}) bool {
-			epk, ok := value.(*protocol.EncapsulatedPacket)
-			if !ok {
-				return true
-			}
-
-			sendLen += epk.CalcSize()
-			if sendLen > session.MTU {
-				return false
-			}
+	current := session.Server.now()
 
-			send = append(send, epk)
-			session.sendQueue.Remove()
+	// Packets are kept in the queues while the writer is busy
+	conn, raddr := session.path()
+	writable := session.Server.writable(conn, raddr)
 
-			return true
-		})
-
-		if len(send) > 0 {
-			session.SendCustomPacket(send, true)
-		}
+	// send packets in the send queue
+	if writable && session.PacketSentCount < raknet.MaxPacketsPerSecond {
+		session.flushSendQueue()
 	}
 
-	// resend lost packets
-	if current.Sub(session.LastRecoverySendTime) >= raknet.RecoverySendInterval {
-		pks, ok := session.pollRecoveryQueue()
+	// resend the oldest packet not acknowledged yet
+	// It's resent only if it was sent RecoverySendInterval ago, so a new packet isn't resent at once.
+	if writable && current.Sub(session.LastRecoverySendTime) >= raknet.RecoverySendInterval {
+		key, ok := session.recoveryQueue.FirstKey()
 		if ok {
-			session.SendCustomPacket(pks, false)
-			session.LastRecoverySendTime = time.Now()
+			dg, ok := session.getRecoveryQueue(key.(int))
+			if ok && current.Sub(dg.time) >= raknet.RecoverySendInterval {
+				session.resend(key.(int))
+				session.LastRecoverySendTime = session.Server.now()
+			}
 		}
 	}
 
 	// Send ping to detect latency if it is enabled
 	if session.latencyEnabled && current.Sub(session.LastPingSendTime) >= raknet.PingSendInterval &&
-		session.State == StateConnected {
+		session.State() == StateConnected {
 		ping := &protocol.ConnectedPing{
 			Timestamp: session.Timestamp(),
 		}
 
 		err := ping.Encode()
 		if err != nil {
-			session.Logger.Warn(err)
+			session.Logger.Warn("Failed to encode a packet", "packet", ping.ID(), "error", err)
 		} else {
 			session.SendPacket(ping, raknet.Unreliable, raknet.DefaultChannel)
 			session.LastPingSendTime = current
+
+			session.sendMutex.Lock()
+
+			// Unanswered pings are forgotten over the limit
+			if len(session.latencyTimestamps) >= maxLatencyTimestamps {
+				session.latencyTimestamps = session.latencyTimestamps[1:]
+			}
+
 			session.latencyTimestamps = append(session.latencyTimestamps, ping.Timestamp)
+
+			session.sendMutex.Unlock()
 		}
 
 	}
 
 	if current.Sub(session.LastPacketReceiveTime) >= raknet.DetectionSendInterval &&
 		current.Sub(session.LastKeepAliveSendTime) >= raknet.DetectionSendInterval &&
-		session.State == StateConnected {
+		session.State() == StateConnected {
 
 		session.SendPacket(&protocol.DetectLostConnections{}, raknet.Unreliable, raknet.DefaultChannel)
-		session.LastKeepAliveSendTime = time.Now()
+		session.LastKeepAliveSendTime = session.Server.now()
 
-		session.Logger.Debug("Sent DetectLostConnections packet to the client")
+		session.Logger.Debug("Sent DetectLostConnections packet to the client", "addr", session.Addr())
+	}
+
+	// Close half-open sessions
+	if session.State() == StateHandshaking && current.Sub(session.createdTime) >= session.Server.HandshakeTimeout {
+		session.close(DisconnectTimeout, "Handshake timed out")
+
+		return false
 	}
 
 	// Time out
 	if current.Sub(session.LastPacketReceiveTime) >= raknet.SessionTimeout {
 		for _, handler := range session.Server.Handlers {
-			handler.Timedout(session.GUID)
+			h, ok := handler.(TimeoutHandler)
+			if ok {
+				h.Timedout(session.GUID)
+			}
 		}
 
+		session.close(DisconnectTimeout, "")
+
 		return false
 	}
 
 	// Reset counters
-	if current.Sub(session.LastPacketCounterResetTime) >= 1000 {
+	if current.Sub(session.LastPacketCounterResetTime) >= time.Second {
 		session.PacketSentCount = 0
 		session.PacketReceivedCount = 0
 		session.LastPacketCounterResetTime = current
@@ -747,22 +1101,159 @@ func (session *Session) update() bool {
 	return true
 }
 
-// Close closes the session
-func (session *Session) Close() error {
-	if session.State == StateDisconected {
+// flushSendQueue sends packets in the send queue as a custom packet
+func (session *Session) flushSendQueue() {
+	if session.sendQueue.IsEmpty() {
+		return
+	}
+
+	var send []*protocol.EncapsulatedPacket
+	sendLen := protocol.CalcCPacketBaseSize()
+
+	for {
+		value, ok := session.sendQueue.Peek()
+		if !ok {
+			break
+		}
+
+		epk, ok := value.(*protocol.EncapsulatedPacket)
+		if !ok {
+			session.sendQueue.Remove()
+			continue
+		}
+
+		sendLen += epk.CalcSize()
+		if sendLen > session.MTU && len(send) > 0 {
+			break
+		}
+
+		send = append(send, epk)
+		session.sendQueue.Remove()
+	}
+
+	if len(send) > 0 {
+		session.SendCustomPacket(send, true)
+	}
+}
+
+// Closed returns a channel closed when the session is closed
+func (session *Session) Closed() <-chan struct{} {
+	return session.closed
+}
+
+// DisconnectReason returns the reason why the session was closed, and its message
+// It's valid after the session is closed.
+func (session *Session) DisconnectReason() (DisconnectReason, string) {
+	session.closeMutex.Lock()
+	defer session.closeMutex.Unlock()
+
+	return session.disconnectReason, session.disconnectMessage
+}
+
+// Close closes the session, and removes it from the server
+// reason is a message to tell handlers
+func (session *Session) Close(reason string) error {
+	session.Server.removeSession(session)
+
+	return session.close(DisconnectKicked, reason)
+}
+
+// SetValue sets the value with the key to the session, such as application state of the client
+// The key should be an unexported type like a context key. If the value is nil, it's removed.
+func (session *Session) SetValue(key interface{}, value interface{}) {
+	session.valuesMutex.Lock()
+	defer session.valuesMutex.Unlock()
+
+	if value == nil {
+		delete(session.values, key)
+		return
+	}
+
+	if session.values == nil {
+		session.values = make(map[interface{}]interface{})
+	}
+
+	session.values[key] = value
+}
+
+// Value returns the value with the key set by SetValue
+// If it's not set, it returns the value of the session's context.
+func (session *Session) Value(key interface{}) interface{} {
+	session.valuesMutex.RLock()
+	value, ok := session.values[key]
+	session.valuesMutex.RUnlock()
+
+	if ok {
+		return value
+	}
+
+	return session.Context().Value(key)
+}
+
+// close closes the session with the reason
+// Handlers and the closed channel are notified only once.
+func (session *Session) close(reason DisconnectReason, message string) error {
+	session.closeMutex.Lock()
+
+	if session.State() == StateDisconected {
+		session.closeMutex.Unlock()
 		return errSessionClosed
 	}
 
-	//session.Server.CloseSession(session.UUID, "Disconnected from server")
+	if reason.notifies() {
+		session.sendQueue.Clear()
 
-	session.sendQueue.Clear()
+		// send a disconnection notification packet
+		npk := &protocol.DisconnectionNotification{}
 
-	// send a disconnection notification packet
-	session.SendPacket(&protocol.DisconnectionNotification{}, raknet.Unreliable, 0)
+		err := npk.Encode()
+		if err != nil {
+			session.Logger.Warn("Failed to encode a packet", "packet", npk.ID(), "error", err)
+		} else {
+			session.SendPacket(npk, raknet.Unreliable, raknet.DefaultChannel)
+			session.flushSendQueue()
+		}
+	}
 
-	session.update()
+	state := SessionState(atomic.SwapInt32(&session.state, int32(StateDisconected)))
+	session.disconnectReason = reason
+	session.disconnectMessage = message
 
-	session.State = StateDisconected
+	session.closeMutex.Unlock()
+
+	close(session.closed)
+
+	for _, handler := range session.Server.Handlers {
+		if state == StateHandshaking {
+			h, ok := handler.(ClosedPreConnHandler)
+			if ok {
+				h.ClosedPreConn(session.GUID)
+			}
+		} else {
+			h, ok := handler.(ClosedConnHandler)
+			if ok {
+				h.ClosedConn(session.GUID, reason, message)
+			} else {
+				lh, ok := handler.(LegacyClosedConnHandler)
+				if ok {
+					lh.ClosedConn(session.GUID)
+				}
+			}
+
+			sh, ok := handler.(ClosedSessionHandler)
+			if ok {
+				sh.ClosedSession(session, reason, message)
+			}
+		}
+	}
+
+	if session.Server.Observer != nil {
+		session.Server.Observer.Closed(session.Context(), session, reason, message)
+	}
+
+	if session.cancel != nil {
+		session.cancel()
+	}
 
 	return nil
 }
@@ -0,0 +1,341 @@
+package server_test
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/protocol"
+	"github.com/beito123/go-raknet/raknettest"
+	"github.com/beito123/go-raknet/server"
+)
+
+func TestACK(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser := serve(t, network)
+	client := dial(t, network)
+
+	for i := 0; i < 10; i++ {
+		client.Send([]byte{0x90, byte(i)}, raknet.ReliableOrdered, raknet.DefaultChannel)
+	}
+
+	// Datagrams not acknowledged are resent after RecoverySendInterval
+	network.Advance(raknet.RecoverySendInterval * 4)
+
+	if len(client.Packets()) != 10 {
+		t.Fatalf("echoed %d packets, want 10", len(client.Packets()))
+	}
+
+	stats := session(t, ser).Stats()
+	if stats.RecoveryQueueDepth != 0 || stats.Retransmissions != 0 {
+		t.Fatalf("%d datagrams waiting for ACKs and %d resent, want ACKs to be handled",
+			stats.RecoveryQueueDepth, stats.Retransmissions)
+	}
+}
+
+func TestLoss(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	serve(t, network)
+	client := dial(t, network)
+
+	// Lost datagrams are resent with NACKs or after RecoverySendInterval
+	network.Loss = 0.2
+
+	for i := 0; i < 20; i++ {
+		client.Send([]byte{0x90, byte(i)}, raknet.ReliableOrdered, raknet.DefaultChannel)
+	}
+
+	network.Advance(5 * time.Second)
+
+	if len(client.Packets()) != 20 {
+		t.Fatalf("echoed %d packets, want 20", len(client.Packets()))
+	}
+}
+
+func TestNACKRange(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser := serve(t, network)
+	client := dial(t, network)
+
+	// Loses the first 3 datagrams to the client, so it sends a NACK with the range
+	lost := 0
+	network.Drop = func(from net.Addr, to net.Addr, b []byte) bool {
+		if from.String() != serverAddress || b[0]&0x80 == 0 || b[0] == protocol.IDACK || b[0] == protocol.IDNACK {
+			return false
+		}
+
+		lost++
+
+		return lost <= 3
+	}
+
+	session := session(t, ser)
+	for i := 0; i < 4; i++ {
+		// A datagram is sent for each message over half of MTU
+		b := bytes.Repeat([]byte{byte(i)}, raknet.MaxMTU/2)
+		b[0] = 0x90
+
+		session.Send(b, raknet.ReliableOrdered, raknet.DefaultChannel)
+	}
+
+	// Lost datagrams are resent with the NACK before RecoverySendInterval
+	network.Advance(raknet.RecoverySendInterval / 2)
+
+	if len(client.Packets()) != 4 {
+		t.Fatalf("received %d packets, want 4", len(client.Packets()))
+	}
+
+	stats := session.Stats()
+	if stats.NACKsReceived != 1 || stats.Retransmissions != 3 {
+		t.Fatalf("%d NACKs received and %d datagrams resent, want 1 and 3", stats.NACKsReceived, stats.Retransmissions)
+	}
+}
+
+func TestSendQueueOrder(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser := serve(t, network)
+	client := dial(t, network)
+
+	// Unreliable messages are sent in the order of the send queue
+	session := session(t, ser)
+	for i := 0; i < 10; i++ {
+		session.Send([]byte{0x90, byte(i)}, raknet.Unreliable, raknet.DefaultChannel)
+	}
+
+	network.Advance(10 * time.Millisecond)
+
+	pks := client.Packets()
+	if len(pks) != 10 {
+		t.Fatalf("received %d packets, want 10", len(pks))
+	}
+
+	for i, pk := range pks {
+		if pk[1] != byte(i) {
+			t.Fatalf("packet %d is received at %d", pk[1], i)
+		}
+	}
+}
+
+func TestConcurrentSend(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser := serve(t, network)
+	client := dial(t, network)
+
+	// Handlers may send from their goroutines while the server updates the session
+	session := session(t, ser)
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			for j := 0; j < 25; j++ {
+				session.Send([]byte{0x90, byte(i), byte(j)}, raknet.Reliable, raknet.DefaultChannel)
+			}
+
+			done <- struct{}{}
+		}(i)
+	}
+
+	for i := 0; i < 4; {
+		select {
+		case <-done:
+			i++
+		default:
+			network.Advance(time.Millisecond)
+		}
+	}
+
+	network.Advance(time.Second)
+
+	received := make(map[string]bool)
+	for _, pk := range client.Packets() {
+		if received[string(pk)] {
+			t.Fatalf("packet %v is received twice", pk)
+		}
+
+		received[string(pk)] = true
+	}
+
+	if len(received) != 100 {
+		t.Fatalf("received %d packets, want 100", len(received))
+	}
+}
+
+func TestLateDatagram(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser := serve(t, network)
+	client := dial(t, network)
+
+	// The first datagram arrives after the second one,
+	// and the NACK for it is lost so that the client doesn't resend it
+	network.Drop = func(from net.Addr, to net.Addr, b []byte) bool {
+		return b[0] == protocol.IDNACK
+	}
+
+	network.Latency = 20 * time.Millisecond
+	client.Send([]byte{0x90, 0}, raknet.Reliable, raknet.DefaultChannel)
+
+	network.Latency = 0
+	client.Send([]byte{0x90, 1}, raknet.Reliable, raknet.DefaultChannel)
+
+	// The late datagram is handled before the client resends it after RecoverySendInterval
+	network.Advance(30 * time.Millisecond)
+
+	if len(client.Packets()) != 2 {
+		t.Fatalf("echoed %d packets, want 2", len(client.Packets()))
+	}
+
+	if session(t, ser).Stats().DuplicatesDropped != 0 {
+		t.Fatal("the late datagram is resent")
+	}
+}
+
+func TestRejectedDatagram(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	serve(t, network)
+	client := dial(t, network)
+
+	// The last parts of the first split packets are lost, so the split queue is full
+	// when the parts of the last one arrive
+	sent := 0
+	network.Drop = func(from net.Addr, to net.Addr, b []byte) bool {
+		if from.String() != clientAddress || b[0]&0xf0 != 0x80 {
+			return false
+		}
+
+		sent++
+
+		return sent <= raknet.MaxSplitsPerQueue*2 && sent%2 == 0
+	}
+
+	var want [][]byte
+	for i := 0; i <= raknet.MaxSplitsPerQueue; i++ {
+		b := bytes.Repeat([]byte{byte(i)}, raknet.MaxMTU+100)
+		b[0] = 0x90
+
+		want = append(want, b)
+
+		client.Send(b, raknet.Reliable, raknet.DefaultChannel)
+	}
+
+	// Parts rejected aren't acknowledged, so the client resends them
+	network.Advance(time.Second)
+
+	if len(client.Packets()) != len(want) {
+		t.Fatalf("echoed %d packets, want %d", len(client.Packets()), len(want))
+	}
+}
+
+func TestNegotiatedMTU(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	// Datagrams over the MTU are dropped, and the client requests it
+	network.MTU = raknet.MinMTU + 100
+
+	ser := serve(t, network)
+	client := dial(t, network)
+
+	if session(t, ser).MTU != network.MTU {
+		t.Fatalf("the session's MTU is %d, want %d", session(t, ser).MTU, network.MTU)
+	}
+
+	b := bytes.Repeat([]byte{1}, raknet.MaxMTU)
+	b[0] = 0x90
+
+	client.Send(b, raknet.ReliableOrdered, raknet.DefaultChannel)
+
+	network.Advance(10 * time.Millisecond)
+
+	if len(client.Packets()) != 1 {
+		t.Fatal("the packet isn't echoed")
+	}
+
+	_, _, dropped := network.Count()
+	if dropped != 0 {
+		t.Fatalf("%d datagrams over the MTU are dropped", dropped)
+	}
+}
+
+func TestPacketsPerSecond(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+
+	ser := serve(t, network)
+	client := dial(t, network)
+
+	// sends n packets every 100 milliseconds for the duration
+	send := func(n int, d time.Duration) {
+		for elapsed := time.Duration(0); elapsed < d; elapsed += 100 * time.Millisecond {
+			for i := 0; i < n; i++ {
+				client.Send([]byte{0x90}, raknet.Unreliable, raknet.DefaultChannel)
+			}
+
+			network.Advance(100 * time.Millisecond)
+		}
+	}
+
+	ip := net.IPv4(10, 0, 0, 2)
+
+	send(raknet.MaxPacketsPerSecond/20, 3*time.Second)
+
+	if ser.Count() != 1 || ser.HasBlockedAddress(ip) {
+		t.Fatal("the client is blocked under the limit")
+	}
+
+	// The counters are reset every second, not on every update
+	send(raknet.MaxPacketsPerSecond/5, time.Second)
+
+	if !ser.HasBlockedAddress(ip) {
+		t.Fatal("the client isn't blocked over the limit in a second")
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+	network.Latency = 20 * time.Millisecond
+
+	ser := serve(t, network)
+	dial(t, network)
+
+	s := session(t, ser)
+
+	// The latency is measured with pings while the session is connected
+	network.Advance(10 * time.Second)
+
+	if s.Latency() < 40*time.Millisecond || s.Latency() > 100*time.Millisecond {
+		t.Fatalf("the latency is %v, want about 40ms", s.Latency())
+	}
+
+	err := s.Close("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ser.Count() != 0 {
+		t.Fatalf("%d sessions after the session is closed, want 0", ser.Count())
+	}
+
+	reason, message := s.DisconnectReason()
+	if reason != server.DisconnectKicked || message != "test" {
+		t.Fatalf("closed with %v %q, want %v %q", reason, message, server.DisconnectKicked, "test")
+	}
+
+	if s.Close("test") == nil {
+		t.Fatal("the closed session is closed again")
+	}
+}
@@ -31,16 +31,25 @@ type SplitPacket struct {
 }
 
 func (spk *SplitPacket) Update(epk *protocol.EncapsulatedPacket) (b []byte) {
-	if !epk.Split && int(epk.SplitID) != spk.SplitID &&
-		int(epk.SplitCount) != spk.SplitCount && epk.Reliability == spk.Reliability {
+	if !epk.Split || int(epk.SplitID) != spk.SplitID ||
+		int(epk.SplitCount) != spk.SplitCount || epk.Reliability != spk.Reliability {
 		return nil
 	}
 
-	spk.Payloads[int(epk.SplitIndex)] = epk.Payload
+	if epk.SplitIndex < 0 || int(epk.SplitIndex) >= spk.SplitCount {
+		return nil
+	}
+
+	if spk.Payloads == nil {
+		spk.Payloads = make(map[int][]byte, spk.SplitCount)
+	}
+
+	// The payload may refer to the read buffer, so it's copied to keep
+	spk.Payloads[int(epk.SplitIndex)] = append([]byte(nil), epk.Payload...)
 
 	if len(spk.Payloads) >= spk.SplitCount {
-		for _, payload := range spk.Payloads {
-			b = append(b, payload...)
+		for i := 0; i < spk.SplitCount; i++ {
+			b = append(b, spk.Payloads[i]...)
 		}
 
 		return b
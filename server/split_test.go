@@ -0,0 +1,76 @@
+package server_test
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/protocol"
+	"github.com/beito123/go-raknet/raknettest"
+	"github.com/beito123/go-raknet/server"
+)
+
+func TestSplitPacket(t *testing.T) {
+	spk := &server.SplitPacket{
+		SplitID:     1,
+		SplitCount:  3,
+		Reliability: raknet.ReliableOrdered,
+	}
+
+	// Parts arrive out of order in a buffer reused for each datagram
+	buf := make([]byte, 2)
+	var b []byte
+	for _, i := range []int{2, 0, 1} {
+		buf[0], buf[1] = byte(i), byte(i)
+
+		b = spk.Update(&protocol.EncapsulatedPacket{
+			Reliability: raknet.ReliableOrdered,
+			Split:       true,
+			SplitID:     1,
+			SplitCount:  3,
+			SplitIndex:  int32(i),
+			Payload:     buf,
+		})
+	}
+
+	want := []byte{0, 0, 1, 1, 2, 2}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("reassembled %v, want %v", b, want)
+	}
+}
+
+func TestSplitReorder(t *testing.T) {
+	network := raknettest.NewNetwork(1)
+	network.Latency = 10 * time.Millisecond
+	network.Reorder = 0.5
+
+	serve(t, network)
+	client := dial(t, network)
+
+	// Parts are sent in a datagram each, and reordered on the network
+	b := make([]byte, raknet.MaxMTU*5)
+	for i := range b {
+		b[i] = byte(i / raknet.MaxMTU)
+	}
+
+	b[0] = 0x90
+
+	client.Send(b, raknet.ReliableOrdered, raknet.DefaultChannel)
+
+	network.Advance(time.Second)
+
+	pks := client.Packets()
+	if len(pks) != 1 || !bytes.Equal(pks[0], b) {
+		t.Fatal("the split packet isn't echoed")
+	}
+}
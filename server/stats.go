@@ -0,0 +1,349 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+)
+
+// NumReliabilities is the number of reliabilities counted in Stats
+const NumReliabilities = int(raknet.ReliableOrderedWithACKReceipt) + 1
+
+// Stats is a snapshot of network statistics, like RakNetStatistics of RakNet
+// Stats of the server are the total of all sessions, and its datagrams include
+// unconnected packets such as pings and handshakes.
+type Stats struct {
+
+	// BytesSent is the number of bytes of datagrams sent
+	BytesSent uint64
+
+	// BytesReceived is the number of bytes of datagrams received
+	BytesReceived uint64
+
+	// DatagramsSent is the number of datagrams sent, including ACKs and NACKs
+	DatagramsSent uint64
+
+	// DatagramsReceived is the number of datagrams received, including ACKs and NACKs
+	DatagramsReceived uint64
+
+	// MessagesSent is the number of messages sent by reliability
+	// A split message is counted once.
+	MessagesSent [NumReliabilities]uint64
+
+	// MessagesReceived is the number of messages received by reliability
+	// A split message is counted once after it's reassembled, and duplicates aren't counted.
+	MessagesReceived [NumReliabilities]uint64
+
+	// Retransmissions is the number of datagrams resent
+	Retransmissions uint64
+
+	// NACKsSent is the number of NACK packets sent
+	NACKsSent uint64
+
+	// NACKsReceived is the number of NACK packets received
+	NACKsReceived uint64
+
+	// DuplicatesDropped is the number of reliable messages received twice and dropped
+	// Ordered messages with order indexes already delivered are counted too.
+	DuplicatesDropped uint64
+
+	// SplitsReassembled is the number of split messages reassembled
+	SplitsReassembled uint64
+
+	// SendQueueDepth is the number of messages waiting to be sent
+	SendQueueDepth int
+
+	// RecoveryQueueDepth is the number of datagrams waiting for ACKs
+	RecoveryQueueDepth int
+
+	// PacketLoss is the estimated ratio of lost datagrams, between 0 and 1
+	// It's the ratio of retransmissions to sent datagrams.
+	PacketLoss float64
+
+	// RTTMin is the minimum round-trip time
+	RTTMin time.Duration
+
+	// RTTAvg is the average round-trip time
+	RTTAvg time.Duration
+
+	// RTTMax is the maximum round-trip time
+	RTTMax time.Duration
+
+	// RTTVariance is the variation of round-trip times, like RTTVAR of TCP (RFC 6298)
+	RTTVariance time.Duration
+}
+
+// netStats is network statistics counted concurrently
+// Events of a session are counted in its parent, the server's stats, too.
+// Datagrams are counted separately, as the server counts datagrams without sessions.
+type netStats struct {
+	// 64 bit fields are first to be aligned for atomic operations
+	bytesSent         uint64
+	bytesReceived     uint64
+	datagramsSent     uint64
+	datagramsReceived uint64
+	messagesSent      [NumReliabilities]uint64
+	messagesReceived  [NumReliabilities]uint64
+	retransmissions   uint64
+	nacksSent         uint64
+	nacksReceived     uint64
+	duplicates        uint64
+	reassembled       uint64
+
+	// sendQueueDepth and recoveryQueueDepth are totals of the sessions, only the server's stats have them
+	// They're counted in every update of the server.
+	sendQueueDepth     int64
+	recoveryQueueDepth int64
+
+	parent *netStats
+
+	// packets is counts by packet ID, only the server's stats have it
+	packets *packetCounts
+
+	rttMutex    sync.Mutex
+	rttCount    int64
+	rttTotal    time.Duration
+	rttMin      time.Duration
+	rttMax      time.Duration
+	rttSmoothed time.Duration
+	rttVariance time.Duration
+}
+
+// PacketCount is the number of datagrams and their bytes
+type PacketCount struct {
+	Datagrams uint64
+	Bytes     uint64
+}
+
+// packetCounts is counts of datagrams by packet ID
+type packetCounts struct {
+	received [256]PacketCount
+	sent     [256]PacketCount
+}
+
+func newNetStats(parent *netStats) *netStats {
+	return &netStats{
+		parent: parent,
+	}
+}
+
+func (stats *netStats) datagramSent(id byte, n int) {
+	atomic.AddUint64(&stats.datagramsSent, 1)
+	atomic.AddUint64(&stats.bytesSent, uint64(n))
+
+	if stats.packets != nil {
+		atomic.AddUint64(&stats.packets.sent[id].Datagrams, 1)
+		atomic.AddUint64(&stats.packets.sent[id].Bytes, uint64(n))
+	}
+}
+
+func (stats *netStats) datagramReceived(id byte, n int) {
+	atomic.AddUint64(&stats.datagramsReceived, 1)
+	atomic.AddUint64(&stats.bytesReceived, uint64(n))
+
+	if stats.packets != nil {
+		atomic.AddUint64(&stats.packets.received[id].Datagrams, 1)
+		atomic.AddUint64(&stats.packets.received[id].Bytes, uint64(n))
+	}
+}
+
+func (stats *netStats) messageSent(reliability raknet.Reliability) {
+	if reliability < 0 || int(reliability) >= NumReliabilities {
+		return
+	}
+
+	for s := stats; s != nil; s = s.parent {
+		atomic.AddUint64(&s.messagesSent[reliability], 1)
+	}
+}
+
+func (stats *netStats) messageReceived(reliability raknet.Reliability) {
+	if reliability < 0 || int(reliability) >= NumReliabilities {
+		return
+	}
+
+	for s := stats; s != nil; s = s.parent {
+		atomic.AddUint64(&s.messagesReceived[reliability], 1)
+	}
+}
+
+func (stats *netStats) retransmitted() {
+	for s := stats; s != nil; s = s.parent {
+		atomic.AddUint64(&s.retransmissions, 1)
+	}
+}
+
+func (stats *netStats) nackSent() {
+	for s := stats; s != nil; s = s.parent {
+		atomic.AddUint64(&s.nacksSent, 1)
+	}
+}
+
+func (stats *netStats) nackReceived() {
+	for s := stats; s != nil; s = s.parent {
+		atomic.AddUint64(&s.nacksReceived, 1)
+	}
+}
+
+func (stats *netStats) duplicate() {
+	for s := stats; s != nil; s = s.parent {
+		atomic.AddUint64(&s.duplicates, 1)
+	}
+}
+
+func (stats *netStats) splitReassembled() {
+	for s := stats; s != nil; s = s.parent {
+		atomic.AddUint64(&s.reassembled, 1)
+	}
+}
+
+// rtt adds a sample of round-trip time
+func (stats *netStats) rtt(rtt time.Duration) {
+	for s := stats; s != nil; s = s.parent {
+		s.addRTT(rtt)
+	}
+}
+
+func (stats *netStats) addRTT(rtt time.Duration) {
+	stats.rttMutex.Lock()
+	defer stats.rttMutex.Unlock()
+
+	if stats.rttCount == 0 {
+		stats.rttMin = rtt
+		stats.rttMax = rtt
+		stats.rttSmoothed = rtt
+		stats.rttVariance = rtt / 2
+	} else {
+		if rtt < stats.rttMin {
+			stats.rttMin = rtt
+		}
+
+		if rtt > stats.rttMax {
+			stats.rttMax = rtt
+		}
+
+		diff := stats.rttSmoothed - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+
+		stats.rttVariance = (3*stats.rttVariance + diff) / 4
+		stats.rttSmoothed = (7*stats.rttSmoothed + rtt) / 8
+	}
+
+	stats.rttCount++
+	stats.rttTotal += rtt
+}
+
+// smoothedRTT returns the smoothed round-trip time, like SRTT of TCP (RFC 6298)
+func (stats *netStats) smoothedRTT() time.Duration {
+	stats.rttMutex.Lock()
+	defer stats.rttMutex.Unlock()
+
+	return stats.rttSmoothed
+}
+
+// queueDepths sets the total queue depths of the sessions
+func (stats *netStats) queueDepths(send int, recovery int) {
+	atomic.StoreInt64(&stats.sendQueueDepth, int64(send))
+	atomic.StoreInt64(&stats.recoveryQueueDepth, int64(recovery))
+}
+
+// snapshot returns the statistics
+func (stats *netStats) snapshot() Stats {
+	st := Stats{
+		BytesSent:          atomic.LoadUint64(&stats.bytesSent),
+		BytesReceived:      atomic.LoadUint64(&stats.bytesReceived),
+		DatagramsSent:      atomic.LoadUint64(&stats.datagramsSent),
+		DatagramsReceived:  atomic.LoadUint64(&stats.datagramsReceived),
+		Retransmissions:    atomic.LoadUint64(&stats.retransmissions),
+		NACKsSent:          atomic.LoadUint64(&stats.nacksSent),
+		NACKsReceived:      atomic.LoadUint64(&stats.nacksReceived),
+		DuplicatesDropped:  atomic.LoadUint64(&stats.duplicates),
+		SplitsReassembled:  atomic.LoadUint64(&stats.reassembled),
+		SendQueueDepth:     int(atomic.LoadInt64(&stats.sendQueueDepth)),
+		RecoveryQueueDepth: int(atomic.LoadInt64(&stats.recoveryQueueDepth)),
+	}
+
+	for i := 0; i < NumReliabilities; i++ {
+		st.MessagesSent[i] = atomic.LoadUint64(&stats.messagesSent[i])
+		st.MessagesReceived[i] = atomic.LoadUint64(&stats.messagesReceived[i])
+	}
+
+	if st.DatagramsSent > 0 {
+		st.PacketLoss = float64(st.Retransmissions) / float64(st.DatagramsSent)
+		if st.PacketLoss > 1 {
+			st.PacketLoss = 1
+		}
+	}
+
+	stats.rttMutex.Lock()
+
+	if stats.rttCount > 0 {
+		st.RTTMin = stats.rttMin
+		st.RTTAvg = stats.rttTotal / time.Duration(stats.rttCount)
+		st.RTTMax = stats.rttMax
+		st.RTTVariance = stats.rttVariance
+	}
+
+	stats.rttMutex.Unlock()
+
+	return st
+}
+
+// Stats returns a snapshot of the session's network statistics
+// It can be called from any goroutine while the session is running.
+func (session *Session) Stats() Stats {
+	st := session.stats.snapshot()
+	st.SendQueueDepth = session.sendQueue.Size()
+	st.RecoveryQueueDepth = session.recoveryQueue.Len()
+
+	return st
+}
+
+// Latency returns the smoothed round-trip time to the client
+// It's 0 until a round-trip time is measured with pings.
+func (session *Session) Latency() time.Duration {
+	return session.stats.smoothedRTT()
+}
+
+// Stats returns a snapshot of the server's network statistics
+// Queue depths are the total of the sessions at the last update, so sessions aren't scanned.
+func (ser *Server) Stats() Stats {
+	// The stats are made when the server is started
+	if ser.State() == StateNew {
+		return Stats{}
+	}
+
+	return ser.stats.snapshot()
+}
+
+// PacketCounts returns the numbers of datagrams and bytes by packet ID, the first byte of datagrams
+// Datagrams of sessions have IDs of their types, such as 0x84 for a custom packet and 0xc0 for ACK.
+func (ser *Server) PacketCounts() (received [256]PacketCount, sent [256]PacketCount) {
+	if ser.State() == StateNew {
+		return received, sent
+	}
+
+	packets := ser.stats.packets
+
+	for i := 0; i < 256; i++ {
+		received[i].Datagrams = atomic.LoadUint64(&packets.received[i].Datagrams)
+		received[i].Bytes = atomic.LoadUint64(&packets.received[i].Bytes)
+		sent[i].Datagrams = atomic.LoadUint64(&packets.sent[i].Datagrams)
+		sent[i].Bytes = atomic.LoadUint64(&packets.sent[i].Bytes)
+	}
+
+	return received, sent
+}
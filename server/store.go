@@ -0,0 +1,260 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// SessionStore stores sessions of a server
+// Sessions are found by the address, the GUID and the ID assigned by the server.
+// It's used from several goroutines, so it must be safe for concurrent use.
+type SessionStore interface {
+
+	// Store stores the session with its address, GUID and ID at the time
+	// A session stored with the same address is removed.
+	Store(session *Session)
+
+	// Remove removes the session from all indexes
+	// It does nothing if the session isn't stored.
+	Remove(session *Session)
+
+	// Get returns the session with the address
+	Get(addr net.Addr) (*Session, bool)
+
+	// GetGUID returns the session with the GUID
+	GetGUID(guid int64) (*Session, bool)
+
+	// GetID returns the session with the ID
+	GetID(id uint64) (*Session, bool)
+
+	// Range calls f for each session until f returns false
+	// Sessions can be stored and removed in f.
+	Range(f func(session *Session) bool)
+
+	// Len returns the number of sessions
+	Len() int
+}
+
+// NewSessionStore returns a new SessionStore in memory
+func NewSessionStore() SessionStore {
+	return &memorySessionStore{
+		addrs: make(map[string]*Session),
+		guids: make(map[int64]*Session),
+		ids:   make(map[uint64]*storedSession),
+	}
+}
+
+// storedSession is a session with the keys it was stored with
+// Its fields can change after it's stored, such as Addr in migration.
+type storedSession struct {
+	session *Session
+	addr    string
+	guid    int64
+}
+
+// memorySessionStore is a SessionStore with maps
+type memorySessionStore struct {
+	mutex sync.RWMutex
+	addrs map[string]*Session
+	guids map[int64]*Session
+	ids   map[uint64]*storedSession
+}
+
+func (store *memorySessionStore) Store(session *Session) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.remove(session)
+
+	addr := session.Addr().String()
+
+	old, ok := store.addrs[addr]
+	if ok {
+		store.remove(old)
+	}
+
+	store.addrs[addr] = session
+	store.guids[session.GUID] = session
+	store.ids[session.ID] = &storedSession{
+		session: session,
+		addr:    addr,
+		guid:    session.GUID,
+	}
+}
+
+func (store *memorySessionStore) Remove(session *Session) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.remove(session)
+}
+
+// remove removes the session with the keys it was stored with
+// The store must be locked.
+func (store *memorySessionStore) remove(session *Session) {
+	stored, ok := store.ids[session.ID]
+	if !ok || stored.session != session {
+		return
+	}
+
+	delete(store.ids, session.ID)
+
+	if store.addrs[stored.addr] == session {
+		delete(store.addrs, stored.addr)
+	}
+
+	// Another session may have the GUID if the client connected again
+	if store.guids[stored.guid] == session {
+		delete(store.guids, stored.guid)
+	}
+}
+
+func (store *memorySessionStore) Get(addr net.Addr) (*Session, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	session, ok := store.addrs[addr.String()]
+
+	return session, ok
+}
+
+func (store *memorySessionStore) GetGUID(guid int64) (*Session, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	session, ok := store.guids[guid]
+
+	return session, ok
+}
+
+func (store *memorySessionStore) GetID(id uint64) (*Session, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	stored, ok := store.ids[id]
+	if !ok {
+		return nil, false
+	}
+
+	return stored.session, true
+}
+
+func (store *memorySessionStore) Range(f func(session *Session) bool) {
+	store.mutex.RLock()
+
+	sessions := make([]*Session, 0, len(store.ids))
+	for _, stored := range store.ids {
+		sessions = append(sessions, stored.session)
+	}
+
+	store.mutex.RUnlock()
+
+	for _, session := range sessions {
+		if !f(session) {
+			break
+		}
+	}
+}
+
+func (store *memorySessionStore) Len() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.ids)
+}
+
+// sessionCounts counts the stored sessions by ip addresses and the handshaking sessions
+// It's updated when sessions are stored, connected and removed, so new connections are
+// validated without scanning the sessions.
+type sessionCounts struct {
+	mutex sync.Mutex
+	ips   map[string]int
+
+	// handshaking is the number of sessions in the handshake, read atomically
+	handshaking int64
+}
+
+func newSessionCounts() *sessionCounts {
+	return &sessionCounts{
+		ips: make(map[string]int),
+	}
+}
+
+// add counts the session with its address at the time
+// It does nothing if the session is counted already.
+func (counts *sessionCounts) add(session *Session) {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+
+	if session.countedIP != "" {
+		return
+	}
+
+	session.countedIP = session.Addr().IP.String()
+	counts.ips[session.countedIP]++
+
+	if session.State() == StateHandshaking {
+		session.countedHandshaking = true
+		atomic.AddInt64(&counts.handshaking, 1)
+	}
+}
+
+// connected uncounts the session from the handshaking sessions
+func (counts *sessionCounts) connected(session *Session) {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+
+	counts.removeHandshaking(session)
+}
+
+// removeHandshaking uncounts the session from the handshaking sessions if it's counted
+// The counts must be locked.
+func (counts *sessionCounts) removeHandshaking(session *Session) {
+	if session.countedHandshaking {
+		session.countedHandshaking = false
+		atomic.AddInt64(&counts.handshaking, -1)
+	}
+}
+
+// remove uncounts the session with the address it was counted with
+// It does nothing if the session isn't counted.
+func (counts *sessionCounts) remove(session *Session) {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+
+	counts.removeHandshaking(session)
+
+	if session.countedIP == "" {
+		return
+	}
+
+	counts.ips[session.countedIP]--
+	if counts.ips[session.countedIP] <= 0 {
+		delete(counts.ips, session.countedIP)
+	}
+
+	session.countedIP = ""
+}
+
+// ip returns the number of sessions from the ip address
+func (counts *sessionCounts) ip(ip net.IP) int {
+	counts.mutex.Lock()
+	defer counts.mutex.Unlock()
+
+	return counts.ips[ip.String()]
+}
+
+// handshakes returns the number of sessions in the handshake
+func (counts *sessionCounts) handshakes() int {
+	return int(atomic.LoadInt64(&counts.handshaking))
+}
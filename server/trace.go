@@ -0,0 +1,264 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/beito123/go-raknet/binary"
+	"github.com/beito123/go-raknet/protocol"
+
+	raknet "github.com/beito123/go-raknet"
+)
+
+// TraceLayer is a layer of packets traced
+type TraceLayer string
+
+const (
+	// LayerDatagram is a datagram with its header, such as a custom packet, ACK and NACK
+	LayerDatagram TraceLayer = "datagram"
+
+	// LayerMessage is an encapsulated packet in a datagram
+	LayerMessage TraceLayer = "message"
+
+	// LayerReassembly is a payload reassembled from split packets
+	LayerReassembly TraceLayer = "reassembly"
+
+	// LayerDelivery is a decision whether a message is delivered to handlers
+	LayerDelivery TraceLayer = "delivery"
+)
+
+// TraceDirection is a direction of packets traced
+type TraceDirection string
+
+const (
+	TraceIn  TraceDirection = "in"
+	TraceOut TraceDirection = "out"
+)
+
+// Delivery is a decision for a received message
+type Delivery string
+
+const (
+	// Delivered is a message delivered to handlers
+	Delivered Delivery = "delivered"
+
+	// DeliveryBuffered is an ordered message waiting for earlier messages
+	// It's traced again as delivered when they're received.
+	DeliveryBuffered Delivery = "buffered"
+
+	// DeliverySplit is a part of a split packet waiting for other parts
+	DeliverySplit Delivery = "split"
+
+	// DeliveryDuplicate is a reliable message dropped as it was already received,
+	// or an ordered message dropped as its order index was already delivered
+	DeliveryDuplicate Delivery = "duplicate"
+
+	// DeliverySequencedDropped is a sequenced message dropped as a newer one was received
+	DeliverySequencedDropped Delivery = "sequenced-dropped"
+
+	// DeliveryRejected is a message dropped as it couldn't be kept, such as when the split queue is full
+	DeliveryRejected Delivery = "rejected"
+
+	// DeliveryInvalidChannel is a message dropped as its channel is invalid
+	DeliveryInvalidChannel Delivery = "invalid-channel"
+)
+
+// Tracer is notified of packets of sessions at each layer
+// It's called in goroutines handling packets, so it should return quickly.
+// Payloads in events are only valid until it returns.
+type Tracer interface {
+	Trace(event *TraceEvent)
+}
+
+// TraceEvent is a packet traced at a layer
+type TraceEvent struct {
+	Time      time.Time      `json:"time"`
+	Layer     TraceLayer     `json:"layer"`
+	Direction TraceDirection `json:"dir"`
+	Session   uint64         `json:"session"`
+	GUID      int64          `json:"guid"`
+	Addr      string         `json:"addr"`
+
+	// Datagram is the datagram header in LayerDatagram
+	Datagram *DatagramTrace `json:"datagram,omitempty"`
+
+	// Message is the message in LayerMessage, LayerReassembly and LayerDelivery
+	Message *MessageTrace `json:"message,omitempty"`
+
+	// Delivery is the decision in LayerDelivery
+	Delivery Delivery `json:"delivery,omitempty"`
+}
+
+// DatagramTrace is the header of a datagram
+type DatagramTrace struct {
+
+	// Flags is the first byte of the datagram, such as 0x84, protocol.IDACK and protocol.IDNACK
+	Flags byte `json:"flags"`
+
+	// Sequence is the sequence number of a custom packet
+	Sequence int `json:"seq"`
+
+	// Size is the size of the datagram in bytes
+	Size int `json:"size"`
+
+	// Messages is the number of messages in a custom packet
+	Messages int `json:"messages"`
+
+	// Records is the acknowledged sequence numbers of ACK and NACK
+	Records []*raknet.Record `json:"records,omitempty"`
+}
+
+// MessageTrace is an encapsulated packet
+type MessageTrace struct {
+	Reliability  string `json:"reliability"`
+	MessageIndex int    `json:"messageIndex"`
+	OrderIndex   int    `json:"orderIndex"`
+	OrderChannel int    `json:"orderChannel"`
+
+	// Split is the split info if the message is a part of a split packet
+	Split *SplitTrace `json:"split,omitempty"`
+
+	// Size is the size of the payload in bytes
+	Size int `json:"size"`
+
+	// Payload is the payload of the message
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// SplitTrace is the split info of a message
+type SplitTrace struct {
+	ID    int `json:"id"`
+	Count int `json:"count"`
+	Index int `json:"index"`
+}
+
+func newMessageTrace(epk *protocol.EncapsulatedPacket) *MessageTrace {
+	msg := &MessageTrace{
+		Reliability:  epk.Reliability.String(),
+		MessageIndex: int(epk.MessageIndex),
+		OrderIndex:   int(epk.OrderIndex),
+		OrderChannel: int(epk.OrderChannel),
+		Size:         len(epk.Payload),
+		Payload:      epk.Payload,
+	}
+
+	if epk.Split {
+		msg.Split = &SplitTrace{
+			ID:    int(epk.SplitID),
+			Count: int(epk.SplitCount),
+			Index: int(epk.SplitIndex),
+		}
+	}
+
+	return msg
+}
+
+// NewJSONTracer returns a Tracer writing events to w as JSON lines
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// JSONTracer is a Tracer writing events as JSON lines
+// It's safe for concurrent use. Errors writing events are ignored.
+type JSONTracer struct {
+
+	// Payloads writes payloads of messages in base64
+	// if it's false, only the size of payloads is written.
+	Payloads bool
+
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func (tracer *JSONTracer) Trace(event *TraceEvent) {
+	if !tracer.Payloads && event.Message != nil && event.Message.Payload != nil {
+		msg := *event.Message
+		msg.Payload = nil
+
+		ev := *event
+		ev.Message = &msg
+
+		event = &ev
+	}
+
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	tracer.encoder.Encode(event)
+}
+
+// trace sends the event of the session to the server's tracer
+// Callers check the tracer first not to make events without it.
+func (session *Session) trace(layer TraceLayer, dir TraceDirection, event *TraceEvent) {
+	event.Time = session.Server.now()
+	event.Layer = layer
+	event.Direction = dir
+	event.Session = session.ID
+	event.GUID = session.GUID
+	event.Addr = session.Addr().String()
+
+	session.Server.Tracer.Trace(event)
+}
+
+func (session *Session) traceDatagram(dir TraceDirection, flags byte, size int, index binary.Triad, epks []*protocol.EncapsulatedPacket) {
+	if session.Server.Tracer == nil {
+		return
+	}
+
+	session.trace(LayerDatagram, dir, &TraceEvent{
+		Datagram: &DatagramTrace{
+			Flags:    flags,
+			Sequence: int(index),
+			Size:     size,
+			Messages: len(epks),
+		},
+	})
+}
+
+func (session *Session) traceACK(dir TraceDirection, flags byte, size int, records []*raknet.Record) {
+	if session.Server.Tracer == nil {
+		return
+	}
+
+	session.trace(LayerDatagram, dir, &TraceEvent{
+		Datagram: &DatagramTrace{
+			Flags:   flags,
+			Size:    size,
+			Records: records,
+		},
+	})
+}
+
+func (session *Session) traceMessage(layer TraceLayer, dir TraceDirection, epk *protocol.EncapsulatedPacket) {
+	if session.Server.Tracer == nil {
+		return
+	}
+
+	session.trace(layer, dir, &TraceEvent{
+		Message: newMessageTrace(epk),
+	})
+}
+
+func (session *Session) traceDelivery(epk *protocol.EncapsulatedPacket, delivery Delivery) {
+	if session.Server.Tracer == nil {
+		return
+	}
+
+	session.trace(LayerDelivery, TraceIn, &TraceEvent{
+		Message:  newMessageTrace(epk),
+		Delivery: delivery,
+	})
+}
@@ -10,7 +10,11 @@ package server
  */
 
 import (
+	"errors"
 	"math"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/beito123/go-raknet/binary"
@@ -29,6 +33,26 @@ func (exp *Expire) IsPermanent() bool {
 	return exp.Duration < 0
 }
 
+// IsExpired returns whether the duration passed from the time at now
+func (exp *Expire) IsExpired(now time.Time) bool {
+	return !exp.IsPermanent() && now.Sub(exp.Time) >= exp.Duration
+}
+
+// BlockedAddress is an ip address blocked by the server
+type BlockedAddress struct {
+	IP     net.IP
+	Expire *Expire
+
+	// Reason is the reason passed to AddBlockedAddress
+	Reason string
+}
+
+// expired returns whether the ban expired at now
+// A ban without Expire is permanent.
+func (addr *BlockedAddress) expired(now time.Time) bool {
+	return addr.Expire != nil && addr.Expire.IsExpired(now)
+}
+
 func BumpTriad(id *binary.Triad) (result binary.Triad) {
 	result = *id
 	*id = id.Bump()
@@ -40,3 +64,83 @@ func BumpUInt16(id *uint16) (result uint16) {
 	*id = (*id % math.MaxUint16) + 1
 	return result
 }
+
+// udpAddr returns addr as UDPAddr
+// Addresses of sessions are converted once when the sessions are created,
+// others are parsed from the string without resolving names.
+func (ser *Server) udpAddr(addr net.Addr) (*net.UDPAddr, error) {
+	uaddr, ok := addr.(*net.UDPAddr)
+	if ok {
+		return uaddr, nil
+	}
+
+	session, ok := ser.restoreSession(addr)
+	if ok {
+		return session.Addr(), nil
+	}
+
+	return parseUDPAddr(addr.String())
+}
+
+// parseUDPAddr parses an address formatted as "host:port", such as "[fe80::1%eth0]:19132"
+func parseUDPAddr(address string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var zone string
+
+	i := strings.LastIndexByte(host, '%')
+	if i >= 0 {
+		host, zone = host[:i], host[i+1:]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.New("invalid ip address: " + host)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.UDPAddr{
+		IP:   ip,
+		Port: int(p),
+		Zone: zone,
+	}, nil
+}
+
+// hashAddr returns a hash of the address with FNV-1a
+// IPv4 addresses in the 16 bytes form give the same hash as the 4 bytes form.
+// Addresses other than UDPAddr are hashed from the string.
+func hashAddr(addr net.Addr) uint32 {
+	h := uint32(2166136261)
+
+	uaddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		for _, b := range []byte(addr.String()) {
+			h ^= uint32(b)
+			h *= 16777619
+		}
+
+		return h
+	}
+
+	ip := uaddr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, b := range ip {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+
+	h ^= uint32(uaddr.Port)
+	h *= 16777619
+
+	return h
+}
@@ -0,0 +1,227 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	raknet "github.com/beito123/go-raknet"
+)
+
+// ErrWriteQueueFull is reported when a datagram is dropped because the write queue is full
+var ErrWriteQueueFull = errors.New("write queue is full")
+
+// WriteErrorFunc is called when the server failed to write a datagram to the address
+// It's called from writer goroutines, so it shouldn't block for long.
+type WriteErrorFunc func(addr net.Addr, err error)
+
+// datagram is a datagram waiting to be written
+// buf is a buffer from the server's pool, and put back after it's written.
+type datagram struct {
+	buf  *[]byte
+	addr net.Addr
+}
+
+// batchWriter writes several datagrams at once
+type batchWriter interface {
+
+	// WriteBatch writes datagrams from the head of dgs, and returns the number of written datagrams
+	// if err isn't nil, dgs[n] failed to be written.
+	WriteBatch(dgs []datagram) (n int, err error)
+}
+
+// writer writes datagrams queued for a connection
+// Each address is assigned to one of its goroutines, so datagrams
+// to the same address are written in the order they were queued.
+type writer struct {
+	ser    *Server
+	conn   net.PacketConn
+	queues []chan datagram
+	closed chan struct{}
+	wg     sync.WaitGroup
+
+	// mutex is a mutex for closing, so datagrams aren't queued after the goroutines stopped
+	mutex sync.RWMutex
+}
+
+func newWriter(ser *Server, conn net.PacketConn) *writer {
+	w := &writer{
+		ser:    ser,
+		conn:   conn,
+		queues: make([]chan datagram, ser.Writers),
+		closed: make(chan struct{}),
+	}
+
+	for i := range w.queues {
+		w.queues[i] = make(chan datagram, ser.WriteQueueSize)
+
+		var batch batchWriter
+		if ser.BatchWrites {
+			batch = newBatchWriter(conn)
+		}
+
+		w.wg.Add(1)
+		go w.run(w.queues[i], batch)
+	}
+
+	return w
+}
+
+// queue returns the queue of the goroutine writing to the address
+func (w *writer) queue(addr net.Addr) chan datagram {
+	if len(w.queues) == 1 {
+		return w.queues[0]
+	}
+
+	return w.queues[hashAddr(addr)%uint32(len(w.queues))]
+}
+
+// Write queues buf to be written to the address, and takes the ownership of buf
+// It returns false if the datagram is dropped because the queue is full or the writer is closed.
+func (w *writer) Write(addr net.Addr, buf *[]byte) bool {
+	w.mutex.RLock()
+
+	select {
+	case <-w.closed:
+		w.mutex.RUnlock()
+
+		w.ser.buffers.Put(buf)
+		return false
+	default:
+	}
+
+	atomic.AddInt64(&w.ser.pending, 1)
+
+	select {
+	case w.queue(addr) <- datagram{buf: buf, addr: addr}:
+		w.mutex.RUnlock()
+		return true
+	default:
+	}
+
+	w.mutex.RUnlock()
+
+	atomic.AddInt64(&w.ser.pending, -1)
+
+	w.ser.buffers.Put(buf)
+	w.ser.writeError(addr, ErrWriteQueueFull)
+
+	return false
+}
+
+// Writable returns whether sessions can send more datagrams to the address
+// A quarter of the queue is left for ACKs and replies to unconnected packets.
+func (w *writer) Writable(addr net.Addr) bool {
+	queue := w.queue(addr)
+
+	return len(queue) < cap(queue)-cap(queue)/4
+}
+
+// Close stops the writer after writing the queued datagrams
+func (w *writer) Close() {
+	w.mutex.Lock()
+	close(w.closed)
+	w.mutex.Unlock()
+
+	w.wg.Wait()
+}
+
+func (w *writer) run(queue chan datagram, batch batchWriter) {
+	defer w.wg.Done()
+
+	var dgs []datagram
+	if batch != nil {
+		dgs = make([]datagram, 0, raknet.MaxWriteBatch)
+	}
+
+	for {
+		select {
+		case dg := <-queue:
+			if batch == nil {
+				w.write(dg)
+				continue
+			}
+
+			dgs = append(dgs[:0], dg)
+
+		drain:
+			for len(dgs) < cap(dgs) {
+				select {
+				case dg := <-queue:
+					dgs = append(dgs, dg)
+				default:
+					break drain
+				}
+			}
+
+			w.writeBatch(batch, dgs)
+
+			for i := range dgs {
+				dgs[i] = datagram{}
+			}
+		case <-w.closed:
+			// Datagrams queued before closing, such as disconnection notifications, are still sent
+			for {
+				select {
+				case dg := <-queue:
+					w.write(dg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *writer) write(dg datagram) {
+	_, err := w.conn.WriteTo(*dg.buf, dg.addr)
+	if err != nil {
+		w.ser.writeError(dg.addr, err)
+	}
+
+	w.ser.buffers.Put(dg.buf)
+
+	atomic.AddInt64(&w.ser.pending, -1)
+}
+
+func (w *writer) writeBatch(batch batchWriter, dgs []datagram) {
+	for rest := dgs; len(rest) > 0; {
+		n, err := batch.WriteBatch(rest)
+		if n < 0 {
+			n = 0
+		}
+
+		if err != nil && n < len(rest) {
+			w.ser.writeError(rest[n].addr, err)
+			n++
+		} else if n <= 0 {
+			// Nothing was written, so they're written one by one
+			for _, dg := range rest {
+				_, err := w.conn.WriteTo(*dg.buf, dg.addr)
+				if err != nil {
+					w.ser.writeError(dg.addr, err)
+				}
+			}
+
+			break
+		}
+
+		rest = rest[n:]
+	}
+
+	for _, dg := range dgs {
+		w.ser.buffers.Put(dg.buf)
+	}
+
+	atomic.AddInt64(&w.ser.pending, -int64(len(dgs)))
+}
@@ -0,0 +1,97 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"net"
+
+	raknet "github.com/beito123/go-raknet"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// mmsgWriter writes datagrams with sendmmsg
+type mmsgWriter struct {
+	conn  *net.UDPConn
+	ipv4  bool
+	write func(ms []ipv4.Message, flags int) (int, error)
+	msgs  []ipv4.Message
+}
+
+// newBatchWriter returns a writer with sendmmsg for *net.UDPConn, or nil for others
+func newBatchWriter(conn net.PacketConn) batchWriter {
+	c, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+
+	laddr, ok := c.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+
+	w := &mmsgWriter{
+		conn: c,
+		msgs: make([]ipv4.Message, raknet.MaxWriteBatch),
+	}
+
+	for i := range w.msgs {
+		w.msgs[i].Buffers = make([][]byte, 1)
+	}
+
+	// ipv4.Message and ipv6.Message are the same type
+	if laddr.IP.To4() != nil {
+		w.ipv4 = true
+		w.write = ipv4.NewPacketConn(c).WriteBatch
+	} else {
+		w.write = ipv6.NewPacketConn(c).WriteBatch
+	}
+
+	return w
+}
+
+// batchable returns whether the address is UDPAddr of the same ip version as the socket
+// IPv4 addresses on a dual-stack socket are written by the standard library.
+func (w *mmsgWriter) batchable(addr net.Addr) bool {
+	uaddr, ok := addr.(*net.UDPAddr)
+
+	return ok && (uaddr.IP.To4() != nil) == w.ipv4
+}
+
+func (w *mmsgWriter) WriteBatch(dgs []datagram) (int, error) {
+	if !w.batchable(dgs[0].addr) {
+		_, err := w.conn.WriteTo(*dgs[0].buf, dgs[0].addr)
+		if err != nil {
+			return 0, err
+		}
+
+		return 1, nil
+	}
+
+	n := 0
+	for _, dg := range dgs {
+		if n >= len(w.msgs) || !w.batchable(dg.addr) {
+			break
+		}
+
+		w.msgs[n].Buffers[0] = *dg.buf
+		w.msgs[n].Addr = dg.addr
+		n++
+	}
+
+	written, err := w.write(w.msgs[:n], 0)
+
+	for i := 0; i < n; i++ {
+		w.msgs[i].Buffers[0] = nil
+		w.msgs[i].Addr = nil
+	}
+
+	return written, err
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import "net"
+
+// newBatchWriter returns nil, sendmmsg is only supported on Linux
+func newBatchWriter(conn net.PacketConn) batchWriter {
+	return nil
+}
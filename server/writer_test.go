@@ -0,0 +1,84 @@
+package server
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+)
+
+func TestWriteClose(t *testing.T) {
+	session := newTestSession()
+	ser := session.Server
+
+	for i := 0; i < 100; i++ {
+		w := newWriter(ser, discardConn{})
+
+		// Datagrams are written or refused while the writer is closed, and none is left in the queue
+		var started, wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			started.Add(1)
+			wg.Add(1)
+
+			go func(j int) {
+				defer wg.Done()
+
+				addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(j+2)), Port: 5000}
+				for k := 0; k < 100; k++ {
+					w.Write(addr, ser.buffers.Get())
+
+					if k == 0 {
+						started.Done()
+					}
+				}
+			}(j)
+		}
+
+		started.Wait()
+
+		w.Close()
+		wg.Wait()
+
+		if pending := atomic.LoadInt64(&ser.pending); pending != 0 {
+			t.Fatalf("%d datagrams are left in the queue after closing", pending)
+		}
+	}
+}
+
+func TestSendHandlerSend(t *testing.T) {
+	session := newTestSession()
+
+	// The handler replies to the first datagram sent, while the session sends packets
+	var sent int32
+	session.Server.Handlers = Handlers{&HandlerFuncs{
+		HandleSendPacketFunc: func(addr net.Addr, pk raknet.Packet) {
+			if atomic.AddInt32(&sent, 1) == 1 {
+				session.Send([]byte{0x90}, raknet.Unreliable, raknet.DefaultChannel)
+			}
+		},
+	}}
+
+	done := make(chan struct{})
+	go func() {
+		session.SendCustomPacket(testMessages(), false)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the session is locked while a handler sends a packet")
+	}
+}
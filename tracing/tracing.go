@@ -0,0 +1,219 @@
+// Package tracing records OpenTelemetry spans of the connection lifecycle of a Raknet server
+//
+// An Observer is set to a server before the server is started:
+//
+//	ser.Observer = tracing.New(otel.GetTracerProvider())
+//
+// Each client has a "raknet.session" span from the handshake until the session is closed,
+// with a "raknet.handshake" span until NewIncomingConnection and "raknet.delivery" spans
+// of slow deliveries as its children. Handlers start spans under the session's span
+// with its context:
+//
+//	session, _ := ser.GetSessionGUID(uid)
+//	ctx, span := tracer.Start(session.Context(), "handle")
+package tracing
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	raknet "github.com/beito123/go-raknet"
+	"github.com/beito123/go-raknet/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope name of the tracer
+const ScopeName = "github.com/beito123/go-raknet/tracing"
+
+// Attribute keys of spans
+const (
+	GUIDKey              = attribute.Key("raknet.guid")
+	SessionIDKey         = attribute.Key("raknet.session.id")
+	MTUKey               = attribute.Key("raknet.mtu")
+	ProtocolVersionKey   = attribute.Key("raknet.protocol_version")
+	DisconnectReasonKey  = attribute.Key("raknet.disconnect.reason")
+	DisconnectMessageKey = attribute.Key("raknet.disconnect.message")
+	PacketIDKey          = attribute.Key("raknet.packet_id")
+	PeerAddressKey       = attribute.Key("network.peer.address")
+	PeerPortKey          = attribute.Key("network.peer.port")
+)
+
+// DefaultSlowDelivery is the default time handlers take to handle a message to record a span
+var DefaultSlowDelivery = 50 * time.Millisecond
+
+// New returns an Observer recording spans with the tracer provider
+// if tp is nil, the global tracer provider is used.
+func New(tp trace.TracerProvider) *Observer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return &Observer{
+		tracer: tp.Tracer(ScopeName),
+	}
+}
+
+// Observer is a server.Observer recording spans
+type Observer struct {
+
+	// SlowDelivery is the minimum time handlers take to handle a message to record a span
+	// if it's 0 or less, DefaultSlowDelivery is used.
+	SlowDelivery time.Duration
+
+	tracer trace.Tracer
+}
+
+// spans is spans of a client stored in the context
+type spans struct {
+	mutex     sync.Mutex
+	session   trace.Span
+	handshake trace.Span
+}
+
+type spansKey struct{}
+
+func spansFromContext(ctx context.Context) (*spans, bool) {
+	sp, ok := ctx.Value(spansKey{}).(*spans)
+	return sp, ok
+}
+
+func (o *Observer) Handshake(ctx context.Context, hs *server.Handshake) context.Context {
+	sp, ok := spansFromContext(ctx)
+	if !ok {
+		sp = &spans{}
+
+		ctx, sp.session = o.tracer.Start(ctx, "raknet.session",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(peerAttributes(hs.Addr)...))
+
+		_, sp.handshake = o.tracer.Start(ctx, "raknet.handshake",
+			trace.WithAttributes(peerAttributes(hs.Addr)...))
+
+		ctx = context.WithValue(ctx, spansKey{}, sp)
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if sp.handshake == nil {
+		return ctx
+	}
+
+	attrs := []attribute.KeyValue{
+		MTUKey.Int(hs.MTU),
+		ProtocolVersionKey.Int(hs.ProtocolVersion),
+	}
+
+	if hs.Step != server.StepOpenConnectionRequestOne {
+		attrs = append(attrs, GUIDKey.Int64(hs.GUID))
+	}
+
+	sp.session.SetAttributes(attrs...)
+	sp.handshake.SetAttributes(attrs...)
+	sp.handshake.AddEvent(hs.Step.String(), trace.WithAttributes(MTUKey.Int(hs.MTU)))
+
+	if hs.Step == server.StepNewIncomingConnection {
+		sp.handshake.End()
+		sp.handshake = nil
+	}
+
+	return ctx
+}
+
+func (o *Observer) HandshakeAbandoned(ctx context.Context) {
+	sp, ok := spansFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	sp.endHandshake("Handshake abandoned")
+
+	sp.session.SetStatus(codes.Error, "Handshake abandoned")
+	sp.session.End()
+}
+
+func (o *Observer) Closed(ctx context.Context, session *server.Session, reason server.DisconnectReason, message string) {
+	sp, ok := spansFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	sp.endHandshake("Closed in the handshake")
+
+	sp.session.SetAttributes(
+		SessionIDKey.Int64(int64(session.ID)),
+		DisconnectReasonKey.String(reason.String()),
+	)
+
+	if len(message) > 0 {
+		sp.session.SetAttributes(DisconnectMessageKey.String(message))
+	}
+
+	if reason == server.DisconnectTimeout || reason == server.DisconnectProtocolError {
+		sp.session.SetStatus(codes.Error, reason.String())
+	}
+
+	sp.session.End()
+}
+
+func (o *Observer) Delivered(ctx context.Context, session *server.Session, pk raknet.Packet, start time.Time, d time.Duration) {
+	slow := o.SlowDelivery
+	if slow <= 0 {
+		slow = DefaultSlowDelivery
+	}
+
+	if d < slow {
+		return
+	}
+
+	_, span := o.tracer.Start(ctx, "raknet.delivery",
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			GUIDKey.Int64(session.GUID),
+			PacketIDKey.Int(int(pk.ID())),
+		))
+
+	span.End(trace.WithTimestamp(start.Add(d)))
+}
+
+// endHandshake ends the handshake span with an error if it's not ended
+func (sp *spans) endHandshake(description string) {
+	if sp.handshake == nil {
+		return
+	}
+
+	sp.handshake.SetStatus(codes.Error, description)
+	sp.handshake.End()
+	sp.handshake = nil
+}
+
+func peerAttributes(addr *net.UDPAddr) []attribute.KeyValue {
+	if addr == nil {
+		return nil
+	}
+
+	return []attribute.KeyValue{
+		PeerAddressKey.String(addr.IP.String()),
+		PeerPortKey.Int(addr.Port),
+	}
+}
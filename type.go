@@ -73,15 +73,27 @@ func NewSystemAddressBytes(addr []byte, port uint16) *SystemAddress {
 	}
 }
 
+// NewSystemAddressUDP returns a new SystemAddress from UDPAddr
+func NewSystemAddressUDP(addr *net.UDPAddr) *SystemAddress {
+	return &SystemAddress{
+		IP:   addr.IP.To16(),
+		Port: uint16(addr.Port),
+		Zone: addr.Zone,
+	}
+}
+
 // SystemAddress is internal address for Raknet
 type SystemAddress struct {
 	IP   net.IP
 	Port uint16
+
+	// Zone is the IPv6 scoped addressing zone, an interface name or a numeric scope id
+	Zone string
 }
 
 // SetLoopback sets loopback address
 func (addr *SystemAddress) SetLoopback() {
-	if len(addr.IP) == net.IPv4len {
+	if addr.Version() == 4 {
 		addr.IP = net.ParseIP("127.0.0.1")
 	} else {
 		addr.IP = net.IPv6loopback // "::1"
@@ -94,24 +106,72 @@ func (addr *SystemAddress) IsLoopback() bool {
 }
 
 // Version returns the ip address version (4 or 6)
+// IPv4-mapped IPv6 addresses are version 4.
 func (addr *SystemAddress) Version() int {
-	if len(addr.IP) == net.IPv6len {
+	if addr.IP.To4() == nil && len(addr.IP) == net.IPv6len {
 		return 6
 	}
 
 	return 4
 }
 
+// ScopeID returns the IPv6 scope id of Zone
+// returns 0 if Zone is empty or unknown.
+// A numeric Zone is returned as it is, only an interface name is looked up.
+func (addr *SystemAddress) ScopeID() uint32 {
+	if addr.Zone == "" {
+		return 0
+	}
+
+	id, err := strconv.ParseUint(addr.Zone, 10, 32)
+	if err == nil {
+		return uint32(id)
+	}
+
+	ifi, err := net.InterfaceByName(addr.Zone)
+	if err != nil {
+		return 0
+	}
+
+	return uint32(ifi.Index)
+}
+
+// SetScopeID sets Zone from the IPv6 scope id
+// Zone is set to the numeric id, it's not looked up the interface name,
+// as it's called for every decoded address. net accepts numeric zones.
+func (addr *SystemAddress) SetScopeID(id uint32) {
+	if id == 0 {
+		addr.Zone = ""
+		return
+	}
+
+	addr.Zone = strconv.FormatUint(uint64(id), 10)
+}
+
+// UDPAddr returns the address as UDPAddr
+func (addr *SystemAddress) UDPAddr() *net.UDPAddr {
+	return &net.UDPAddr{
+		IP:   addr.IP,
+		Port: int(addr.Port),
+		Zone: addr.Zone,
+	}
+}
+
 // Equal returns whether sub is the same address
 func (addr *SystemAddress) Equal(sub *SystemAddress) bool {
-	return addr.IP.Equal(sub.IP) && addr.Port == sub.Port
+	return addr.IP.Equal(sub.IP) && addr.Port == sub.Port && addr.Zone == sub.Zone
 }
 
 // String returns as string
-// Format: 192.168.11.1:8080, [fc00::]:8080
+// Format: 192.168.11.1:8080, [fc00::]:8080, [fe80::1%eth0]:8080
 func (addr *SystemAddress) String() string {
-	if len(addr.IP) == net.IPv6len {
-		return "[" + addr.IP.String() + "]:" + strconv.Itoa(int(addr.Port))
+	if addr.Version() == 6 {
+		host := addr.IP.String()
+		if addr.Zone != "" {
+			host += "%" + addr.Zone
+		}
+
+		return "[" + host + "]:" + strconv.Itoa(int(addr.Port))
 	}
 
 	return addr.IP.String() + ":" + strconv.Itoa(int(addr.Port))
@@ -140,19 +200,47 @@ const (
 	ReliableOrderedWithACKReceipt
 )
 
+// String returns the name of the reliability
+func (r Reliability) String() string {
+	switch r {
+	case Unreliable:
+		return "Unreliable"
+	case UnreliableSequenced:
+		return "UnreliableSequenced"
+	case Reliable:
+		return "Reliable"
+	case ReliableOrdered:
+		return "ReliableOrdered"
+	case ReliableSequenced:
+		return "ReliableSequenced"
+	case UnreliableWithACKReceipt:
+		return "UnreliableWithACKReceipt"
+	case ReliableWithACKReceipt:
+		return "ReliableWithACKReceipt"
+	case ReliableOrderedWithACKReceipt:
+		return "ReliableOrderedWithACKReceipt"
+	}
+
+	return "Unknown"
+}
+
 // IsReliable returns whether reliability has reliable
 func (r Reliability) IsReliable() bool {
-	return (r & 0x04) > 0
+	return r == Reliable ||
+		r == ReliableOrdered ||
+		r == ReliableSequenced ||
+		r == ReliableWithACKReceipt ||
+		r == ReliableOrderedWithACKReceipt
 }
 
 // IsOrdered returns whether reliability has ordered
 func (r Reliability) IsOrdered() bool {
-	return (r & 0x02) > 0
+	return r == ReliableOrdered || r == ReliableOrderedWithACKReceipt
 }
 
 // IsSequenced returns whether reliability has sequenced
 func (r Reliability) IsSequenced() bool {
-	return (r & 0x01) > 0
+	return r == UnreliableSequenced || r == ReliableSequenced
 }
 
 // IsNeededACK returns whether reliability need ack
@@ -163,41 +251,14 @@ func (r Reliability) IsNeededACK() bool {
 }
 
 // ToBinary encode reliability to bytes
+// Raknet sends the reliability as 3 bits of its value.
 func (r Reliability) ToBinary() byte {
-	var b byte
-
-	if r.IsReliable() {
-		b |= 1 << 2
-	}
-
-	if r.IsOrdered() {
-		b |= 1 << 1
-	}
-
-	if r.IsSequenced() {
-		b |= 1
-	}
-
-	return b
+	return byte(r) & 0x07
 }
 
 // ReliabilityBinary returns Reliability from binary
 func ReliabilityBinary(b byte) Reliability {
-	if (b & 0x04) > 0 { // Reliable
-		if (b & 0x02) > 0 { // Ordered
-			return ReliableOrdered
-		} else if (b & 0x01) > 0 { // Sequenced
-			return ReliableSequenced
-		} else {
-			return Reliable
-		}
-	} else { // Unreliable
-		if (b & 0x01) > 0 { // Sequenced
-			return UnreliableSequenced
-		}
-	}
-
-	return Unreliable
+	return Reliability(b & 0x07)
 }
 
 /*
@@ -276,7 +337,7 @@ func (lat *Latency) AddRaw(raw time.Duration) {
 	} else {
 		if raw < lat.LowestLatency {
 			lat.LowestLatency = raw
-		} else if raw < lat.HighestLatency {
+		} else if raw > lat.HighestLatency {
 			lat.HighestLatency = raw
 		}
 	}
@@ -0,0 +1,44 @@
+package raknet
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import "testing"
+
+func TestReliability(t *testing.T) {
+	tests := []struct {
+		r         Reliability
+		reliable  bool
+		ordered   bool
+		sequenced bool
+	}{
+		{Unreliable, false, false, false},
+		{UnreliableSequenced, false, false, true},
+		{Reliable, true, false, false},
+		{ReliableOrdered, true, true, false},
+		{ReliableSequenced, true, false, true},
+		{UnreliableWithACKReceipt, false, false, false},
+		{ReliableWithACKReceipt, true, false, false},
+		{ReliableOrderedWithACKReceipt, true, true, false},
+	}
+
+	for _, test := range tests {
+		if test.r.IsReliable() != test.reliable || test.r.IsOrdered() != test.ordered ||
+			test.r.IsSequenced() != test.sequenced {
+			t.Fatalf("reliability %d is reliable %t, ordered %t and sequenced %t",
+				test.r, test.r.IsReliable(), test.r.IsOrdered(), test.r.IsSequenced())
+		}
+
+		// Raknet sends the value of the reliability
+		b := test.r.ToBinary()
+		if b != byte(test.r) || ReliabilityBinary(b) != test.r {
+			t.Fatalf("reliability %d is encoded to %d and decoded to %d", test.r, b, ReliabilityBinary(b))
+		}
+	}
+}
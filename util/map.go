@@ -110,23 +110,29 @@ func (m *OrderedMap) Remove(key interface{}) {
 }
 
 func (m *OrderedMap) Pop() (val interface{}, ok bool) {
+	m.Lock()
+	defer m.Unlock()
+
 	key, val, ok := m.Map.GetLast()
 	if !ok {
 		return nil, false
 	}
 
-	m.Remove(key)
+	m.Map.Delete(key)
 
 	return val, ok
 }
 
 func (m *OrderedMap) Shift() (val interface{}, ok bool) {
+	m.Lock()
+	defer m.Unlock()
+
 	key, val, ok := m.Map.GetFirst()
 	if !ok {
 		return nil, false
 	}
 
-	m.Remove(key)
+	m.Map.Delete(key)
 
 	return val, ok
 }
@@ -170,13 +176,18 @@ func NewQueue() *Queue {
 type Queue struct {
 	Map *OrderedMap
 
-	off int
+	// mutex is a mutex for off, so values added at once are kept in order
+	mutex sync.Mutex
+	off   int
 }
 
 func (q *Queue) Clear() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
 	q.off = 0
 
-	q.Clear()
+	q.Map.Clear()
 }
 
 func (q *Queue) IsEmpty() bool {
@@ -187,8 +198,9 @@ func (q *Queue) Size() int {
 	return q.Map.Len()
 }
 
+// Remove removes the head of the queue
 func (q *Queue) Remove() {
-	key, ok := q.Map.LastKey()
+	key, ok := q.Map.FirstKey()
 	if !ok {
 		return
 	}
@@ -197,19 +209,26 @@ func (q *Queue) Remove() {
 }
 
 func (q *Queue) bump() int {
-	return (q.off % math.MaxInt32) + 1
+	q.off = (q.off % math.MaxInt32) + 1
+	return q.off
 }
 
+// Add adds the value to the tail of the queue
 func (q *Queue) Add(val interface{}) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
 	q.Map.Set(q.bump(), val)
 }
 
+// Peek returns the head of the queue
 func (q *Queue) Peek() (interface{}, bool) {
-	return q.Map.Last()
+	return q.Map.First()
 }
 
+// Poll returns the head of the queue and removes it
 func (q *Queue) Poll() (interface{}, bool) {
-	return q.Map.Pop()
+	return q.Map.Shift()
 }
 
 func (q *Queue) Range(f func(val interface{}) bool) {
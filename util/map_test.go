@@ -0,0 +1,163 @@
+package util
+
+/*
+ * go-raknet
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+ */
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	q := NewQueue()
+	for i := 0; i < 5; i++ {
+		q.Add(i)
+	}
+
+	if q.Size() != 5 {
+		t.Fatalf("size is %d, want 5", q.Size())
+	}
+
+	head, ok := q.Peek()
+	if !ok || head != 0 {
+		t.Fatalf("peeked %v, want 0", head)
+	}
+
+	q.Remove()
+
+	for i := 1; i < 5; i++ {
+		val, ok := q.Poll()
+		if !ok || val != i {
+			t.Fatalf("polled %v, want %d", val, i)
+		}
+	}
+
+	if !q.IsEmpty() {
+		t.Fatal("the queue isn't empty")
+	}
+}
+
+func TestOrderedMapShift(t *testing.T) {
+	testOrderedMapTake(t, (*OrderedMap).Shift)
+}
+
+func TestOrderedMapPop(t *testing.T) {
+	testOrderedMapTake(t, (*OrderedMap).Pop)
+}
+
+// testOrderedMapTake tests each value is taken once by goroutines taking at the same time
+// The values are taken twice without locking on more than one cpu, such as -cpu 4.
+func testOrderedMapTake(t *testing.T, take func(m *OrderedMap) (interface{}, bool)) {
+	const n = 10000
+
+	m := NewOrderedMap()
+	for i := 0; i < n; i++ {
+		m.Set(i, i)
+	}
+
+	start := make(chan struct{})
+	results := make(chan []interface{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			<-start
+
+			var vals []interface{}
+			for {
+				val, ok := take(m)
+				if !ok {
+					break
+				}
+
+				vals = append(vals, val)
+			}
+
+			results <- vals
+		}()
+	}
+
+	close(start)
+
+	taken := make(map[interface{}]bool)
+	for i := 0; i < 8; i++ {
+		for _, val := range <-results {
+			if taken[val] {
+				t.Fatalf("%v is taken twice", val)
+			}
+
+			taken[val] = true
+		}
+	}
+
+	if len(taken) != n {
+		t.Fatalf("%d values are taken, want %d", len(taken), n)
+	}
+}
+
+func TestQueueClear(t *testing.T) {
+	q := NewQueue()
+	for i := 0; i < 5; i++ {
+		q.Add(i)
+	}
+
+	q.Clear()
+
+	if !q.IsEmpty() {
+		t.Fatalf("size is %d after Clear, want 0", q.Size())
+	}
+
+	q.Add(5)
+
+	val, ok := q.Peek()
+	if !ok || val != 5 {
+		t.Fatalf("peeked %v, want 5", val)
+	}
+}
+
+func TestQueueConcurrentClear(t *testing.T) {
+	q := NewQueue()
+
+	// Values are added while the queue is cleared, and the ones left are in the order they were added
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				q.Add(j)
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		q.Clear()
+	}
+
+	wg.Wait()
+
+	want := q.Size()
+	for i := 0; i < 5; i++ {
+		q.Add(i)
+	}
+
+	n := 0
+	for !q.IsEmpty() {
+		val, _ := q.Poll()
+		if n >= want && val != n-want {
+			t.Fatalf("polled %v at %d, want %d", val, n, n-want)
+		}
+
+		n++
+	}
+
+	if n != want+5 {
+		t.Fatalf("polled %d values, want %d", n, want+5)
+	}
+}